@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"golang.org/x/oauth2/google"
+	"google-calendar-sample/auth"
+	"google-calendar-sample/gcal"
+	"log"
+	"os"
+)
+
+// スタッフごとのGoogleカレンダー連携を管理する。
+//
+// go run ./accounts list
+// go run ./accounts connect staff1@example.com
+// go run ./accounts disconnect staff1@example.com
+// go run ./accounts calendars staff1@example.com
+func main() {
+	dir := flag.String("dir", "tokens", "directory where per-user tokens are stored")
+	clientSecret := flag.String("client-secret", "", "path to the OAuth client secret file")
+	flag.Parse()
+
+	ctx := context.Background()
+	b, err := auth.ResolveClientSecret(*clientSecret)
+	if err != nil {
+		log.Fatalf("Unable to read client secret file: %v", err)
+	}
+	// 予約の登録（events）とカレンダーの一覧（calendarList.readonly）に必要な最小限のscopeのみ要求する。
+	config, err := google.ConfigFromJSON(b, auth.ScopesFor(auth.OpEvents, auth.OpCalendarListReadonly)...)
+	if err != nil {
+		log.Fatalf("Unable to parse client secret file to config: %v", err)
+	}
+	cipher, err := auth.CipherFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to load token encryption key: %v", err)
+	}
+	manager := auth.NewManager(config, &auth.DirStore{Dir: *dir, Cipher: cipher})
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+	}
+	switch args[0] {
+	case "list":
+		accounts, err := manager.Accounts()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, account := range accounts {
+			if account.Reason != "" {
+				fmt.Printf("%s\t%s\t%s\n", account.UserId, account.Status, account.Reason)
+				continue
+			}
+			fmt.Printf("%s\t%s\n", account.UserId, account.Status)
+		}
+	case "connect":
+		requireUser(args)
+		if err := manager.Connect(ctx, args[1], auth.NewLoopbackFlow(config)); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("connected: %s\n", args[1])
+	case "disconnect":
+		requireUser(args)
+		if err := manager.Disconnect(args[1]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("disconnected: %s\n", args[1])
+	case "calendars":
+		// トークンが使えるかの確認を兼ねて、カレンダーの一覧を表示する。
+		requireUser(args)
+		srv, err := manager.Service(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		calendars, err := gcal.ListCalendars(ctx, srv, gcal.ListOptions{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, c := range calendars {
+			fmt.Printf("%s\t%s\n", c.Id, c.Summary)
+		}
+	default:
+		usage()
+	}
+}
+
+func requireUser(args []string) {
+	if len(args) < 2 {
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: accounts [-dir tokens] list | connect <user> | disconnect <user> | calendars <user>")
+	os.Exit(2)
+}
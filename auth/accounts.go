@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+	"sort"
+	"sync"
+)
+
+// ErrReconsentRequired リフレッシュトークンが失効（invalid_grant）しており、再度同意が必要
+var ErrReconsentRequired = errors.New("re-consent required")
+
+// AccountStatus 連携アカウントの状態
+type AccountStatus string
+
+const (
+	AccountConnected      AccountStatus = "connected"
+	AccountNeedsReconsent AccountStatus = "needs_reconsent"
+)
+
+// Account 連携済みのアカウント
+type Account struct {
+	UserId string        `json:"userId"`
+	Status AccountStatus `json:"status"`
+	Reason string        `json:"reason,omitempty"` // 再同意が必要になった理由
+}
+
+// KeyLister 保存されているユーザーIDを列挙できるTokenStore（DirStore, SQLiteStore）
+type KeyLister interface {
+	Keys() ([]string, error)
+}
+
+// Manager スタッフごとのOAuth2トークンを管理し、ユーザーごとのcalendar.Serviceを作成する。
+//
+// トークンのリフレッシュでinvalid_grant（同意の取り消し・パスワード変更・長期間未使用など）が返った場合は
+// そのアカウントを再同意が必要な状態にし、以降のServiceの作成はErrReconsentRequiredを返す。
+type Manager struct {
+	Config *oauth2.Config
+	Store  TokenStore
+
+	mu       sync.Mutex
+	services map[string]*calendar.Service
+	flagged  map[string]string // key: ユーザーID value: 理由
+}
+
+// NewManager configの認可情報とstoreの保存先でManagerを作成する。
+func NewManager(config *oauth2.Config, store TokenStore) *Manager {
+	return &Manager{
+		Config:   config,
+		Store:    store,
+		services: make(map[string]*calendar.Service),
+		flagged:  make(map[string]string),
+	}
+}
+
+// Service userIdのトークンでcalendar.Serviceを作成する。作成済みのものは使い回す。
+// トークンが保存されていない場合はErrTokenNotFound、再同意が必要な場合はErrReconsentRequiredを返す。
+//
+// Serviceとトークンのリフレッシュは最初の呼び出し元のリクエストより長く使い回すため、呼び出し元のctxには紐付けない。
+// 呼び出しごとのキャンセルは srv.Events.List(...).Context(ctx) のように指定する。
+func (m *Manager) Service(userId string) (*calendar.Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if reason, ok := m.flagged[userId]; ok {
+		return nil, fmt.Errorf("user %s: %w: %s", userId, ErrReconsentRequired, reason)
+	}
+	if srv, ok := m.services[userId]; ok {
+		return srv, nil
+	}
+
+	tok, err := m.Store.Load(userId)
+	if err != nil {
+		return nil, fmt.Errorf("user %s: %w", userId, err)
+	}
+	// 別のプロセスで再同意が必要になったトークン、または必要なscopeが増えた場合は、追加の同意が必要な状態にする。
+	if reason := m.reconsentReason(tok); reason != "" {
+		m.flagged[userId] = reason
+		return nil, fmt.Errorf("user %s: %w: %s", userId, ErrReconsentRequired, reason)
+	}
+	ctx := context.Background()
+	src := PersistingTokenSource(m.Config.TokenSource(ctx, tok), m.Store, userId, tok)
+	src = &flaggingTokenSource{src: src, manager: m, userId: userId}
+	srv, err := calendar.NewService(ctx, option.WithTokenSource(src))
+	if err != nil {
+		return nil, fmt.Errorf("user %s: %w", userId, err)
+	}
+	m.services[userId] = srv
+	return srv, nil
+}
+
+// reconsentReason 保存されたトークンで再同意が必要な理由を返す。不要であれば空文字。
+func (m *Manager) reconsentReason(tok *oauth2.Token) string {
+	if reason := ReconsentReason(tok); reason != "" {
+		return reason
+	}
+	if granted, ok := GrantedScopes(tok); ok {
+		if missing := MissingScopes(granted, m.Config.Scopes); len(missing) > 0 {
+			return (&InsufficientScopeError{Missing: missing}).Error()
+		}
+	}
+	return ""
+}
+
+// Connect LoopbackFlowで同意を取り、userIdのトークンとして保存する。
+// 再同意が必要な状態だったアカウントも、このタイミングで連携済みに戻る。
+func (m *Manager) Connect(ctx context.Context, userId string, flow *LoopbackFlow) error {
+	// 再同意の場合もリフレッシュトークンを再発行してもらうため、毎回同意画面を出す。
+	consentFlow := *flow
+	consentFlow.AuthCodeOptions = append([]oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("prompt", "consent"),
+		oauth2.SetAuthURLParam("include_granted_scopes", "true"),
+	}, flow.AuthCodeOptions...)
+	tok, err := consentFlow.Token(ctx)
+	if err != nil {
+		return err
+	}
+	if err := m.Store.Save(userId, tok); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.flagged, userId)
+	delete(m.services, userId)
+	return nil
+}
+
+// Disconnect userIdのトークンを削除する。
+func (m *Manager) Disconnect(userId string) error {
+	m.mu.Lock()
+	delete(m.flagged, userId)
+	delete(m.services, userId)
+	m.mu.Unlock()
+	return m.Store.Delete(userId)
+}
+
+// Accounts 連携済みのアカウントをユーザーIDの昇順で返す。
+// 再同意が必要な状態はトークンと一緒に保存されているため、別のプロセスで判明したものも含む。
+// StoreがKeyListerを実装していない場合はエラー。
+func (m *Manager) Accounts() ([]Account, error) {
+	lister, ok := m.Store.(KeyLister)
+	if !ok {
+		return nil, errors.New("token store cannot list accounts")
+	}
+	keys, err := lister.Keys()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	accounts := make([]Account, 0, len(keys))
+	for _, key := range keys {
+		account := Account{UserId: key, Status: AccountConnected}
+		m.mu.Lock()
+		reason, ok := m.flagged[key]
+		m.mu.Unlock()
+		if !ok {
+			// 読み込めない（壊れた・復号できない）トークンも連携し直す必要がある。
+			if tok, err := m.Store.Load(key); err != nil {
+				reason = err.Error()
+			} else {
+				reason = m.reconsentReason(tok)
+			}
+		}
+		if reason != "" {
+			account.Status = AccountNeedsReconsent
+			account.Reason = reason
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// flag 再同意が必要な状態にし、トークンと一緒に保存する。
+func (m *Manager) flag(userId, reason string) error {
+	m.mu.Lock()
+	m.flagged[userId] = reason
+	delete(m.services, userId)
+	m.mu.Unlock()
+
+	tok, err := m.Store.Load(userId)
+	if err != nil {
+		return err
+	}
+	return m.Store.Save(userId, WithReconsentReason(tok, reason))
+}
+
+// flaggingTokenSource トークンのリフレッシュでinvalid_grantが返ったらManagerに通知する。
+type flaggingTokenSource struct {
+	src     oauth2.TokenSource
+	manager *Manager
+	userId  string
+}
+
+func (s *flaggingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if err != nil && IsInvalidGrant(err) {
+		if flagErr := s.manager.flag(s.userId, "invalid_grant"); flagErr != nil {
+			return nil, fmt.Errorf("user %s: %w: %v (unable to persist: %v)", s.userId, ErrReconsentRequired, err, flagErr)
+		}
+		return nil, fmt.Errorf("user %s: %w: %v", s.userId, ErrReconsentRequired, err)
+	}
+	return tok, err
+}
+
+// IsInvalidGrant トークンエンドポイントがinvalid_grantを返したエラーかを判定する。
+// see: https://datatracker.ietf.org/doc/html/rfc6749#section-5.2
+func IsInvalidGrant(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return false
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(retrieveErr.Body, &body) != nil {
+		return false
+	}
+	return body.Error == "invalid_grant"
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"errors"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestManager(tokenURL string, store TokenStore) *Manager {
+	return NewManager(&oauth2.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Endpoint:     oauth2.Endpoint{TokenURL: tokenURL},
+		Scopes:       []string{calendar.CalendarEventsScope, calendar.CalendarReadonlyScope},
+	}, store)
+}
+
+func testToken(scope string) *oauth2.Token {
+	tok := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: time.Now().Add(-time.Hour)}
+	return tok.WithExtra(map[string]interface{}{"scope": scope})
+}
+
+// TestManagerPersistsReconsent invalid_grantで再同意が必要になった状態が、別のManager（別のプロセス）からも分かることを確認する。
+func TestManagerPersistsReconsent(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`))
+	}))
+	defer tokenServer.Close()
+
+	store := &DirStore{Dir: t.TempDir()}
+	if err := store.Save("a@example.com", testToken(calendar.CalendarScope)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("b@example.com", testToken(calendar.CalendarScope)); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestManager(tokenServer.URL, store)
+	srv, err := m.Service("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 期限切れのトークンのリフレッシュでinvalid_grantになる。
+	if _, err := srv.CalendarList.List().Do(); err == nil || !strings.Contains(err.Error(), ErrReconsentRequired.Error()) {
+		t.Fatalf("err = %v, want re-consent required", err)
+	}
+	if _, err := m.Service("a@example.com"); !errors.Is(err, ErrReconsentRequired) {
+		t.Errorf("Service after invalid_grant: err = %v", err)
+	}
+
+	restarted := newTestManager(tokenServer.URL, store)
+	accounts, err := restarted.Accounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Account{
+		{UserId: "a@example.com", Status: AccountNeedsReconsent, Reason: "invalid_grant"},
+		{UserId: "b@example.com", Status: AccountConnected},
+	}
+	if len(accounts) != len(want) {
+		t.Fatalf("accounts = %v, want %v", accounts, want)
+	}
+	for i := range want {
+		if accounts[i] != want[i] {
+			t.Errorf("accounts[%d] = %+v, want %+v", i, accounts[i], want[i])
+		}
+	}
+	if _, err := restarted.Service("a@example.com"); !errors.Is(err, ErrReconsentRequired) {
+		t.Errorf("Service in new manager: err = %v", err)
+	}
+
+	// 再同意の状態を保存してもscopeは引き継ぐ。
+	tok, err := store.Load("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if granted, _ := GrantedScopes(tok); len(granted) != 1 || granted[0] != calendar.CalendarScope {
+		t.Errorf("granted scopes = %v", granted)
+	}
+
+	// 連携し直した（新しいトークンを保存した）アカウントは連携済みに戻る。
+	if err := store.Save("a@example.com", testToken(calendar.CalendarScope)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestManager(tokenServer.URL, store).Service("a@example.com"); err != nil {
+		t.Errorf("Service after reconnect: %v", err)
+	}
+}
+
+func TestManagerAccountsScopes(t *testing.T) {
+	store := &DirStore{Dir: t.TempDir()}
+	tokens := map[string]string{
+		"broad@example.com":    calendar.CalendarScope,
+		"minimal@example.com":  calendar.CalendarEventsScope + " " + calendar.CalendarReadonlyScope,
+		"readonly@example.com": calendar.CalendarReadonlyScope,
+	}
+	for key, scope := range tokens {
+		if err := store.Save(key, testToken(scope)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := newTestManager("http://localhost/token", store)
+	accounts, err := m.Accounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := make(map[string]AccountStatus)
+	for _, a := range accounts {
+		status[a.UserId] = a.Status
+	}
+	want := map[string]AccountStatus{
+		"broad@example.com":    AccountConnected,
+		"minimal@example.com":  AccountConnected,
+		"readonly@example.com": AccountNeedsReconsent,
+	}
+	for key, w := range want {
+		if status[key] != w {
+			t.Errorf("%s: status = %s, want %s", key, status[key], w)
+		}
+	}
+	if _, err := m.Service("broad@example.com"); err != nil {
+		t.Errorf("Service with broader scope: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// TokenKeyEnv トークン暗号化の鍵（32byteをbase64にしたもの）を入れる環境変数
+	TokenKeyEnv = "CALENDAR_TOKEN_KEY"
+	// TokenKeyFileEnv トークン暗号化の鍵ファイルのパスを入れる環境変数
+	TokenKeyFileEnv = "CALENDAR_TOKEN_KEYFILE"
+)
+
+// Cipher AES-256-GCMでトークンを暗号化する。
+// 暗号文は nonce(12byte) + ciphertext の形式で保存する。
+// ユーザーIDを追加データ（AAD）にするため、別のユーザーの行・ファイルに暗号文をコピーしても復号できない。
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher 32byteの鍵からCipherを作成する。
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("token key must be 32 bytes: got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// CipherFromEnv 環境変数 CALENDAR_TOKEN_KEY か、CALENDAR_TOKEN_KEYFILE の鍵ファイルからCipherを作成する。
+// どちらも設定されていない場合は nil, nil を返す（暗号化しない）。
+func CipherFromEnv() (*Cipher, error) {
+	if v := os.Getenv(TokenKeyEnv); v != "" {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("%s is not valid base64: %w", TokenKeyEnv, err)
+		}
+		return NewCipher(key)
+	}
+	if path := os.Getenv(TokenKeyFileEnv); path != "" {
+		return CipherFromKeyFile(path)
+	}
+	return nil, nil
+}
+
+// CipherFromKeyFile base64の鍵が書かれたファイルからCipherを作成する。
+func CipherFromKeyFile(path string) (*Cipher, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s is not valid base64: %w", path, err)
+	}
+	return NewCipher(key)
+}
+
+// Encrypt userIdのトークンとして暗号化する。
+func (c *Cipher) Encrypt(userId string, plain []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plain, []byte(userId)), nil
+}
+
+// Decrypt userIdのトークンとして復号する。暗号化したときとuserIdが異なる場合はエラーになる。
+func (c *Cipher) Decrypt(userId string, b []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(b) < n {
+		return nil, errors.New("encrypted token is too short")
+	}
+	plain, err := c.aead.Open(nil, b[:n], b[n:], []byte(userId))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt token (wrong key or user?): %w", err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"bytes"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCipherUserId(t *testing.T) {
+	c, err := NewCipher(bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCipher(bytes.Repeat([]byte{0x43}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		decrypter *Cipher
+		userId    string
+		wantErr   bool
+	}{
+		{name: "same user", decrypter: c, userId: "a@example.com"},
+		{name: "other user", decrypter: c, userId: "b@example.com", wantErr: true},
+		{name: "empty user", decrypter: c, userId: "", wantErr: true},
+		{name: "other key", decrypter: other, userId: "a@example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := c.Encrypt("a@example.com", []byte("token"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := tt.decrypter.Decrypt(tt.userId, b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && string(plain) != "token" {
+				t.Errorf("Decrypt() = %q, want %q", plain, "token")
+			}
+		})
+	}
+}
+
+// TestDirStoreCopiedToken 別のユーザーのファイルにコピーした暗号文は読めない。
+func TestDirStoreCopiedToken(t *testing.T) {
+	c, err := NewCipher(bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &DirStore{Dir: t.TempDir(), Cipher: c}
+	if err := store.Save("a@example.com", &oauth2.Token{AccessToken: "access-a"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(store.Dir, "a@example.com.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(store.Dir, "b@example.com.json"), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if tok, err := store.Load("a@example.com"); err != nil || tok.AccessToken != "access-a" {
+		t.Errorf("Load(a) = %v, %v", tok, err)
+	}
+	if tok, err := store.Load("b@example.com"); err == nil {
+		t.Errorf("Load(b) = %v, want error", tok)
+	}
+}
@@ -0,0 +1,161 @@
+// Package auth Google APIの認証（OAuth2 / サービスアカウント）をまとめたもの
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultLoopbackTimeout ブラウザでの同意を待つ時間のデフォルト
+const DefaultLoopbackTimeout = 5 * time.Minute
+
+// LoopbackFlow ローカルのHTTPサーバーでリダイレクトを受け取るOAuth2の認可フロー
+//
+// 認可コードを手でコピーするOOBフローは廃止されたため、
+// 127.0.0.1のランダムなポートで待ち受け、リダイレクトされた認可コードを自動でトークンに交換する。
+// stateはリクエストごとのランダム値で検証し、PKCE（S256）で認可コードの横取りを防ぐ。
+// see: https://developers.google.com/identity/protocols/oauth2/native-app
+type LoopbackFlow struct {
+	Config *oauth2.Config
+	// Timeout ブラウザでの同意を待つ時間（0以下はDefaultLoopbackTimeout）
+	Timeout time.Duration
+	// OpenURL 認可URLをユーザーに開いてもらう処理（nilの場合は標準出力に表示する）
+	OpenURL func(authURL string) error
+	// AuthCodeOptions 認可URLに追加するパラメータ ex: oauth2.SetAuthURLParam("login_hint", "user@example.com")
+	AuthCodeOptions []oauth2.AuthCodeOption
+}
+
+// NewLoopbackFlow デフォルトの設定でLoopbackFlowを作成する。
+func NewLoopbackFlow(config *oauth2.Config) *LoopbackFlow {
+	return &LoopbackFlow{Config: config, Timeout: DefaultLoopbackTimeout}
+}
+
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// Token ブラウザでの同意を経てトークンを取得する。
+func (f *LoopbackFlow) Token(ctx context.Context) (*oauth2.Token, error) {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = DefaultLoopbackTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on loopback: %w", err)
+	}
+	defer listener.Close()
+
+	// 元のConfigを書き換えないようコピーしてリダイレクト先を差し替える。
+	config := *f.Config
+	config.RedirectURL = fmt.Sprintf("http://%s/", listener.Addr().String())
+
+	state, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(chan callbackResult, 1)
+	server := &http.Server{Handler: callbackHandler(state, results)}
+	go server.Serve(listener)
+	defer server.Close()
+
+	opts := append([]oauth2.AuthCodeOption{
+		oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", codeChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}, f.AuthCodeOptions...)
+	authURL := config.AuthCodeURL(state, opts...)
+	if f.OpenURL != nil {
+		if err := f.OpenURL(authURL); err != nil {
+			return nil, err
+		}
+	} else {
+		fmt.Printf("Go to the following link in your browser to authorize this app: \n%v\n", authURL)
+	}
+
+	var result callbackResult
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("authorization was not completed: %w", ctx.Err())
+	case result = <-results:
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	tok, err := config.Exchange(ctx, result.code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		return nil, fmt.Errorf("unable to exchange authorization code: %w", err)
+	}
+	return tok, nil
+}
+
+// callbackHandler リダイレクトを受け取り、stateを検証して認可コードをresultsに送る。
+// 最初の1回分のみ受け付ける。
+func callbackHandler(state string, results chan<- callbackResult) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		var result callbackResult
+		switch {
+		case subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state)) != 1:
+			// 別のリクエストから来たリダイレクトは無視する（フローは続ける）。
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		case q.Get("error") != "":
+			result.err = fmt.Errorf("authorization failed: %s", q.Get("error"))
+		case q.Get("code") == "":
+			result.err = errors.New("authorization failed: no code in redirect")
+		default:
+			result.code = q.Get("code")
+		}
+
+		select {
+		case results <- result:
+		default:
+			http.Error(w, "authorization already handled", http.StatusConflict)
+			return
+		}
+		if result.err != nil {
+			http.Error(w, result.err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, "Authorization completed. You can close this window.")
+	})
+}
+
+// randomString n byteの乱数をURLセーフなbase64で返す。
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// codeChallenge PKCEのS256のcode_challengeを返す。
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
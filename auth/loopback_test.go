@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallbackHandler(t *testing.T) {
+	const state = "state-1"
+	tests := []struct {
+		name       string
+		target     string
+		handled    bool // すでに1回分を受け付けている
+		wantStatus int
+		wantResult bool // resultsに送られるか
+		wantCode   string
+		wantErr    string
+	}{
+		{name: "success", target: "/?state=state-1&code=code-1", wantStatus: http.StatusOK, wantResult: true, wantCode: "code-1"},
+		{name: "other path", target: "/favicon.ico?state=state-1&code=code-1", wantStatus: http.StatusNotFound},
+		{name: "state mismatch", target: "/?state=other&code=code-1", wantStatus: http.StatusBadRequest},
+		{name: "no state", target: "/?code=code-1", wantStatus: http.StatusBadRequest},
+		{
+			name:       "error param",
+			target:     "/?state=state-1&error=access_denied",
+			wantStatus: http.StatusBadRequest, wantResult: true, wantErr: "authorization failed: access_denied",
+		},
+		{
+			name:       "no code",
+			target:     "/?state=state-1",
+			wantStatus: http.StatusBadRequest, wantResult: true, wantErr: "authorization failed: no code in redirect",
+		},
+		{name: "second callback", target: "/?state=state-1&code=code-2", handled: true, wantStatus: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := make(chan callbackResult, 1)
+			if tt.handled {
+				results <- callbackResult{code: "code-1"}
+			}
+			rec := httptest.NewRecorder()
+			callbackHandler(state, results).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.handled {
+				// 最初の結果はそのまま残る。
+				if got := <-results; got.code != "code-1" {
+					t.Errorf("first result = %+v", got)
+				}
+				return
+			}
+			select {
+			case got := <-results:
+				if !tt.wantResult {
+					t.Fatalf("result = %+v, want none", got)
+				}
+				if got.code != tt.wantCode {
+					t.Errorf("code = %q, want %q", got.code, tt.wantCode)
+				}
+				if gotErr := errorString(got.err); gotErr != tt.wantErr {
+					t.Errorf("err = %q, want %q", gotErr, tt.wantErr)
+				}
+			default:
+				if tt.wantResult {
+					t.Error("no result")
+				}
+			}
+		})
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 Appendix B
+	if got := codeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("codeChallenge() = %q", got)
+	}
+}
+
+// TestLoopbackFlowToken 認可URLのS256のcode_challengeと、トークン交換時のcode_verifierが対応することを確認する。
+func TestLoopbackFlowToken(t *testing.T) {
+	// 認可URLのcode_challengeをトークンのエンドポイントに渡す。
+	challenges := make(chan string, 1)
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if got := r.PostForm.Get("code"); got != "code-1" {
+			t.Errorf("code = %q", got)
+		}
+		if got, want := codeChallenge(r.PostForm.Get("code_verifier")), <-challenges; got != want {
+			t.Errorf("challenge of code_verifier = %q, want %q", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access", "refresh_token": "refresh", "token_type": "Bearer", "expires_in": 3600})
+	}))
+	defer tokenServer.Close()
+
+	flow := NewLoopbackFlow(&oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{AuthURL: "https://accounts.example.com/auth", TokenURL: tokenServer.URL},
+	})
+	flow.Timeout = 10 * time.Second
+	flow.OpenURL = func(authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		q := u.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("access_type") != "offline" {
+			t.Errorf("auth URL = %s", authURL)
+		}
+		challenges <- q.Get("code_challenge")
+		redirect := q.Get("redirect_uri")
+		if !strings.HasPrefix(redirect, "http://127.0.0.1:") {
+			t.Errorf("redirect_uri = %q", redirect)
+		}
+		// ブラウザの代わりにリダイレクト先を開く。
+		go func() {
+			resp, err := http.Get(redirect + "?" + url.Values{"state": {q.Get("state")}, "code": {"code-1"}}.Encode())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+		return nil
+	}
+	tok, err := flow.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "access" || tok.RefreshToken != "refresh" {
+		t.Errorf("Token() = %+v", tok)
+	}
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"golang.org/x/oauth2/google"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// CredentialsJSONEnv サービスアカウントの鍵のJSONをそのまま入れる環境変数
+	CredentialsJSONEnv = "CALENDAR_CREDENTIALS_JSON"
+	// ApplicationCredentialsEnv サービスアカウントの鍵ファイルのパス（ADCと同じ環境変数）
+	ApplicationCredentialsEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+	// ClientSecretEnv OAuthクライアント（client_secret.json）のパスを入れる環境変数
+	ClientSecretEnv = "CALENDAR_CLIENT_SECRET"
+	// ClientSecretJSONEnv OAuthクライアントのJSONをそのまま入れる環境変数
+	ClientSecretJSONEnv = "CALENDAR_CLIENT_SECRET_JSON"
+
+	DefaultServiceAccountPath = "credentials/service_account.json"
+	DefaultClientSecretPath   = "credentials/client_secret.json"
+)
+
+// Resolver サービスアカウントの認証情報を以下の順で探す。
+//
+// 1. -credentials フラグのファイル
+// 2. -credentials-json フラグ / CALENDAR_CREDENTIALS_JSON のJSON
+// 3. GOOGLE_APPLICATION_CREDENTIALS のファイル
+// 4. credentials/service_account.json（カレントディレクトリ、実行ファイルのディレクトリの順）
+// 5. Application Default Credentials（gcloudのユーザー認証 / GCE・Cloud Runのメタデータサーバー）
+//
+// 見つからない場合は、試したすべての場所と理由をエラーに含める。
+type Resolver struct {
+	Path    string // -credentials
+	JSON    string // -credentials-json
+	Subject string // -subject ドメイン全体の委任で振る舞うユーザー
+	UseADC  bool   // -adc=false でADCを使わない
+}
+
+// NewResolver ADCも探すResolverを作成する。
+func NewResolver() *Resolver {
+	return &Resolver{UseADC: true}
+}
+
+// RegisterFlags -credentials, -credentials-json, -subject, -adc フラグを登録する。
+func (r *Resolver) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&r.Path, "credentials", r.Path, "path to a service account key file")
+	fs.StringVar(&r.JSON, "credentials-json", r.JSON, "service account key JSON (also read from $"+CredentialsJSONEnv+")")
+	fs.StringVar(&r.Subject, "subject", r.Subject, "Workspace user email to impersonate via domain-wide delegation")
+	fs.BoolVar(&r.UseADC, "adc", r.UseADC, "fall back to Application Default Credentials")
+}
+
+// triedSources 探した場所と見つからなかった理由
+type triedSources []string
+
+func (t *triedSources) add(source, reason string) {
+	*t = append(*t, fmt.Sprintf("%s: %s", source, reason))
+}
+
+func (t triedSources) err(what string) error {
+	return fmt.Errorf("no %s found; tried:\n  %s", what, strings.Join(t, "\n  "))
+}
+
+// Credentials 認証情報を探し、scopesのCredentialsを作成する。
+// 見つかった場所の鍵が壊れている場合は、次の場所を探さずにエラーにする。
+func (r *Resolver) Credentials(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+	params := google.CredentialsParams{Scopes: scopes, Subject: r.Subject}
+	var tried triedSources
+
+	fromFile := func(source, path string) (*google.Credentials, error) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		c, err := google.CredentialsFromJSONWithParams(ctx, b, params)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", source, path, err)
+		}
+		return c, nil
+	}
+
+	if r.Path != "" {
+		return fromFile("-credentials", r.Path)
+	}
+	tried.add("-credentials", "not set")
+
+	inline, inlineSource := r.JSON, "-credentials-json"
+	if inline == "" {
+		inline, inlineSource = os.Getenv(CredentialsJSONEnv), "$"+CredentialsJSONEnv
+	}
+	if inline != "" {
+		c, err := google.CredentialsFromJSONWithParams(ctx, []byte(inline), params)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", inlineSource, err)
+		}
+		return c, nil
+	}
+	tried.add("-credentials-json / $"+CredentialsJSONEnv, "not set")
+
+	if path := os.Getenv(ApplicationCredentialsEnv); path != "" {
+		return fromFile("$"+ApplicationCredentialsEnv, path)
+	}
+	tried.add("$"+ApplicationCredentialsEnv, "not set")
+
+	for _, path := range defaultPaths(DefaultServiceAccountPath) {
+		if _, err := os.Stat(path); err != nil {
+			tried.add(path, reason(err))
+			continue
+		}
+		return fromFile("default path", path)
+	}
+
+	if r.UseADC {
+		c, err := google.FindDefaultCredentialsWithParams(ctx, params)
+		if err == nil {
+			return c, nil
+		}
+		tried.add("Application Default Credentials", err.Error())
+	} else {
+		tried.add("Application Default Credentials", "disabled by -adc=false")
+	}
+	return nil, tried.err("Google credentials")
+}
+
+// ResolveClientSecret OAuthクライアント（client_secret.json）の中身を以下の順で探す。
+//
+// 1. path（-client-secret フラグ）
+// 2. CALENDAR_CLIENT_SECRET_JSON のJSON
+// 3. CALENDAR_CLIENT_SECRET のファイル
+// 4. credentials/client_secret.json（カレントディレクトリ、実行ファイルのディレクトリの順）
+func ResolveClientSecret(path string) ([]byte, error) {
+	var tried triedSources
+	if path != "" {
+		return ioutil.ReadFile(path)
+	}
+	tried.add("-client-secret", "not set")
+
+	if v := os.Getenv(ClientSecretJSONEnv); v != "" {
+		return []byte(v), nil
+	}
+	tried.add("$"+ClientSecretJSONEnv, "not set")
+
+	if p := os.Getenv(ClientSecretEnv); p != "" {
+		return ioutil.ReadFile(p)
+	}
+	tried.add("$"+ClientSecretEnv, "not set")
+
+	for _, p := range defaultPaths(DefaultClientSecretPath) {
+		b, err := ioutil.ReadFile(p)
+		if err == nil {
+			return b, nil
+		}
+		tried.add(p, reason(err))
+	}
+	return nil, tried.err("OAuth client secret")
+}
+
+// defaultPaths 相対パスをカレントディレクトリと実行ファイルのディレクトリから探すための候補を返す。
+// go run の場合、実行ファイルは一時ディレクトリに作られるのでカレントディレクトリを優先する。
+func defaultPaths(rel string) []string {
+	paths := []string{rel}
+	if exe, err := os.Executable(); err == nil {
+		if p := filepath.Join(filepath.Dir(exe), rel); p != rel {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+func reason(err error) string {
+	if os.IsNotExist(err) {
+		return "no such file"
+	}
+	return err.Error()
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serviceAccountJSON client_emailで見つかった場所を区別できるサービスアカウントの鍵
+func serviceAccountJSON(name string) string {
+	return `{"type":"service_account","client_email":"` + name + `@example.iam.gserviceaccount.com","private_key":"dummy","token_uri":"https://oauth2.googleapis.com/token"}`
+}
+
+// chdir テストの間だけカレントディレクトリを移す。
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolverCredentialsOrder(t *testing.T) {
+	// 優先度の高い順
+	sources := []string{"flag-file", "flag-json", "env-json", "env-file", "default-path", "adc"}
+	for i, want := range sources {
+		t.Run(want, func(t *testing.T) {
+			dir := t.TempDir()
+			chdir(t, dir)
+			t.Setenv(CredentialsJSONEnv, "")
+			t.Setenv(ApplicationCredentialsEnv, "")
+			t.Setenv("HOME", dir)
+
+			// want以降の場所にだけ鍵を置く。
+			r := &Resolver{UseADC: true}
+			for _, source := range sources[i:] {
+				switch source {
+				case "flag-file":
+					r.Path = filepath.Join(dir, "flag.json")
+					writeFile(t, r.Path, serviceAccountJSON(source))
+				case "flag-json":
+					r.JSON = serviceAccountJSON(source)
+				case "env-json":
+					t.Setenv(CredentialsJSONEnv, serviceAccountJSON(source))
+				case "env-file":
+					path := filepath.Join(dir, "env.json")
+					writeFile(t, path, serviceAccountJSON(source))
+					t.Setenv(ApplicationCredentialsEnv, path)
+				case "default-path":
+					writeFile(t, filepath.Join(dir, DefaultServiceAccountPath), serviceAccountJSON(source))
+				case "adc":
+					writeFile(t, filepath.Join(dir, ".config", "gcloud", "application_default_credentials.json"), serviceAccountJSON(source))
+				}
+			}
+
+			c, err := r.Credentials(context.Background(), CalendarFreebusyScope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var key struct {
+				ClientEmail string `json:"client_email"`
+			}
+			if err := json.Unmarshal(c.JSON, &key); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSuffix(key.ClientEmail, "@example.iam.gserviceaccount.com"); got != want {
+				t.Errorf("credentials from %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestResolverCredentialsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, dir string, r *Resolver)
+		wantErr string
+	}{
+		{
+			// 見つかった場所の鍵が壊れている場合は次の場所を探さない。
+			name: "broken flag file",
+			setup: func(t *testing.T, dir string, r *Resolver) {
+				r.Path = filepath.Join(dir, "broken.json")
+				writeFile(t, r.Path, "{")
+				t.Setenv(CredentialsJSONEnv, serviceAccountJSON("env-json"))
+			},
+			wantErr: "-credentials ",
+		},
+		{
+			name: "missing flag file",
+			setup: func(t *testing.T, dir string, r *Resolver) {
+				r.Path = filepath.Join(dir, "missing.json")
+			},
+			wantErr: "missing.json",
+		},
+		{
+			name: "broken env json",
+			setup: func(t *testing.T, dir string, r *Resolver) {
+				t.Setenv(CredentialsJSONEnv, "not json")
+			},
+			wantErr: "$" + CredentialsJSONEnv + ": ",
+		},
+		{
+			name: "broken default path",
+			setup: func(t *testing.T, dir string, r *Resolver) {
+				writeFile(t, filepath.Join(dir, DefaultServiceAccountPath), "{")
+			},
+			wantErr: "default path " + DefaultServiceAccountPath,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			chdir(t, dir)
+			t.Setenv(CredentialsJSONEnv, "")
+			t.Setenv(ApplicationCredentialsEnv, "")
+			t.Setenv("HOME", dir)
+			r := &Resolver{UseADC: false}
+			tt.setup(t, dir, r)
+			_, err := r.Credentials(context.Background(), CalendarFreebusyScope)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Credentials() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestResolverCredentialsNotFound 見つからない場合は、試したすべての場所と理由をエラーに含める。
+func TestResolverCredentialsNotFound(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	t.Setenv(CredentialsJSONEnv, "")
+	t.Setenv(ApplicationCredentialsEnv, "")
+
+	_, err := (&Resolver{UseADC: false}).Credentials(context.Background(), CalendarFreebusyScope)
+	if err == nil {
+		t.Fatal("Credentials() error = nil")
+	}
+	lines := []string{
+		"no Google credentials found; tried:",
+		"-credentials: not set",
+		"-credentials-json / $" + CredentialsJSONEnv + ": not set",
+		"$" + ApplicationCredentialsEnv + ": not set",
+	}
+	for _, path := range defaultPaths(DefaultServiceAccountPath) {
+		lines = append(lines, path+": no such file")
+	}
+	lines = append(lines, "Application Default Credentials: disabled by -adc=false")
+	if want := strings.Join(lines, "\n  "); err.Error() != want {
+		t.Errorf("Credentials() error =\n%v\nwant\n%v", err, want)
+	}
+}
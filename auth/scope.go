@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"sort"
+	"strings"
+)
+
+// calendar/v3 のこのバージョンには定数がないため定義する。
+const (
+	// CalendarFreebusyScope 空き時間（FreeBusy）の参照のみ
+	CalendarFreebusyScope = "https://www.googleapis.com/auth/calendar.freebusy"
+	// CalendarEventsFreebusyScope 予定の空き時間（FreeBusy）の参照のみ
+	CalendarEventsFreebusyScope = "https://www.googleapis.com/auth/calendar.events.freebusy"
+)
+
+// Operation scopeを決めるための操作の種類
+type Operation string
+
+const (
+	OpFreeBusy             Operation = "freebusy"              // Freebusy.Query
+	OpEventsReadonly       Operation = "events.readonly"       // Events.List / Get
+	OpEvents               Operation = "events"                // Events.Insert / Update / Delete
+	OpCalendarListReadonly Operation = "calendarList.readonly" // CalendarList.List
+	OpCalendarList         Operation = "calendarList"          // CalendarList.Insert / Delete
+)
+
+// operationScopes 操作ごとに許可されるscope（先頭が最小権限）
+//
+// Freebusy.Queryは calendar.events / calendar.events.readonly では呼べない（403）。
+// see: https://developers.google.com/calendar/api/v3/reference/freebusy/query#auth
+var operationScopes = map[Operation][]string{
+	OpFreeBusy: {
+		CalendarFreebusyScope,
+		CalendarEventsFreebusyScope,
+		calendar.CalendarReadonlyScope,
+		calendar.CalendarScope,
+	},
+	OpEventsReadonly: {
+		calendar.CalendarEventsReadonlyScope,
+		calendar.CalendarReadonlyScope,
+		calendar.CalendarEventsScope,
+		calendar.CalendarScope,
+	},
+	OpEvents: {
+		calendar.CalendarEventsScope,
+		calendar.CalendarScope,
+	},
+	OpCalendarListReadonly: {
+		calendar.CalendarReadonlyScope,
+		calendar.CalendarScope,
+	},
+	OpCalendarList: {
+		calendar.CalendarScope,
+	},
+}
+
+// ScopesFor 操作に必要な最小限のscopeを返す。
+//
+// 許可されるscopeが少ない（制約の強い）操作から順に最小権限のscopeを選び、
+// すでに選んだscopeで足りる操作は追加しない。
+// ex: OpCalendarListReadonly, OpFreeBusy -> [calendar.readonly]（calendar.readonlyでFreeBusyも呼べる）
+func ScopesFor(ops ...Operation) []string {
+	sorted := append([]Operation{}, ops...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(operationScopes[sorted[i]]) < len(operationScopes[sorted[j]])
+	})
+	scopes := make([]string, 0, len(ops))
+	for _, op := range sorted {
+		if allows(op, scopes) {
+			continue
+		}
+		if candidates := operationScopes[op]; len(candidates) > 0 {
+			scopes = append(scopes, candidates[0])
+		}
+	}
+	return scopes
+}
+
+// MissingOperations grantedのscopeでは実行できない操作を返す。
+func MissingOperations(granted []string, ops ...Operation) []Operation {
+	missing := make([]Operation, 0)
+	for _, op := range ops {
+		if !allows(op, granted) {
+			missing = append(missing, op)
+		}
+	}
+	return missing
+}
+
+// MissingScopes requiredのscopeのうち、grantedのscopeで満たされないものを返す。
+//
+// 上位のscopeは下位のscopeを満たす（calendar は calendar.readonly・calendar.events を含む）。
+// 操作（Operation）に対応しないscopeは完全に一致する場合のみ満たされる。
+func MissingScopes(granted, required []string) []string {
+	missing := make([]string, 0)
+	for _, s := range required {
+		if !covered(s, granted) {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+// covered scopeで実行できる操作をすべてgrantedで実行できるかを判定する。
+func covered(scope string, granted []string) bool {
+	for _, g := range granted {
+		if g == scope {
+			return true
+		}
+	}
+	ops := scopeOperations(scope)
+	if len(ops) == 0 {
+		return false
+	}
+	for _, g := range granted {
+		if len(MissingOperations([]string{g}, ops...)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeOperations scopeで実行できる操作を返す。
+func scopeOperations(scope string) []Operation {
+	ops := make([]Operation, 0)
+	for op := range operationScopes {
+		if allows(op, []string{scope}) {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+func allows(op Operation, granted []string) bool {
+	for _, candidate := range operationScopes[op] {
+		for _, s := range granted {
+			if s == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GrantedScopes トークンに付与されたscopeを返す。
+// トークンのレスポンス（Extra("scope")）にscopeがない場合は ok = false。
+// FileStore等に保存したトークンも、保存時のscopeを復元している。
+func GrantedScopes(tok *oauth2.Token) (scopes []string, ok bool) {
+	v, _ := tok.Extra("scope").(string)
+	if v == "" {
+		return nil, false
+	}
+	return strings.Fields(v), true
+}
+
+// InsufficientScopeError 保存されたトークンのscopeが足りない
+type InsufficientScopeError struct {
+	Missing []string
+}
+
+func (e *InsufficientScopeError) Error() string {
+	return fmt.Sprintf("token is missing scopes: %s", strings.Join(e.Missing, " "))
+}
+
+// EnsureScopes tokのscopeで config.Scopes をすべて満たすか（上位のscopeを含む）を確認し、足りない場合はflowで追加の同意を取る。
+//
+// include_granted_scopes=true を付けるため、既に許可済みのscopeはそのままに足りないscopeのみ同意画面に出る（段階的な承認）。
+// scopeが不明なトークン（古い形式で保存されたもの）はそのまま返す。
+// see: https://developers.google.com/identity/protocols/oauth2/web-server#incrementalAuth
+func EnsureScopes(ctx context.Context, tok *oauth2.Token, flow *LoopbackFlow) (*oauth2.Token, bool, error) {
+	granted, ok := GrantedScopes(tok)
+	if !ok {
+		return tok, false, nil
+	}
+	missing := MissingScopes(granted, flow.Config.Scopes)
+	if len(missing) == 0 {
+		return tok, false, nil
+	}
+	incremental := *flow
+	incremental.AuthCodeOptions = append([]oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("include_granted_scopes", "true"),
+		oauth2.SetAuthURLParam("prompt", "consent"),
+	}, flow.AuthCodeOptions...)
+	newTok, err := incremental.Token(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("%v: %w", &InsufficientScopeError{Missing: missing}, err)
+	}
+	return newTok, true, nil
+}
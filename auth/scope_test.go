@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"google.golang.org/api/calendar/v3"
+	"reflect"
+	"testing"
+)
+
+func TestMissingScopes(t *testing.T) {
+	tests := []struct {
+		name     string
+		granted  []string
+		required []string
+		want     []string
+	}{
+		{
+			name:     "exact",
+			granted:  []string{calendar.CalendarReadonlyScope},
+			required: []string{calendar.CalendarReadonlyScope},
+			want:     []string{},
+		},
+		{
+			name:     "calendar covers narrower scopes",
+			granted:  []string{calendar.CalendarScope},
+			required: []string{calendar.CalendarReadonlyScope, calendar.CalendarEventsScope, CalendarFreebusyScope},
+			want:     []string{},
+		},
+		{
+			name:     "events covers events.readonly",
+			granted:  []string{calendar.CalendarEventsScope},
+			required: []string{calendar.CalendarEventsReadonlyScope},
+			want:     []string{},
+		},
+		{
+			// Freebusy.Queryは calendar.events / calendar.events.readonly では呼べない。
+			name:     "events does not cover freebusy",
+			granted:  []string{calendar.CalendarEventsScope, calendar.CalendarEventsReadonlyScope},
+			required: []string{CalendarFreebusyScope, CalendarEventsFreebusyScope},
+			want:     []string{CalendarFreebusyScope, CalendarEventsFreebusyScope},
+		},
+		{
+			name:     "readonly covers freebusy",
+			granted:  []string{calendar.CalendarReadonlyScope},
+			required: []string{CalendarFreebusyScope, CalendarEventsFreebusyScope},
+			want:     []string{},
+		},
+		{
+			// calendar.eventsではCalendarList.Listを呼べない。
+			name:     "events does not cover calendar.readonly",
+			granted:  []string{calendar.CalendarEventsScope},
+			required: []string{calendar.CalendarReadonlyScope},
+			want:     []string{calendar.CalendarReadonlyScope},
+		},
+		{
+			name:     "readonly does not cover events",
+			granted:  []string{calendar.CalendarReadonlyScope},
+			required: []string{calendar.CalendarEventsScope, calendar.CalendarEventsReadonlyScope},
+			want:     []string{calendar.CalendarEventsScope},
+		},
+		{
+			name:     "combined scopes",
+			granted:  []string{calendar.CalendarReadonlyScope, calendar.CalendarEventsScope},
+			required: []string{calendar.CalendarScope},
+			want:     []string{calendar.CalendarScope},
+		},
+		{
+			name:     "unknown scope requires exact match",
+			granted:  []string{calendar.CalendarScope, "openid"},
+			required: []string{"openid", "email"},
+			want:     []string{"email"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MissingScopes(tt.granted, tt.required); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MissingScopes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingOperations(t *testing.T) {
+	tests := []struct {
+		granted []string
+		ops     []Operation
+		want    []Operation
+	}{
+		{[]string{calendar.CalendarEventsScope}, []Operation{OpEvents, OpEventsReadonly, OpFreeBusy}, []Operation{OpFreeBusy}},
+		{[]string{calendar.CalendarEventsReadonlyScope}, []Operation{OpFreeBusy}, []Operation{OpFreeBusy}},
+		{[]string{CalendarEventsFreebusyScope}, []Operation{OpFreeBusy, OpEventsReadonly}, []Operation{OpEventsReadonly}},
+		{[]string{calendar.CalendarReadonlyScope}, []Operation{OpFreeBusy, OpCalendarListReadonly}, []Operation{}},
+	}
+	for _, tt := range tests {
+		if got := MissingOperations(tt.granted, tt.ops...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MissingOperations(%v, %v) = %v, want %v", tt.granted, tt.ops, got, tt.want)
+		}
+	}
+}
+
+func TestScopesFor(t *testing.T) {
+	tests := []struct {
+		ops  []Operation
+		want []string
+	}{
+		{[]Operation{OpFreeBusy}, []string{CalendarFreebusyScope}},
+		{[]Operation{OpEventsReadonly, OpFreeBusy}, []string{calendar.CalendarEventsReadonlyScope, CalendarFreebusyScope}},
+		{[]Operation{OpEvents, OpFreeBusy}, []string{calendar.CalendarEventsScope, CalendarFreebusyScope}},
+		{[]Operation{OpCalendarListReadonly, OpFreeBusy}, []string{calendar.CalendarReadonlyScope}},
+		{[]Operation{OpEvents, OpEventsReadonly}, []string{calendar.CalendarEventsScope}},
+		{[]Operation{OpCalendarList, OpEvents}, []string{calendar.CalendarScope}},
+	}
+	for _, tt := range tests {
+		if got := ScopesFor(tt.ops...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ScopesFor(%v) = %v, want %v", tt.ops, got, tt.want)
+		}
+		// ScopesForのscopeで、すべての操作を実行できる。
+		if missing := MissingOperations(ScopesFor(tt.ops...), tt.ops...); len(missing) > 0 {
+			t.Errorf("ScopesFor(%v) is missing %v", tt.ops, missing)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"context"
+	"golang.org/x/oauth2/google"
+	"io/ioutil"
+)
+
+// ServiceAccountCredentials サービスアカウントの鍵（service_account.json）からCredentialsを作成する。
+//
+// subjectにWorkspaceのユーザーのメールアドレスを指定すると、そのユーザーとしてAPIを呼び出す（ドメイン全体の委任）。
+// スタッフごとの同意なしに、そのユーザーのカレンダーを読み書きできる。
+// 空文字の場合はサービスアカウント自身として呼び出す。
+// 事前にWorkspaceの管理コンソールでサービスアカウントのクライアントIDとscopeを許可しておく必要がある。
+// see: https://developers.google.com/identity/protocols/oauth2/service-account#delegatingauthority
+func ServiceAccountCredentials(ctx context.Context, jsonKey []byte, subject string, scopes ...string) (*google.Credentials, error) {
+	return google.CredentialsFromJSONWithParams(ctx, jsonKey, google.CredentialsParams{
+		Scopes:  scopes,
+		Subject: subject,
+	})
+}
+
+// ServiceAccountCredentialsFromFile 鍵ファイルのパスからServiceAccountCredentialsを作成する。
+func ServiceAccountCredentialsFromFile(ctx context.Context, path, subject string, scopes ...string) (*google.Credentials, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ServiceAccountCredentials(ctx, b, subject, scopes...)
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"golang.org/x/oauth2"
+	"time"
+)
+
+// SQLiteStore SQLiteのテーブルにユーザーごとのトークンを保存する。
+//
+// database/sqlで開いたDBを渡す。ドライバー（mattn/go-sqlite3, modernc.org/sqlite など）は呼び出し側でimportする。
+// ex:
+// db, err := sql.Open("sqlite3", "tokens.db")
+// store := &auth.SQLiteStore{DB: db, Cipher: c}
+// err = store.Init()
+type SQLiteStore struct {
+	DB     *sql.DB
+	Cipher *Cipher
+}
+
+// Init トークン用のテーブルを作成する。
+func (s *SQLiteStore) Init() error {
+	_, err := s.DB.Exec(`CREATE TABLE IF NOT EXISTS oauth_tokens (
+	user_id    TEXT PRIMARY KEY,
+	token      BLOB NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`)
+	return err
+}
+
+func (s *SQLiteStore) Load(key string) (*oauth2.Token, error) {
+	var b []byte
+	err := s.DB.QueryRow(`SELECT token FROM oauth_tokens WHERE user_id = ?`, key).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeToken(s.Cipher, key, b)
+}
+
+func (s *SQLiteStore) Save(key string, token *oauth2.Token) error {
+	b, err := encodeToken(s.Cipher, key, token)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`INSERT INTO oauth_tokens (user_id, token, updated_at) VALUES (?, ?, ?)
+ON CONFLICT(user_id) DO UPDATE SET token = excluded.token, updated_at = excluded.updated_at`, key, b, time.Now().UTC())
+	return err
+}
+
+func (s *SQLiteStore) Delete(key string) error {
+	_, err := s.DB.Exec(`DELETE FROM oauth_tokens WHERE user_id = ?`, key)
+	return err
+}
+
+// Keys 保存されているユーザーIDの一覧を返す。
+func (s *SQLiteStore) Keys() ([]string, error) {
+	rows, err := s.DB.Query(`SELECT user_id FROM oauth_tokens ORDER BY user_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSQLite SQLiteStoreが発行するSQLだけを解釈するdatabase/sqlのドライバー
+// DSNごとにテーブル（user_id -> token）をメモリに持つ。
+type fakeSQLite struct {
+	mu     sync.Mutex
+	tables map[string]map[string][]byte // key: DSN
+}
+
+var fakeDriver = &fakeSQLite{tables: make(map[string]map[string][]byte)}
+
+func init() {
+	sql.Register("fakesqlite", fakeDriver)
+}
+
+func (d *fakeSQLite) Open(dsn string) (driver.Conn, error) {
+	return &fakeConn{driver: d, dsn: dsn}, nil
+}
+
+type fakeConn struct {
+	driver *fakeSQLite
+	dsn    string
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: strings.Join(strings.Fields(query), " ")}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return strings.Count(s.query, "?") }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	d := s.conn.driver
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	table := d.tables[s.conn.dsn]
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE IF NOT EXISTS oauth_tokens"):
+		if table == nil {
+			d.tables[s.conn.dsn] = make(map[string][]byte)
+		}
+		return driver.RowsAffected(0), nil
+	case table == nil:
+		return nil, errors.New("no such table: oauth_tokens")
+	case strings.HasPrefix(s.query, "INSERT INTO oauth_tokens (user_id, token, updated_at) VALUES (?, ?, ?) ON CONFLICT(user_id) DO UPDATE"):
+		if _, ok := args[2].(time.Time); !ok {
+			return nil, fmt.Errorf("updated_at: unexpected %T", args[2])
+		}
+		table[args[0].(string)] = append([]byte(nil), args[1].([]byte)...)
+		return driver.RowsAffected(1), nil
+	case s.query == "DELETE FROM oauth_tokens WHERE user_id = ?":
+		key := args[0].(string)
+		if _, ok := table[key]; !ok {
+			return driver.RowsAffected(0), nil
+		}
+		delete(table, key)
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unexpected exec: %s", s.query)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	d := s.conn.driver
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	table := d.tables[s.conn.dsn]
+	if table == nil {
+		return nil, errors.New("no such table: oauth_tokens")
+	}
+	switch s.query {
+	case "SELECT token FROM oauth_tokens WHERE user_id = ?":
+		rows := &fakeRows{columns: []string{"token"}}
+		if b, ok := table[args[0].(string)]; ok {
+			rows.values = [][]driver.Value{{append([]byte(nil), b...)}}
+		}
+		return rows, nil
+	case "SELECT user_id FROM oauth_tokens ORDER BY user_id":
+		keys := make([]string, 0, len(table))
+		for key := range table {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		rows := &fakeRows{columns: []string{"user_id"}}
+		for _, key := range keys {
+			rows.values = append(rows.values, []driver.Value{key})
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("unexpected query: %s", s.query)
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func openFakeSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("fakesqlite", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLiteStore(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		cipher *Cipher
+	}{
+		{"plain", nil},
+		{"encrypted", c},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openFakeSQLite(t)
+			store := &SQLiteStore{DB: db, Cipher: tt.cipher}
+			if err := store.Init(); err != nil {
+				t.Fatal(err)
+			}
+			// 2回目のInitでも既存のテーブルを壊さない。
+			if err := store.Init(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := store.Load("a@example.com"); !errors.Is(err, ErrTokenNotFound) {
+				t.Errorf("Load before Save: err = %v, want ErrTokenNotFound", err)
+			}
+
+			expiry := time.Date(2022, 4, 18, 9, 0, 0, 0, time.UTC)
+			tok := (&oauth2.Token{AccessToken: "access-1", RefreshToken: "refresh", TokenType: "Bearer", Expiry: expiry}).
+				WithExtra(map[string]interface{}{"scope": "https://www.googleapis.com/auth/calendar.events"})
+			if err := store.Save("a@example.com", tok); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Save("b@example.com", &oauth2.Token{AccessToken: "access-b"}); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := store.Load("a@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.AccessToken != "access-1" || got.RefreshToken != "refresh" || got.TokenType != "Bearer" || !got.Expiry.Equal(expiry) {
+				t.Errorf("Load = %+v", got)
+			}
+			if scopes, ok := GrantedScopes(got); !ok || len(scopes) != 1 || scopes[0] != "https://www.googleapis.com/auth/calendar.events" {
+				t.Errorf("scopes = %v, %v", scopes, ok)
+			}
+
+			// 暗号化した場合は平文のトークンが保存されない。
+			fakeDriver.mu.Lock()
+			stored := fakeDriver.tables[t.Name()]["a@example.com"]
+			fakeDriver.mu.Unlock()
+			if plain := bytes.Contains(stored, []byte("access-1")); plain != (tt.cipher == nil) {
+				t.Errorf("stored token contains plaintext = %v: %q", plain, stored)
+			}
+
+			// 同じユーザーは上書きする。
+			if err := store.Save("a@example.com", &oauth2.Token{AccessToken: "access-2", RefreshToken: "refresh"}); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := store.Load("a@example.com"); err != nil || got.AccessToken != "access-2" {
+				t.Errorf("Load after overwrite = %+v, %v", got, err)
+			}
+
+			keys, err := store.Keys()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(keys, ",") != "a@example.com,b@example.com" {
+				t.Errorf("Keys = %v", keys)
+			}
+
+			if err := store.Delete("a@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Load("a@example.com"); !errors.Is(err, ErrTokenNotFound) {
+				t.Errorf("Load after Delete: err = %v, want ErrTokenNotFound", err)
+			}
+			// 存在しないユーザーの削除はエラーにしない。
+			if err := store.Delete("a@example.com"); err != nil {
+				t.Errorf("Delete twice: %v", err)
+			}
+			if keys, err := store.Keys(); err != nil || strings.Join(keys, ",") != "b@example.com" {
+				t.Errorf("Keys after Delete = %v, %v", keys, err)
+			}
+		})
+	}
+}
+
+func TestSQLiteStoreWrongKey(t *testing.T) {
+	c1, err := NewCipher(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := NewCipher(bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := openFakeSQLite(t)
+	store := &SQLiteStore{DB: db, Cipher: c1}
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("a@example.com", &oauth2.Token{AccessToken: "access"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 別の鍵・平文として読むとエラーになる。
+	for _, other := range []*SQLiteStore{{DB: db, Cipher: c2}, {DB: db}} {
+		if _, err := other.Load("a@example.com"); err == nil || errors.Is(err, ErrTokenNotFound) {
+			t.Errorf("Load with wrong cipher: err = %v", err)
+		}
+	}
+}
+
+// TestSQLiteStoreWithManager SQLiteStoreでも再同意の状態を保存・列挙できることを確認する。
+func TestSQLiteStoreWithManager(t *testing.T) {
+	c, err := NewCipher(bytes.Repeat([]byte{3}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &SQLiteStore{DB: openFakeSQLite(t), Cipher: c}
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	tok := testToken("https://www.googleapis.com/auth/calendar")
+	if err := store.Save("a@example.com", WithReconsentReason(tok, "invalid_grant")); err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := newTestManager("http://localhost/token", store).Accounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].Status != AccountNeedsReconsent || accounts[0].Reason != "invalid_grant" {
+		t.Errorf("accounts = %+v", accounts)
+	}
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// ErrTokenNotFound 保存されたトークンがない
+var ErrTokenNotFound = errors.New("token not found")
+
+// TokenStore OAuth2のトークンの保存先
+// keyはユーザーID。1ユーザーのみの保存先（FileStore）ではkeyは使わない。
+type TokenStore interface {
+	Load(key string) (*oauth2.Token, error)
+	Save(key string, token *oauth2.Token) error
+	Delete(key string) error
+}
+
+// FileStore 1つのファイルにトークンを保存する。
+// Cipherを指定するとAES-GCMで暗号化して保存する。1ユーザーのみのため、暗号化の追加データはkeyではなく空文字にする。
+type FileStore struct {
+	Path   string
+	Cipher *Cipher
+}
+
+func (s *FileStore) Load(key string) (*oauth2.Token, error) {
+	return readTokenFile(s.Path, s.Cipher, "")
+}
+
+func (s *FileStore) Save(key string, token *oauth2.Token) error {
+	return writeTokenFile(s.Path, s.Cipher, "", token)
+}
+
+func (s *FileStore) Delete(key string) error {
+	return removeTokenFile(s.Path)
+}
+
+// DirStore ユーザーごとに <Dir>/<key>.json へトークンを保存する。
+type DirStore struct {
+	Dir    string
+	Cipher *Cipher
+}
+
+// keyPattern ファイル名に使えるユーザーID（パスの区切りなどを含まないもの）
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9._@+-]+$`)
+
+func (s *DirStore) path(key string) (string, error) {
+	if !keyPattern.MatchString(key) || key == "." || key == ".." {
+		return "", fmt.Errorf("invalid token key: %q", key)
+	}
+	return filepath.Join(s.Dir, key+".json"), nil
+}
+
+func (s *DirStore) Load(key string) (*oauth2.Token, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return readTokenFile(path, s.Cipher, key)
+}
+
+func (s *DirStore) Save(key string, token *oauth2.Token) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	return writeTokenFile(path, s.Cipher, key, token)
+}
+
+func (s *DirStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return removeTokenFile(path)
+}
+
+// Keys 保存されているユーザーIDの一覧を返す。
+func (s *DirStore) Keys() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(s.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(matches))
+	for _, m := range matches {
+		name := filepath.Base(m)
+		keys = append(keys, name[:len(name)-len(".json")])
+	}
+	return keys, nil
+}
+
+// storedToken 保存形式
+// oauth2.TokenのJSONには付与されたscopeが含まれないため、scopeの確認用に一緒に保存する。
+// 再同意が必要になったトークンは、別のプロセスからも分かるよう理由を一緒に保存する。
+type storedToken struct {
+	*oauth2.Token
+	Scope     string `json:"scope,omitempty"`
+	Reconsent string `json:"reconsent,omitempty"`
+}
+
+// extraReconsent 再同意が必要な理由を持たせるトークンのExtraのキー
+const extraReconsent = "reconsent"
+
+// ReconsentReason 保存されたトークンが再同意が必要な状態であればその理由を返す。不要であれば空文字。
+func ReconsentReason(token *oauth2.Token) string {
+	reason, _ := token.Extra(extraReconsent).(string)
+	return reason
+}
+
+// WithReconsentReason 再同意が必要な理由を持たせたトークンのコピーを返す。scopeはそのまま引き継ぐ。
+func WithReconsentReason(token *oauth2.Token, reason string) *oauth2.Token {
+	extra := map[string]interface{}{extraReconsent: reason}
+	if scope, _ := token.Extra("scope").(string); scope != "" {
+		extra["scope"] = scope
+	}
+	return token.WithExtra(extra)
+}
+
+// encodeToken トークンをJSONにし、Cipherがあればkey（ユーザーID）のトークンとして暗号化する。
+func encodeToken(c *Cipher, key string, token *oauth2.Token) ([]byte, error) {
+	scope, _ := token.Extra("scope").(string)
+	b, err := json.Marshal(storedToken{Token: token, Scope: scope, Reconsent: ReconsentReason(token)})
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return b, nil
+	}
+	return c.Encrypt(key, b)
+}
+
+// decodeToken encodeTokenの逆変換。中身のないトークンはエラーにする。
+func decodeToken(c *Cipher, key string, b []byte) (*oauth2.Token, error) {
+	if c != nil {
+		plain, err := c.Decrypt(key, b)
+		if err != nil {
+			return nil, err
+		}
+		b = plain
+	}
+	stored := storedToken{Token: &oauth2.Token{}}
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	tok := stored.Token
+	if tok.AccessToken == "" && tok.RefreshToken == "" {
+		return nil, errors.New("invalid token: neither access_token nor refresh_token is set")
+	}
+	extra := make(map[string]interface{})
+	if stored.Scope != "" {
+		extra["scope"] = stored.Scope
+	}
+	if stored.Reconsent != "" {
+		extra[extraReconsent] = stored.Reconsent
+	}
+	if len(extra) > 0 {
+		tok = tok.WithExtra(extra)
+	}
+	return tok, nil
+}
+
+func readTokenFile(path string, c *Cipher, key string) (*oauth2.Token, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeToken(c, key, b)
+}
+
+// writeTokenFile 書き込み途中で壊れたファイルが残らないよう、一時ファイルに書いてからrenameする。
+func writeTokenFile(path string, c *Cipher, key string, token *oauth2.Token) error {
+	b, err := encodeToken(c, key, token)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func removeTokenFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// persistingTokenSource リフレッシュされたトークンを保存先に書き戻すTokenSource
+type persistingTokenSource struct {
+	mu     sync.Mutex
+	src    oauth2.TokenSource
+	store  TokenStore
+	key    string
+	latest string // 最後に保存したアクセストークン
+}
+
+// PersistingTokenSource srcから取得したトークンが変わった（リフレッシュされた）ときにstoreへ保存する。
+//
+// ex:
+// src := config.TokenSource(ctx, tok)
+// client := oauth2.NewClient(ctx, auth.PersistingTokenSource(src, store, userId, tok))
+func PersistingTokenSource(src oauth2.TokenSource, store TokenStore, key string, current *oauth2.Token) oauth2.TokenSource {
+	s := &persistingTokenSource{src: src, store: store, key: key}
+	if current != nil {
+		s.latest = current.AccessToken
+	}
+	return oauth2.ReuseTokenSource(current, s)
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tok.AccessToken != s.latest {
+		if err := s.store.Save(s.key, tok); err != nil {
+			return nil, fmt.Errorf("unable to persist refreshed token: %w", err)
+		}
+		s.latest = tok.AccessToken
+	}
+	return tok, nil
+}
@@ -0,0 +1,220 @@
+// Package cache カレンダーごと・日ごとの予定ありの期間をTTL付きでキャッシュする。
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Backend キャッシュの保存先
+//
+// RedisのGET / SET key value EX ttl / DEL と同じ操作にしているため、Redisのクライアントをそのまま包んで使える。
+// 見つからない・期限切れの場合、Getはok=falseを返す。
+type Backend interface {
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// LRU プロセス内のキャッシュ。Capacity件を超えると最も使われていないものから捨てる。
+type LRU struct {
+	Capacity int
+
+	mu    sync.Mutex
+	ll    *list.List // 先頭ほど最近使われた
+	items map[string]*list.Element
+	now   func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRU capacity件まで保持するLRUを作成する。
+func NewLRU(capacity int) *LRU {
+	return &LRU{Capacity: capacity, ll: list.New(), items: make(map[string]*list.Element), now: time.Now}
+}
+
+func (c *LRU) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := e.Value.(*lruEntry)
+	if !entry.expires.IsZero() && !c.now().Before(entry.expires) {
+		c.remove(e)
+		return nil, false, nil
+	}
+	c.ll.MoveToFront(e)
+	return entry.value, true, nil
+}
+
+func (c *LRU) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var expires time.Time
+	if ttl > 0 {
+		expires = c.now().Add(ttl)
+	}
+	if e, ok := c.items[key]; ok {
+		e.Value = &lruEntry{key: key, value: value, expires: expires}
+		c.ll.MoveToFront(e)
+		return nil
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.Capacity > 0 && c.ll.Len() > c.Capacity {
+		c.remove(c.ll.Back())
+	}
+	return nil
+}
+
+func (c *LRU) Delete(_ context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if e, ok := c.items[key]; ok {
+			c.remove(e)
+		}
+	}
+	return nil
+}
+
+// Len 保持している件数（期限切れを含む）
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *LRU) remove(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.items, e.Value.(*lruEntry).key)
+}
+
+// FileBackend ディレクトリにキーごとのファイルで保存する。プロセスをまたいで共有できる。
+//
+// ファイルの1行目に期限（UnixNano、0は無期限）、2行目以降に値を書く。
+type FileBackend struct {
+	Dir string
+}
+
+func (b *FileBackend) path(key string) string {
+	// カレンダーIDには / や # が含まれることがあるため、ハッシュをファイル名にする。
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(b.Dir, hex.EncodeToString(sum[:]))
+}
+
+func (b *FileBackend) Get(_ context.Context, key string) ([]byte, bool, error) {
+	data, err := ioutil.ReadFile(b.path(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, false, fmt.Errorf("cache %s: broken entry", key)
+	}
+	expires, err := strconv.ParseInt(string(data[:i]), 10, 64)
+	if err != nil {
+		return nil, false, fmt.Errorf("cache %s: %w", key, err)
+	}
+	if expires != 0 && time.Now().UnixNano() >= expires {
+		os.Remove(b.path(key))
+		return nil, false, nil
+	}
+	return data[i+1:], true, nil
+}
+
+func (b *FileBackend) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := os.MkdirAll(b.Dir, 0700); err != nil {
+		return err
+	}
+	var expires int64
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).UnixNano()
+	}
+	// 書き込み途中のファイルを読まないよう、一時ファイルに書いてから置き換える。
+	f, err := ioutil.TempFile(b.Dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := fmt.Fprintf(f, "%d\n%s", expires, value); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), b.path(key))
+}
+
+func (b *FileBackend) Delete(_ context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := os.Remove(b.path(key)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tiered 複数のBackendを重ねたもの（ex: LRU → FileBackend / Redis）
+//
+// Getは先頭から探し、後ろで見つかった値は前のBackendにも入れる。Set / Deleteはすべてに行う。
+type Tiered struct {
+	Tiers []Backend
+	TTL   time.Duration // 後ろのBackendで見つかった値を前に入れるときのTTL
+}
+
+func (t *Tiered) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	for i, b := range t.Tiers {
+		value, ok, err := b.Get(ctx, key)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			continue
+		}
+		for _, front := range t.Tiers[:i] {
+			if err := front.Set(ctx, key, value, t.TTL); err != nil {
+				return nil, false, err
+			}
+		}
+		return value, true, nil
+	}
+	return nil, false, nil
+}
+
+func (t *Tiered) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	for _, b := range t.Tiers {
+		if err := b.Set(ctx, key, value, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Tiered) Delete(ctx context.Context, keys ...string) error {
+	for _, b := range t.Tiers {
+		if err := b.Delete(ctx, keys...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
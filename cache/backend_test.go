@@ -0,0 +1,297 @@
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// clock テスト用に進められる時計
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newTestLRU(capacity int, c *clock) *LRU {
+	l := NewLRU(capacity)
+	l.now = c.now
+	return l
+}
+
+// presentKeys keysのうちbに入っているものを返す。
+func presentKeys(t *testing.T, b Backend, keys ...string) []string {
+	t.Helper()
+	present := make([]string, 0)
+	for _, key := range keys {
+		_, ok, err := b.Get(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			present = append(present, key)
+		}
+	}
+	return present
+}
+
+func TestLRUEviction(t *testing.T) {
+	ctx := context.Background()
+	set := func(c *LRU, keys ...string) {
+		for _, key := range keys {
+			if err := c.Set(ctx, key, []byte(key), 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tests := []struct {
+		name     string
+		capacity int
+		run      func(c *LRU)
+		want     []string
+	}{
+		{
+			name:     "evicts least recently used",
+			capacity: 2,
+			run:      func(c *LRU) { set(c, "a", "b", "c") },
+			want:     []string{"b", "c"},
+		},
+		{
+			name:     "get marks as recently used",
+			capacity: 2,
+			run: func(c *LRU) {
+				set(c, "a", "b")
+				c.Get(ctx, "a")
+				set(c, "c")
+			},
+			want: []string{"a", "c"},
+		},
+		{
+			name:     "overwrite marks as recently used",
+			capacity: 2,
+			run:      func(c *LRU) { set(c, "a", "b", "a", "c") },
+			want:     []string{"a", "c"},
+		},
+		{
+			name:     "delete frees capacity",
+			capacity: 2,
+			run: func(c *LRU) {
+				set(c, "a", "b")
+				c.Delete(ctx, "a", "unknown")
+				set(c, "c")
+			},
+			want: []string{"b", "c"},
+		},
+		{
+			name:     "zero capacity is unbounded",
+			capacity: 0,
+			run:      func(c *LRU) { set(c, "a", "b", "c") },
+			want:     []string{"a", "b", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestLRU(tt.capacity, &clock{t: time.Now()})
+			tt.run(c)
+			if c.Len() != len(tt.want) {
+				t.Errorf("Len() = %d, want %d", c.Len(), len(tt.want))
+			}
+			if got := presentKeys(t, c, "a", "b", "c"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLRUExpiry(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		elapsed time.Duration
+		want    bool
+	}{
+		{name: "before expiry", ttl: time.Minute, elapsed: time.Minute - time.Nanosecond, want: true},
+		{name: "at expiry", ttl: time.Minute, elapsed: time.Minute, want: false},
+		{name: "no ttl", ttl: 0, elapsed: 24 * time.Hour, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := &clock{t: time.Date(2022, 4, 18, 9, 0, 0, 0, time.UTC)}
+			c := newTestLRU(10, clk)
+			if err := c.Set(ctx, "a", []byte("value"), tt.ttl); err != nil {
+				t.Fatal(err)
+			}
+			clk.t = clk.t.Add(tt.elapsed)
+			value, ok, err := c.Get(ctx, "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.want {
+				t.Fatalf("Get() ok = %v, want %v", ok, tt.want)
+			}
+			if ok && string(value) != "value" {
+				t.Errorf("Get() = %q, want %q", value, "value")
+			}
+			// 期限切れの値は取り出したときに捨てる。
+			if want := map[bool]int{true: 1, false: 0}[tt.want]; c.Len() != want {
+				t.Errorf("Len() = %d, want %d", c.Len(), want)
+			}
+		})
+	}
+}
+
+func TestFileBackend(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		setup   func(b *FileBackend) error
+		want    string
+		wantOk  bool
+		wantErr bool
+		removed bool // Getのあとファイルが消えているか
+	}{
+		{
+			name:   "no ttl",
+			setup:  func(b *FileBackend) error { return b.Set(ctx, "k", []byte("value\nwith newline"), 0) },
+			want:   "value\nwith newline",
+			wantOk: true,
+		},
+		{
+			name:   "before expiry",
+			setup:  func(b *FileBackend) error { return b.Set(ctx, "k", []byte("value"), time.Hour) },
+			want:   "value",
+			wantOk: true,
+		},
+		{
+			name: "expired",
+			setup: func(b *FileBackend) error {
+				expires := time.Now().Add(-time.Second).UnixNano()
+				return ioutil.WriteFile(b.path("k"), []byte(strconv.FormatInt(expires, 10)+"\nvalue"), 0600)
+			},
+			removed: true,
+		},
+		{
+			name:  "missing",
+			setup: func(b *FileBackend) error { return nil },
+		},
+		{
+			name:    "broken entry",
+			setup:   func(b *FileBackend) error { return ioutil.WriteFile(b.path("k"), []byte("value"), 0600) },
+			wantErr: true,
+		},
+		{
+			name: "deleted",
+			setup: func(b *FileBackend) error {
+				if err := b.Set(ctx, "k", []byte("value"), 0); err != nil {
+					return err
+				}
+				return b.Delete(ctx, "k", "unknown")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &FileBackend{Dir: t.TempDir()}
+			if err := tt.setup(b); err != nil {
+				t.Fatal(err)
+			}
+			value, ok, err := b.Get(ctx, "k")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOk || string(value) != tt.want {
+				t.Errorf("Get() = %q, %v, want %q, %v", value, ok, tt.want, tt.wantOk)
+			}
+			if _, err := os.Stat(b.path("k")); tt.removed && !os.IsNotExist(err) {
+				t.Errorf("expired entry is not removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestTiered(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		setup     func(tiered *Tiered, front, back *LRU) error
+		want      string
+		wantOk    bool
+		wantFront bool // Getのあとfrontに値があるか
+	}{
+		{
+			name:      "front hit",
+			setup:     func(_ *Tiered, front, _ *LRU) error { return front.Set(ctx, "k", []byte("front"), 0) },
+			want:      "front",
+			wantOk:    true,
+			wantFront: true,
+		},
+		{
+			name:      "back hit is promoted",
+			setup:     func(_ *Tiered, _, back *LRU) error { return back.Set(ctx, "k", []byte("back"), 0) },
+			want:      "back",
+			wantOk:    true,
+			wantFront: true,
+		},
+		{
+			name:  "miss",
+			setup: func(*Tiered, *LRU, *LRU) error { return nil },
+		},
+		{
+			name: "delete from all tiers",
+			setup: func(tiered *Tiered, _, _ *LRU) error {
+				if err := tiered.Set(ctx, "k", []byte("value"), 0); err != nil {
+					return err
+				}
+				return tiered.Delete(ctx, "k")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := &clock{t: time.Date(2022, 4, 18, 9, 0, 0, 0, time.UTC)}
+			front, back := newTestLRU(10, clk), newTestLRU(10, clk)
+			tiered := &Tiered{Tiers: []Backend{front, back}, TTL: time.Minute}
+			if err := tt.setup(tiered, front, back); err != nil {
+				t.Fatal(err)
+			}
+			value, ok, err := tiered.Get(ctx, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOk || string(value) != tt.want {
+				t.Errorf("Get() = %q, %v, want %q, %v", value, ok, tt.want, tt.wantOk)
+			}
+			if got := len(presentKeys(t, front, "k")) == 1; got != tt.wantFront {
+				t.Errorf("front has value = %v, want %v", got, tt.wantFront)
+			}
+		})
+	}
+}
+
+// TestTieredPromotionTTL 後ろのBackendから戻した値はTiered.TTLで期限切れになる。
+func TestTieredPromotionTTL(t *testing.T) {
+	ctx := context.Background()
+	clk := &clock{t: time.Date(2022, 4, 18, 9, 0, 0, 0, time.UTC)}
+	front, back := newTestLRU(10, clk), newTestLRU(10, clk)
+	tiered := &Tiered{Tiers: []Backend{front, back}, TTL: time.Minute}
+	if err := back.Set(ctx, "k", []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := tiered.Get(ctx, "k"); err != nil || !ok {
+		t.Fatalf("Get() = %v, %v, want hit", ok, err)
+	}
+	clk.t = clk.t.Add(time.Minute)
+	if got := presentKeys(t, front, "k"); len(got) != 0 {
+		t.Errorf("promoted value is not expired after Tiered.TTL")
+	}
+	if got := presentKeys(t, back, "k"); len(got) != 1 {
+		t.Errorf("back value is expired")
+	}
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// NamespaceEvents Events.Listから作成した予定（予約・仮の予定を区別する）
+	NamespaceEvents = "events"
+	// NamespaceFreeBusy FreeBusyで取得した予定ありの期間
+	NamespaceFreeBusy = "freebusy"
+
+	DefaultTTL     = 5 * time.Minute
+	DefaultLRUSize = 4096 // カレンダー × 日の件数
+)
+
+// namespaces 無効化の対象
+var namespaces = []string{NamespaceEvents, NamespaceFreeBusy}
+
+// Busy 予定ありの期間1件
+type Busy struct {
+	Id        string    `json:"id,omitempty"` // 予定のID（FreeBusyの期間にはない）
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Booking   bool      `json:"booking,omitempty"`   // 予約経由の予定
+	Tentative bool      `json:"tentative,omitempty"` // 仮の予定
+}
+
+// entry 1カレンダー・1日分の値
+// Backendの期限とは別に期限を持たせ、後ろのBackendから戻した値が延命されないようにする。
+type entry struct {
+	Expires time.Time `json:"expires"`
+	Busy    []Busy    `json:"busy"`
+}
+
+// BusyCache カレンダーごと・日ごとの予定ありの期間のキャッシュ
+//
+// 1日分には、その日にかかる予定を（日をまたぐ予定も切り取らずに）入れる。
+// 期間全体の日がすべてキャッシュにある場合のみヒットとし、一部だけ使うことはしない。
+// ex: key: busy:events:example@gmail.com:2022-04-18
+type BusyCache struct {
+	Backend  Backend
+	TTL      time.Duration
+	Location *time.Location // 日の区切り
+
+	now func() time.Time
+}
+
+// NewBusyCache time.Localの日ごとにキャッシュするBusyCacheを作成する。
+func NewBusyCache(backend Backend, ttl time.Duration) *BusyCache {
+	return &BusyCache{Backend: backend, TTL: ttl, Location: time.Local, now: time.Now}
+}
+
+func key(namespace, calendarId string, day time.Time) string {
+	return fmt.Sprintf("busy:%s:%s:%s", namespace, calendarId, day.Format("2006-01-02"))
+}
+
+// days [from, to) にかかる日（0時）を返す。
+func (c *BusyCache) days(from, to time.Time) []time.Time {
+	from = from.In(c.Location)
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, c.Location)
+	days := make([]time.Time, 0)
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	return days
+}
+
+// Get [from, to) にかかる予定ありの期間を開始時刻順に返す。1日でもキャッシュにない日があればok=false
+func (c *BusyCache) Get(ctx context.Context, namespace, calendarId string, from, to time.Time) ([]Busy, bool, error) {
+	seen := make(map[Busy]bool)
+	busy := make([]Busy, 0)
+	for _, day := range c.days(from, to) {
+		value, ok, err := c.Backend.Get(ctx, key(namespace, calendarId, day))
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		var e entry
+		if err := json.Unmarshal(value, &e); err != nil {
+			return nil, false, err
+		}
+		if !c.now().Before(e.Expires) {
+			return nil, false, nil
+		}
+		for _, b := range e.Busy {
+			// 日をまたぐ予定は両方の日に入っているため、予定のIDで重複を除く。
+			// 同じ時刻の別の予約を1件にまとめると予約の上限を数え損ねるため、時刻で比べるのはIDのない期間のみ。
+			k := Busy{Id: b.Id}
+			if b.Id == "" {
+				// timeのLocationが異なると別の値になるためUTCにそろえる。
+				k = Busy{Start: b.Start.UTC(), End: b.End.UTC(), Booking: b.Booking, Tentative: b.Tentative}
+			}
+			if seen[k] || !b.End.After(from) || !b.Start.Before(to) {
+				continue
+			}
+			seen[k] = true
+			busy = append(busy, b)
+		}
+	}
+	sort.SliceStable(busy, func(i, j int) bool { return busy[i].Start.Before(busy[j].Start) })
+	return busy, true, nil
+}
+
+// Put [from, to) で取得した予定ありの期間を日ごとに入れる。
+// 期間の途中から・途中までしか取得していない日は、その日の予定がすべて揃っていないため入れない。
+func (c *BusyCache) Put(ctx context.Context, namespace, calendarId string, from, to time.Time, busy []Busy) error {
+	expires := c.now().Add(c.TTL)
+	for _, day := range c.days(from, to) {
+		next := day.AddDate(0, 0, 1)
+		if day.Before(from) || next.After(to) {
+			continue
+		}
+		e := entry{Expires: expires, Busy: make([]Busy, 0)}
+		for _, b := range busy {
+			if b.End.After(day) && b.Start.Before(next) {
+				e.Busy = append(e.Busy, b)
+			}
+		}
+		value, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := c.Backend.Set(ctx, key(namespace, calendarId, day), value, c.TTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Invalidate calendarIdの [start, end) にかかる日のキャッシュを消す。
+// 予約経由で予定を登録・変更・削除したときに呼び、次の取得でAPIから取り直す。
+func (c *BusyCache) Invalidate(ctx context.Context, calendarId string, start, end time.Time) error {
+	keys := make([]string, 0)
+	for _, day := range c.days(start, end) {
+		for _, namespace := range namespaces {
+			keys = append(keys, key(namespace, calendarId, day))
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.Backend.Delete(ctx, keys...)
+}
+
+// Config -cache-dir, -cache-ttl, -cache-size フラグの値
+type Config struct {
+	Dir  string        // 空の場合はプロセス内（LRU）のみ
+	TTL  time.Duration // 0以下はキャッシュしない
+	Size int
+}
+
+// NewConfig デフォルト値のConfigを作成する。
+func NewConfig() *Config {
+	return &Config{TTL: DefaultTTL, Size: DefaultLRUSize}
+}
+
+// RegisterFlags -cache-dir, -cache-ttl, -cache-size フラグを登録する。
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Dir, "cache-dir", c.Dir, "directory shared between runs to cache busy data per calendar and day")
+	fs.DurationVar(&c.TTL, "cache-ttl", c.TTL, "how long cached busy data is used (0 disables the cache)")
+	fs.IntVar(&c.Size, "cache-size", c.Size, "number of calendar days kept in memory")
+}
+
+// Open LRU（と-cache-dirがあればFileBackend）を重ねたBusyCacheを返す。TTLが0以下ならnil
+//
+// Invalidateは他のプロセスのLRUまでは消せないため、常駐するプロセスではTTLまで古い値が残ることがある。
+func (c *Config) Open() *BusyCache {
+	if c.TTL <= 0 {
+		return nil
+	}
+	tiers := []Backend{NewLRU(c.Size)}
+	if c.Dir != "" {
+		tiers = append(tiers, &FileBackend{Dir: c.Dir})
+	}
+	return NewBusyCache(&Tiered{Tiers: tiers, TTL: c.TTL}, c.TTL)
+}
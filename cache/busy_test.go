@@ -0,0 +1,273 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var jst = time.FixedZone("JST", 9*60*60)
+
+// day 2022/04/dd hh:mm (JST)
+func day(dd, hh, mm int) time.Time {
+	return time.Date(2022, 4, dd, hh, mm, 0, 0, jst)
+}
+
+// mapBackend 期限を持たないBackend。entry側の期限だけを確認するために使う。
+type mapBackend map[string][]byte
+
+func (b mapBackend) Get(_ context.Context, key string) ([]byte, bool, error) {
+	value, ok := b[key]
+	return value, ok, nil
+}
+
+func (b mapBackend) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	b[key] = value
+	return nil
+}
+
+func (b mapBackend) Delete(_ context.Context, keys ...string) error {
+	for _, key := range keys {
+		delete(b, key)
+	}
+	return nil
+}
+
+func (b mapBackend) keys() []string {
+	keys := make([]string, 0, len(b))
+	for key := range b {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newTestBusyCache(backend Backend, clk *clock) *BusyCache {
+	return &BusyCache{Backend: backend, TTL: time.Minute, Location: jst, now: clk.now}
+}
+
+func TestBusyCacheGetPut(t *testing.T) {
+	ctx := context.Background()
+	const id = "a@example.com"
+	overnight := Busy{Id: "overnight", Start: day(18, 22, 0), End: day(19, 2, 0)}
+	morning := Busy{Id: "morning", Start: day(19, 9, 0), End: day(19, 10, 0), Booking: true}
+	tests := []struct {
+		name     string
+		putFrom  time.Time
+		putTo    time.Time
+		busy     []Busy
+		getFrom  time.Time
+		getTo    time.Time
+		want     []Busy
+		wantOk   bool
+		wantKeys []string
+	}{
+		{
+			name:    "overnight event is returned once",
+			putFrom: day(18, 0, 0), putTo: day(20, 0, 0),
+			busy:    []Busy{morning, overnight},
+			getFrom: day(18, 0, 0), getTo: day(20, 0, 0),
+			want:   []Busy{overnight, morning},
+			wantOk: true,
+			wantKeys: []string{
+				"busy:events:a@example.com:2022-04-18",
+				"busy:events:a@example.com:2022-04-19",
+			},
+		},
+		{
+			name:    "bookings at the same time are kept",
+			putFrom: day(19, 0, 0), putTo: day(20, 0, 0),
+			busy:    []Busy{morning, {Id: "other", Start: morning.Start, End: morning.End, Booking: true}},
+			getFrom: day(19, 0, 0), getTo: day(20, 0, 0),
+			want:   []Busy{morning, {Id: "other", Start: morning.Start, End: morning.End, Booking: true}},
+			wantOk: true,
+			wantKeys: []string{
+				"busy:events:a@example.com:2022-04-19",
+			},
+		},
+		{
+			name:    "busy without id is deduped by time",
+			putFrom: day(18, 0, 0), putTo: day(20, 0, 0),
+			busy:    []Busy{{Start: overnight.Start, End: overnight.End}},
+			getFrom: day(18, 0, 0), getTo: day(20, 0, 0),
+			want:   []Busy{{Start: overnight.Start, End: overnight.End}},
+			wantOk: true,
+			wantKeys: []string{
+				"busy:events:a@example.com:2022-04-18",
+				"busy:events:a@example.com:2022-04-19",
+			},
+		},
+		{
+			name:    "busy outside the range is dropped",
+			putFrom: day(18, 0, 0), putTo: day(20, 0, 0),
+			busy:    []Busy{morning, overnight},
+			getFrom: day(19, 8, 0), getTo: day(19, 12, 0),
+			want:   []Busy{morning},
+			wantOk: true,
+			wantKeys: []string{
+				"busy:events:a@example.com:2022-04-18",
+				"busy:events:a@example.com:2022-04-19",
+			},
+		},
+		{
+			name:    "partial days are not put",
+			putFrom: day(18, 12, 0), putTo: day(19, 12, 0),
+			busy:    []Busy{morning, overnight},
+			getFrom: day(18, 0, 0), getTo: day(20, 0, 0),
+			wantKeys: []string{},
+		},
+		{
+			name:    "miss when any day is missing",
+			putFrom: day(18, 0, 0), putTo: day(19, 0, 0),
+			busy:    []Busy{overnight},
+			getFrom: day(18, 0, 0), getTo: day(20, 0, 0),
+			wantKeys: []string{
+				"busy:events:a@example.com:2022-04-18",
+			},
+		},
+		{
+			name:    "empty day is a hit",
+			putFrom: day(20, 0, 0), putTo: day(21, 0, 0),
+			getFrom: day(20, 9, 0), getTo: day(20, 18, 0),
+			want:   []Busy{},
+			wantOk: true,
+			wantKeys: []string{
+				"busy:events:a@example.com:2022-04-20",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := mapBackend{}
+			c := newTestBusyCache(backend, &clock{t: day(18, 0, 0)})
+			if err := c.Put(ctx, NamespaceEvents, id, tt.putFrom, tt.putTo, tt.busy); err != nil {
+				t.Fatal(err)
+			}
+			if got := backend.keys(); !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("keys = %v, want %v", got, tt.wantKeys)
+			}
+			got, ok, err := c.Get(ctx, NamespaceEvents, id, tt.getFrom, tt.getTo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOk {
+				t.Fatalf("Get() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if !ok {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Get() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				// JSONを経由するとLocationが変わるため時刻はEqualで比べる。
+				g, w := got[i], tt.want[i]
+				if g.Id != w.Id || !g.Start.Equal(w.Start) || !g.End.Equal(w.End) || g.Booking != w.Booking || g.Tentative != w.Tentative {
+					t.Errorf("Get()[%d] = %+v, want %+v", i, g, w)
+				}
+			}
+		})
+	}
+}
+
+// TestBusyCacheTTL Backendに値が残っていてもBusyCacheのTTLを過ぎたらヒットしない。
+func TestBusyCacheTTL(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    bool
+	}{
+		{name: "before expiry", elapsed: time.Minute - time.Nanosecond, want: true},
+		{name: "at expiry", elapsed: time.Minute, want: false},
+		{name: "after expiry", elapsed: time.Hour, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := &clock{t: day(18, 0, 0)}
+			c := newTestBusyCache(mapBackend{}, clk)
+			if err := c.Put(ctx, NamespaceFreeBusy, "a", day(18, 0, 0), day(19, 0, 0), nil); err != nil {
+				t.Fatal(err)
+			}
+			clk.t = clk.t.Add(tt.elapsed)
+			_, ok, err := c.Get(ctx, NamespaceFreeBusy, "a", day(18, 0, 0), day(19, 0, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.want {
+				t.Errorf("Get() ok = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestBusyCacheInvalidate(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		calendarId string
+		start, end time.Time
+		want       []string
+	}{
+		{
+			name:       "one day in both namespaces",
+			calendarId: "a",
+			start:      day(19, 9, 0), end: day(19, 10, 0),
+			want: []string{
+				"busy:events:a:2022-04-18",
+				"busy:events:b:2022-04-18",
+				"busy:events:b:2022-04-19",
+				"busy:freebusy:a:2022-04-18",
+				"busy:freebusy:b:2022-04-18",
+				"busy:freebusy:b:2022-04-19",
+			},
+		},
+		{
+			name:       "overnight event",
+			calendarId: "b",
+			start:      day(18, 22, 0), end: day(19, 2, 0),
+			want: []string{
+				"busy:events:a:2022-04-18",
+				"busy:events:a:2022-04-19",
+				"busy:freebusy:a:2022-04-18",
+				"busy:freebusy:a:2022-04-19",
+			},
+		},
+		{
+			name:       "outside cached days",
+			calendarId: "a",
+			start:      day(25, 9, 0), end: day(25, 10, 0),
+			want: []string{
+				"busy:events:a:2022-04-18",
+				"busy:events:a:2022-04-19",
+				"busy:events:b:2022-04-18",
+				"busy:events:b:2022-04-19",
+				"busy:freebusy:a:2022-04-18",
+				"busy:freebusy:a:2022-04-19",
+				"busy:freebusy:b:2022-04-18",
+				"busy:freebusy:b:2022-04-19",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := mapBackend{}
+			c := newTestBusyCache(backend, &clock{t: day(18, 0, 0)})
+			for _, namespace := range namespaces {
+				for _, id := range []string{"a", "b"} {
+					if err := c.Put(ctx, namespace, id, day(18, 0, 0), day(20, 0, 0), nil); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if err := c.Invalidate(ctx, tt.calendarId, tt.start, tt.end); err != nil {
+				t.Fatal(err)
+			}
+			if got := backend.keys(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
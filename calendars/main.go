@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"google-calendar-sample/auth"
+	"google-calendar-sample/gcal"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// カレンダー一覧の表示と、共有カレンダーの追加・削除を行う。
+//
+// go run ./calendars [-role owner,writer] [-min-role reader] [-json] list
+// go run ./calendars subscribe xxx@group.calendar.google.com
+// go run ./calendars unsubscribe xxx@group.calendar.google.com
+func main() {
+	resolver := auth.NewResolver()
+	resolver.RegisterFlags(flag.CommandLine)
+	roles := flag.String("role", "", "comma separated access roles to show (freeBusyReader, reader, writer, owner)")
+	minRole := flag.String("min-role", "", "minimum access role to show")
+	showHidden := flag.Bool("show-hidden", false, "include hidden calendars")
+	asJSON := flag.Bool("json", false, "output as JSON")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+	}
+
+	// 一覧の表示は参照のみ、追加・削除はカレンダー一覧の書き込みが必要
+	op := auth.OpCalendarListReadonly
+	if args[0] != "list" {
+		op = auth.OpCalendarList
+	}
+	ctx := context.Background()
+	c, err := resolver.Credentials(ctx, auth.ScopesFor(op)...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	calendarService, err := calendar.NewService(ctx, option.WithCredentials(c))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch args[0] {
+	case "list":
+		opts := gcal.ListOptions{MinAccessRole: *minRole, ShowHidden: *showHidden}
+		if *roles != "" {
+			opts.AccessRoles = strings.Split(*roles, ",")
+		}
+		calendars, err := gcal.ListCalendars(ctx, calendarService, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *asJSON {
+			b, err := json.MarshalIndent(calendars, "", "    ")
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(string(b))
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSUMMARY\tACCESS ROLE\tTIME ZONE\tPRIMARY\tSELECTED\tCOLOR")
+		for _, cal := range calendars {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\t%v\t%s\n", cal.Id, cal.Summary, cal.AccessRole, cal.TimeZone, cal.Primary, cal.Selected, cal.BackgroundColor)
+		}
+		w.Flush()
+	case "subscribe":
+		requireId(args)
+		cal, err := gcal.Subscribe(ctx, calendarService, args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("subscribed: %s (%s, %s)\n", cal.Id, cal.Summary, cal.AccessRole)
+	case "unsubscribe":
+		requireId(args)
+		if err := gcal.Unsubscribe(ctx, calendarService, args[1]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("unsubscribed: %s\n", args[1])
+	default:
+		usage()
+	}
+}
+
+func requireId(args []string) {
+	if len(args) < 2 {
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: calendars [flags] list | subscribe <calendarId> | unsubscribe <calendarId>")
+	os.Exit(2)
+}
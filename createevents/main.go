@@ -2,22 +2,29 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"golang.org/x/oauth2/google"
+	"google-calendar-sample/auth"
+	"google-calendar-sample/cache"
+	"google-calendar-sample/gcal"
+	"google-calendar-sample/schedule"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
-	"io/ioutil"
 	"log"
 	"time"
 )
 
 func main() {
+	resolver := auth.NewResolver()
+	resolver.RegisterFlags(flag.CommandLine)
+	cacheConfig := cache.NewConfig()
+	cacheConfig.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
 	ctx := context.Background()
-	srvAcc, err := ioutil.ReadFile("./credentials/service_account.json")
-	if err != nil {
-		log.Fatal(err)
-	}
-	c, err := google.CredentialsFromJSON(ctx, srvAcc, calendar.CalendarScope)
+	// -credentials / 環境変数 / credentials/service_account.json / ADC の順で認証情報を探す。
+	// -subject を指定した場合は、そのWorkspaceユーザーとして呼び出す（ドメイン全体の委任）。
+	c, err := resolver.Credentials(ctx, auth.ScopesFor(auth.OpEvents)...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -47,6 +54,10 @@ func main() {
 			DateTime: end.Format(time.RFC3339),
 			TimeZone: "Asia/Tokyo",
 		},
+		// 予約経由の予定であることを示す。getevents の予約上限の集計対象になる。
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{schedule.BookingPropertyKey: "true"},
+		},
 		Id: fmt.Sprintf("%v", now.Unix()),
 		Start: &calendar.EventDateTime{
 			DateTime: start.Format(time.RFC3339),
@@ -59,10 +70,23 @@ func main() {
 		// Attendees: []*calendar.EventAttendee{}
 	}
 
-	e, err := calendarService.Events.Insert(calendarId, &event).Do()
+	// イベントIDを指定しているため、再試行で二重に登録されることはない。
+	var e *calendar.Event
+	err = gcal.Retry(ctx, "events.insert", func() error {
+		e, err = calendarService.Events.Insert(calendarId, &event).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		log.Printf("%v", err)
 	}
 	log.Printf("%+v", e)
 
+	// 予約で埋まった日のキャッシュを消し、getevents / freebusy が次回APIから取り直すようにする。
+	// 失敗した場合も登録されている可能性があるため消しておく。
+	if busyCache := cacheConfig.Open(); busyCache != nil {
+		if err := busyCache.Invalidate(ctx, calendarId, start, end); err != nil {
+			log.Printf("cache: %v", err)
+		}
+	}
+
 }
@@ -3,22 +3,38 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"golang.org/x/oauth2/google"
+	"google-calendar-sample/auth"
+	"google-calendar-sample/cache"
+	"google-calendar-sample/gcal"
+	"google-calendar-sample/ical"
+	"google-calendar-sample/report"
+	"google-calendar-sample/schedule"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
-	"io/ioutil"
 	"log"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 )
 
 func main() {
+	groups := flag.String("groups", "", "comma separated Google Group email addresses expanded to member calendars")
+	groupExpansionMax := flag.Int("group-expansion-max", gcal.FreeBusyMaxGroupExpansion, "maximum number of calendars expanded per group")
+	format := flag.String("format", "report", "output format: report, json, bits or ics")
+	listen := flag.String("listen", "", "serve availability as iCalendar at /freebusy.ics on this address (ex: :8080)")
+	resolver := auth.NewResolver()
+	resolver.RegisterFlags(flag.CommandLine)
+	cacheConfig := cache.NewConfig()
+	cacheConfig.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
 	ctx := context.Background()
-	srvAcc, err := ioutil.ReadFile("./credentials/service_account.json")
-	if err != nil {
-		log.Fatal(err)
-	}
-	c, err := google.CredentialsFromJSON(ctx, srvAcc, calendar.CalendarScope)
+	// -credentials / 環境変数 / credentials/service_account.json / ADC の順で認証情報を探す。
+	// -subject を指定した場合は、そのWorkspaceユーザーとして呼び出す（ドメイン全体の委任）。
+	c, err := resolver.Credentials(ctx, auth.ScopesFor(auth.OpFreeBusy)...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -29,87 +45,105 @@ func main() {
 	}
 
 	const days = 14
-	timeMax := time.Now().Add(24 * days * time.Hour).Format(time.RFC3339)
-	timeMin := time.Now().Format(time.RFC3339)
-	fmt.Println(timeMin)
-	fmt.Println(timeMax)
-
-	// sample calendar ids
-	freeBusyItem := []*calendar.FreeBusyRequestItem{
-		{Id: "kg090637fo0f1lg5s3ham2bhk8@group.calendar.google.com"},
-		{Id: "0lqtb45e5rpi3jmvjs4kcrrh94@group.calendar.google.com"},
-		{Id: "7j4hmerqr14ptp98p6b5p3io2k@group.calendar.google.com"},
-	}
-
-	freeBusyRequest := calendar.FreeBusyRequest{
-		CalendarExpansionMax: 0,
-		GroupExpansionMax:    0,
-		Items:                freeBusyItem,
-		TimeMax:              timeMax,
-		TimeMin:              timeMin,
-		TimeZone:             "Asia/Tokyo",
-		ForceSendFields:      nil,
-		NullFields:           nil,
+	now := time.Now()
+	timeMin := now
+	timeMax := now.Add(24 * days * time.Hour)
+	// icsはそのままファイルとして保存できるよう、期間は標準出力に出さない。
+	if *format != "ics" {
+		fmt.Println(timeMin.Format(time.RFC3339))
+		fmt.Println(timeMax.Format(time.RFC3339))
 	}
 
-	freeBusyResponse, err := calendarService.Freebusy.Query(&freeBusyRequest).Do()
-	if err != nil {
-		log.Fatal(err)
+	// sample calendar ids
+	calendarIds := []string{
+		"kg090637fo0f1lg5s3ham2bhk8@group.calendar.google.com",
+		"0lqtb45e5rpi3jmvjs4kcrrh94@group.calendar.google.com",
+		"7j4hmerqr14ptp98p6b5p3io2k@group.calendar.google.com",
 	}
-	b, err := json.MarshalIndent(freeBusyResponse, "", "    ")
-	if err != nil {
-		log.Fatal(err)
+	// グループのメールアドレスも参加者として受け付ける。
+	for _, group := range strings.Split(*groups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			calendarIds = append(calendarIds, group)
+		}
 	}
-	fmt.Println(string(b))
 
-	mapDate := make(map[string]uint64)
-	for _, calendar := range freeBusyResponse.Calendars {
-		for _, busy := range calendar.Busy {
-			if busy == nil {
+	// カレンダー数・期間がAPIの上限を超える場合は分割して問い合わせ、結果を結合する。
+	freeBusyClient := gcal.NewFreeBusyClient(calendarService, "Asia/Tokyo")
+	freeBusyClient.GroupExpansionMax = *groupExpansionMax
+	freeBusyClient.Cache = cacheConfig.Open()
 
+	// カレンダーアプリから購読できるよう、リクエストごとに問い合わせてVFREEBUSYで返す。
+	// FreeBusy APIは仮の予定を区別しないためすべてBUSYになる。
+	// BUSY-TENTATIVEが必要な場合は getevents -listen の /freetimes?format=ics を使う。
+	if *listen != "" {
+		handler := &ical.Handler{Days: days, Source: func(ctx context.Context, start, end time.Time) ([]ical.FreeBusy, error) {
+			result, err := freeBusyClient.Query(ctx, calendarIds, start, end)
+			if err != nil {
+				return nil, err
 			}
-			calcBit(mapDate, busy)
-		}
-	}
-	for k, v := range mapDate {
-		fmt.Printf("%v %064b\n", k, v)
+			return ical.FromFreeBusy(result)
+		}}
+		http.Handle("/freebusy.ics", handler)
+		log.Printf("listening on %s", *listen)
+		log.Fatal(http.ListenAndServe(*listen, nil))
 	}
-}
 
-func calcBit(mapDate map[string]uint64, timePeriod *calendar.TimePeriod) error {
-	startTime, err := time.Parse(time.RFC3339, timePeriod.Start)
+	freeBusyResult, err := freeBusyClient.Query(ctx, calendarIds, timeMin, timeMax)
 	if err != nil {
-		return err
-	}
-	endTime, err := time.Parse(time.RFC3339, timePeriod.End)
-	if err != nil {
-		return err
+		log.Fatal(err)
 	}
-	startTimeBit := uint64(startTime.Hour() * 2)
-	if startTime.Minute() >= 30 {
-		startTimeBit++
+	// notFound などカレンダー単位のエラーは、そのカレンダーを除いて続行する。
+	for _, e := range freeBusyResult.Errors {
+		log.Printf("skip calendar: %v", e)
 	}
-	endTimeBit := uint64(endTime.Hour() * 2)
-	if endTime.Minute() == 0 {
-		endTimeBit--
-	} else if endTime.Minute() > 30 {
-		endTimeBit++
+	// グループを展開したメンバー
+	for group, members := range freeBusyResult.Groups {
+		log.Printf("group %s: %d members %v", group, len(members), members)
 	}
-
-	date := startTime.Format("2006/01/02")
-	tmp := make(map[string]uint64)
-	if _, ok := mapDate[date]; !ok {
-		for i := startTimeBit; i <= endTimeBit; i++ {
-			mapDate[date] |= 1 << i
+	switch *format {
+	case "json":
+		b, err := json.MarshalIndent(freeBusyResult.Busy, "", "    ")
+		if err != nil {
+			log.Fatal(err)
 		}
-	} else {
-		for i := startTimeBit; i <= endTimeBit; i++ {
-			tmp[date] |= 1 << i
+		fmt.Println(string(b))
+	case "bits":
+		// getevents と同じCalendarBitsに集約し、論理積で誰か1人でも空いている時間枠を求める。
+		calendarBits := schedule.NewCalendarBits()
+		fetchedIds := freeBusyResult.CalendarIds()
+		for _, id := range fetchedIds {
+			for _, busy := range freeBusyResult.Busy[id] {
+				if err := calendarBits.AddTimePeriod(id, busy, time.Local); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+		calendarBits.FillEmpty(fetchedIds, timeMin, timeMax, time.Local)
+		dateBits := calendarBits.DateBits()
+		for _, date := range calendarBits.Dates() {
+			fmt.Printf("%v %064b\n", date, dateBits[date])
+		}
+	case "ics":
+		// カレンダーごとのVFREEBUSYと、誰も空いていない期間を集約したVFREEBUSY
+		components, err := ical.FromFreeBusy(freeBusyResult)
+		if err != nil {
+			log.Fatal(err)
+		}
+		components = ical.WithAggregate(components, freeBusyResult.TimeMin, freeBusyResult.TimeMax)
+		if err := ical.WriteFreeBusy(os.Stdout, components, now); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		// カレンダーごと・日ごとの予定ありの時間帯と、誰が空いているかのタイムライン
+		r, err := report.FromFreeBusy(freeBusyResult, time.Local)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.Color = report.IsTerminal(os.Stdout)
+		if err := r.Write(os.Stdout); err != nil {
+			log.Fatal(err)
 		}
-		mapDate[date] = mapDate[date] & tmp[date]
 	}
-
-	return nil
 }
 
 /*
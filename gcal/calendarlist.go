@@ -0,0 +1,122 @@
+package gcal
+
+import (
+	"context"
+	"google.golang.org/api/calendar/v3"
+	"sort"
+)
+
+// Access roles of CalendarListEntry.AccessRole
+// 権限の弱い順に並べている。
+const (
+	AccessRoleFreeBusyReader = "freeBusyReader"
+	AccessRoleReader         = "reader"
+	AccessRoleWriter         = "writer"
+	AccessRoleOwner          = "owner"
+)
+
+// CalendarInfo CalendarListEntryから一覧表示用に必要なものを抜き出したもの
+type CalendarInfo struct {
+	Id              string `json:"id"`
+	Summary         string `json:"summary"`
+	AccessRole      string `json:"accessRole"`
+	TimeZone        string `json:"timeZone"`
+	Primary         bool   `json:"primary"`
+	Selected        bool   `json:"selected"`
+	Hidden          bool   `json:"hidden"`
+	ColorId         string `json:"colorId,omitempty"`
+	BackgroundColor string `json:"backgroundColor,omitempty"`
+	ForegroundColor string `json:"foregroundColor,omitempty"`
+}
+
+func newCalendarInfo(entry *calendar.CalendarListEntry) *CalendarInfo {
+	summary := entry.Summary
+	if entry.SummaryOverride != "" {
+		summary = entry.SummaryOverride
+	}
+	return &CalendarInfo{
+		Id:              entry.Id,
+		Summary:         summary,
+		AccessRole:      entry.AccessRole,
+		TimeZone:        entry.TimeZone,
+		Primary:         entry.Primary,
+		Selected:        entry.Selected,
+		Hidden:          entry.Hidden,
+		ColorId:         entry.ColorId,
+		BackgroundColor: entry.BackgroundColor,
+		ForegroundColor: entry.ForegroundColor,
+	}
+}
+
+// ListOptions カレンダー一覧の絞り込み条件
+type ListOptions struct {
+	// MinAccessRole この権限以上のカレンダーのみ（APIのminAccessRole）
+	MinAccessRole string
+	// AccessRoles いずれかの権限と一致するカレンダーのみ（空の場合は絞り込まない）
+	AccessRoles []string
+	// ShowHidden 非表示にしているカレンダーも含める
+	ShowHidden bool
+}
+
+// ListCalendars カレンダー一覧をページングしながらすべて取得する。
+// メインのカレンダー（primary）を先頭に、以降はカレンダー名の順に並べる。
+func ListCalendars(ctx context.Context, srv *calendar.Service, opts ListOptions) ([]*CalendarInfo, error) {
+	roles := make(map[string]bool, len(opts.AccessRoles))
+	for _, role := range opts.AccessRoles {
+		roles[role] = true
+	}
+
+	var calendars []*CalendarInfo
+	err := Retry(ctx, "calendarList.list", func() error {
+		// 途中のページで失敗した場合は最初から取得し直す。
+		calendars = make([]*CalendarInfo, 0)
+		call := srv.CalendarList.List().ShowHidden(opts.ShowHidden)
+		if opts.MinAccessRole != "" {
+			call = call.MinAccessRole(opts.MinAccessRole)
+		}
+		return call.Pages(ctx, func(list *calendar.CalendarList) error {
+			for _, entry := range list.Items {
+				// 削除されたカレンダーはshowDeletedを指定しなければ返らないが、念のため除く。
+				if entry.Deleted {
+					continue
+				}
+				if len(roles) > 0 && !roles[entry.AccessRole] {
+					continue
+				}
+				calendars = append(calendars, newCalendarInfo(entry))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(calendars, func(i, j int) bool {
+		if calendars[i].Primary != calendars[j].Primary {
+			return calendars[i].Primary
+		}
+		return calendars[i].Summary < calendars[j].Summary
+	})
+	return calendars, nil
+}
+
+// Subscribe 共有されているカレンダーを自分のカレンダー一覧に追加する。
+func Subscribe(ctx context.Context, srv *calendar.Service, calendarId string) (*CalendarInfo, error) {
+	var entry *calendar.CalendarListEntry
+	err := Retry(ctx, "calendarList.insert", func() error {
+		var err error
+		entry, err = srv.CalendarList.Insert(&calendar.CalendarListEntry{Id: calendarId}).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newCalendarInfo(entry), nil
+}
+
+// Unsubscribe カレンダーを自分のカレンダー一覧から外す（カレンダー自体は削除されない）。
+func Unsubscribe(ctx context.Context, srv *calendar.Service, calendarId string) error {
+	return Retry(ctx, "calendarList.delete", func() error {
+		return srv.CalendarList.Delete(calendarId).Context(ctx).Do()
+	})
+}
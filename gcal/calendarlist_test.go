@@ -0,0 +1,102 @@
+package gcal
+
+import (
+	"context"
+	"encoding/json"
+	"google.golang.org/api/calendar/v3"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// fakeCalendarList CalendarList.Listの代わりに、minAccessRole・showHiddenで絞り込んだ一覧を1件ずつのページで返す。
+// 削除済みのカレンダーは（syncTokenで取得した場合と同じく）そのまま返す。
+type fakeCalendarList struct {
+	entries []*calendar.CalendarListEntry
+}
+
+func (f *fakeCalendarList) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rank := map[string]int{AccessRoleFreeBusyReader: 0, AccessRoleReader: 1, AccessRoleWriter: 2, AccessRoleOwner: 3}
+	q := r.URL.Query()
+	items := make([]*calendar.CalendarListEntry, 0)
+	for _, e := range f.entries {
+		if min := q.Get("minAccessRole"); min != "" && rank[e.AccessRole] < rank[min] {
+			continue
+		}
+		if e.Hidden && q.Get("showHidden") != "true" {
+			continue
+		}
+		items = append(items, e)
+	}
+	page := 0
+	if token := q.Get("pageToken"); token != "" {
+		json.Unmarshal([]byte(token), &page)
+	}
+	list := &calendar.CalendarList{Items: []*calendar.CalendarListEntry{}}
+	if page < len(items) {
+		list.Items = items[page : page+1]
+	}
+	if page+1 < len(items) {
+		b, _ := json.Marshal(page + 1)
+		list.NextPageToken = string(b)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func TestListCalendars(t *testing.T) {
+	f := &fakeCalendarList{entries: []*calendar.CalendarListEntry{
+		{Id: "team", Summary: "Team", AccessRole: AccessRoleWriter},
+		{Id: "holiday", Summary: "Holidays", AccessRole: AccessRoleReader},
+		{Id: "me", Summary: "me@example.com", AccessRole: AccessRoleOwner, Primary: true},
+		// 表示名を変えたカレンダーはSummaryOverrideの名前で並べる。
+		{Id: "room", Summary: "Zzz room", SummaryOverride: "Arena", AccessRole: AccessRoleFreeBusyReader},
+		{Id: "hidden", Summary: "Hidden", AccessRole: AccessRoleReader, Hidden: true},
+		{Id: "deleted", Summary: "Deleted", AccessRole: AccessRoleOwner, Deleted: true},
+	}}
+	tests := []struct {
+		name string
+		opts ListOptions
+		want []string
+	}{
+		{name: "default", want: []string{"me", "room", "holiday", "team"}},
+		{name: "show hidden", opts: ListOptions{ShowHidden: true}, want: []string{"me", "room", "hidden", "holiday", "team"}},
+		{name: "min access role", opts: ListOptions{MinAccessRole: AccessRoleWriter}, want: []string{"me", "team"}},
+		{name: "access roles", opts: ListOptions{AccessRoles: []string{AccessRoleFreeBusyReader, AccessRoleReader}}, want: []string{"room", "holiday"}},
+		{
+			name: "access roles with hidden",
+			opts: ListOptions{AccessRoles: []string{AccessRoleReader}, ShowHidden: true},
+			want: []string{"hidden", "holiday"},
+		},
+		{name: "no match", opts: ListOptions{MinAccessRole: AccessRoleReader, AccessRoles: []string{AccessRoleFreeBusyReader}}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calendars, err := ListCalendars(context.Background(), newFakeService(t, f), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(calendars))
+			for _, c := range calendars {
+				got = append(got, c.Id)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListCalendars() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCalendarInfo(t *testing.T) {
+	entry := &calendar.CalendarListEntry{
+		Id: "room", Summary: "Room", SummaryOverride: "Arena", AccessRole: AccessRoleReader, TimeZone: "Asia/Tokyo",
+		Selected: true, ColorId: "7", BackgroundColor: "#42d692", ForegroundColor: "#000000",
+	}
+	want := &CalendarInfo{
+		Id: "room", Summary: "Arena", AccessRole: AccessRoleReader, TimeZone: "Asia/Tokyo",
+		Selected: true, ColorId: "7", BackgroundColor: "#42d692", ForegroundColor: "#000000",
+	}
+	if got := newCalendarInfo(entry); !reflect.DeepEqual(got, want) {
+		t.Errorf("newCalendarInfo() = %+v, want %+v", got, want)
+	}
+}
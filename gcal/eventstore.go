@@ -0,0 +1,213 @@
+package gcal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"google.golang.org/api/calendar/v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventStore 同期した予定を保持するローカルのミラー
+type EventStore interface {
+	// SyncToken 次の差分同期に使うnextSyncTokenを返す。まだ同期していなければ空文字
+	SyncToken(ctx context.Context, calendarId string) (string, error)
+	// Apply 変更された予定を反映し、syncTokenを保存する。
+	// fullの場合は保持している予定をすべて置き換える。status: cancelled の予定は削除する。
+	Apply(ctx context.Context, calendarId string, changes []*calendar.Event, syncToken string, full bool) error
+	// Events 保持している予定を開始時刻順に返す。
+	Events(ctx context.Context, calendarId string) ([]*calendar.Event, error)
+}
+
+// mirror カレンダー1件分の同期状態
+type mirror struct {
+	SyncToken string                     `json:"syncToken"`
+	Events    map[string]*calendar.Event `json:"events"` // key: イベントID
+}
+
+func (m *mirror) apply(changes []*calendar.Event, syncToken string, full bool) {
+	if full || m.Events == nil {
+		m.Events = make(map[string]*calendar.Event)
+	}
+	for _, e := range changes {
+		if e.Status == "cancelled" {
+			delete(m.Events, e.Id)
+			continue
+		}
+		m.Events[e.Id] = e
+	}
+	m.SyncToken = syncToken
+}
+
+func (m *mirror) sorted() []*calendar.Event {
+	events := make([]*calendar.Event, 0, len(m.Events))
+	starts := make(map[string]time.Time, len(m.Events))
+	for _, e := range m.Events {
+		events = append(events, e)
+		starts[e.Id] = eventStart(e)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		si, sj := starts[events[i].Id], starts[events[j].Id]
+		if !si.Equal(sj) {
+			return si.Before(sj)
+		}
+		return events[i].Id < events[j].Id
+	})
+	return events
+}
+
+// eventStart 並べ替え用の開始日時
+//
+// dateTimeは時差が予定ごとに異なるため、文字列ではなく時刻で比較する。
+// 終日の予定（date）は予定のタイムゾーン（なければtime.Local）の0時とする。
+// 開始日時がない・解釈できない予定はゼロ値（先頭）にする。
+func eventStart(e *calendar.Event) time.Time {
+	if e.Start == nil {
+		return time.Time{}
+	}
+	if e.Start.DateTime != "" {
+		t, err := time.Parse(time.RFC3339, e.Start.DateTime)
+		if err != nil {
+			return time.Time{}
+		}
+		return t
+	}
+	loc := time.Local
+	if e.Start.TimeZone != "" {
+		if l, err := time.LoadLocation(e.Start.TimeZone); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("2006-01-02", e.Start.Date, loc)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// MemoryEventStore プロセス内で保持するEventStore
+type MemoryEventStore struct {
+	mu      sync.Mutex
+	mirrors map[string]*mirror
+}
+
+// NewMemoryEventStore 空のMemoryEventStoreを作成する。
+func NewMemoryEventStore() *MemoryEventStore {
+	return &MemoryEventStore{mirrors: make(map[string]*mirror)}
+}
+
+func (s *MemoryEventStore) SyncToken(_ context.Context, calendarId string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.mirrors[calendarId]; ok {
+		return m.SyncToken, nil
+	}
+	return "", nil
+}
+
+func (s *MemoryEventStore) Apply(_ context.Context, calendarId string, changes []*calendar.Event, syncToken string, full bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.mirrors[calendarId]
+	if !ok {
+		m = &mirror{}
+		s.mirrors[calendarId] = m
+	}
+	m.apply(changes, syncToken, full)
+	return nil
+}
+
+func (s *MemoryEventStore) Events(_ context.Context, calendarId string) ([]*calendar.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.mirrors[calendarId]
+	if !ok {
+		return []*calendar.Event{}, nil
+	}
+	return m.sorted(), nil
+}
+
+// FileEventStore カレンダーごとにJSONファイルで保持するEventStore
+type FileEventStore struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+func (s *FileEventStore) path(calendarId string) string {
+	// カレンダーIDには # などが含まれることがあるため、ハッシュをファイル名にする。
+	sum := sha256.Sum256([]byte(calendarId))
+	return filepath.Join(s.Dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+func (s *FileEventStore) load(calendarId string) (*mirror, error) {
+	m := &mirror{}
+	b, err := ioutil.ReadFile(s.path(calendarId))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *FileEventStore) SyncToken(_ context.Context, calendarId string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, err := s.load(calendarId)
+	if err != nil {
+		return "", err
+	}
+	return m.SyncToken, nil
+}
+
+func (s *FileEventStore) Apply(_ context.Context, calendarId string, changes []*calendar.Event, syncToken string, full bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, err := s.load(calendarId)
+	if err != nil {
+		return err
+	}
+	m.apply(changes, syncToken, full)
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	// 書き込み途中で止まっても前回の状態が残るよう、一時ファイルに書いてから置き換える。
+	f, err := ioutil.TempFile(s.Dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path(calendarId))
+}
+
+func (s *FileEventStore) Events(_ context.Context, calendarId string) ([]*calendar.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, err := s.load(calendarId)
+	if err != nil {
+		return nil, err
+	}
+	return m.sorted(), nil
+}
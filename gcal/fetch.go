@@ -0,0 +1,131 @@
+// Package gcal Google Calendar APIの呼び出しをまとめたもの
+package gcal
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/api/calendar/v3"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWorkers    = 4                // 同時に問い合わせるカレンダー数のデフォルト
+	DefaultTimeout    = 30 * time.Second // カレンダー1件あたりのタイムアウトのデフォルト
+	defaultMaxResults = 250
+)
+
+// CalendarEvents カレンダー1件分のEvents.Listの結果
+type CalendarEvents struct {
+	CalendarId string
+	Summary    string // カレンダー名
+	Items      []*calendar.Event
+}
+
+// CalendarError カレンダー1件分の取得エラー
+type CalendarError struct {
+	CalendarId string
+	Err        error
+}
+
+func (e *CalendarError) Error() string {
+	return fmt.Sprintf("calendar %s: %v", e.CalendarId, e.Err)
+}
+
+func (e *CalendarError) Unwrap() error {
+	return e.Err
+}
+
+// Fetcher 複数のカレンダーの予定を並行して取得する。
+//
+// 1件のカレンダーでエラーになっても他のカレンダーの取得は続け、
+// 取得できた分の結果とカレンダーごとのエラーを返す。
+type Fetcher struct {
+	Service  *calendar.Service
+	Workers  int           // 同時に問い合わせるカレンダー数（0以下はDefaultWorkers）
+	Timeout  time.Duration // カレンダー1件あたりのタイムアウト（0以下はDefaultTimeout）
+	TimeZone string
+}
+
+// NewFetcher デフォルトの並列数・タイムアウトでFetcherを作成する。
+func NewFetcher(service *calendar.Service, timeZone string) *Fetcher {
+	return &Fetcher{Service: service, Workers: DefaultWorkers, Timeout: DefaultTimeout, TimeZone: timeZone}
+}
+
+// FetchEvents calendarIdsの予定をtimeMin〜timeMaxの範囲で取得する。
+// 結果はcalendarIdsと同じ順序で、取得に失敗したカレンダーは結果に含まれずerrsに入る。
+func (f *Fetcher) FetchEvents(ctx context.Context, calendarIds []string, timeMin, timeMax string) ([]*CalendarEvents, []*CalendarError) {
+	workers := f.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if workers > len(calendarIds) {
+		workers = len(calendarIds)
+	}
+
+	// 結果の順序を固定するため、indexごとに結果を格納する。
+	results := make([]*CalendarEvents, len(calendarIds))
+	errs := make([]*CalendarError, len(calendarIds))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				events, err := f.fetchCalendar(ctx, calendarIds[i], timeMin, timeMax)
+				if err != nil {
+					errs[i] = &CalendarError{CalendarId: calendarIds[i], Err: err}
+					continue
+				}
+				results[i] = events
+			}
+		}()
+	}
+	for i := range calendarIds {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	fetched := make([]*CalendarEvents, 0, len(calendarIds))
+	calendarErrs := make([]*CalendarError, 0)
+	for i := range calendarIds {
+		if errs[i] != nil {
+			calendarErrs = append(calendarErrs, errs[i])
+			continue
+		}
+		fetched = append(fetched, results[i])
+	}
+	return fetched, calendarErrs
+}
+
+// fetchCalendar カレンダー1件分の予定をページングしながらすべて取得する。
+func (f *Fetcher) fetchCalendar(ctx context.Context, calendarId, timeMin, timeMax string) (*CalendarEvents, error) {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var result *CalendarEvents
+	err := Retry(ctx, "events.list", func() error {
+		// 途中のページで失敗した場合は最初から取得し直す。
+		result = &CalendarEvents{CalendarId: calendarId}
+		call := f.Service.Events.List(calendarId).MaxResults(defaultMaxResults).TimeMin(timeMin).TimeMax(timeMax)
+		if f.TimeZone != "" {
+			call = call.TimeZone(f.TimeZone)
+		}
+		return call.Pages(ctx, func(events *calendar.Events) error {
+			result.Summary = events.Summary
+			result.Items = append(result.Items, events.Items...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
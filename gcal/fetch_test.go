@@ -0,0 +1,159 @@
+package gcal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEventsList カレンダーごとにEvents.Listの結果を返す。failのカレンダーは404にする。
+// 同時に処理しているリクエスト数の最大値を記録する。
+type fakeEventsList struct {
+	pages map[string][][]string // key: カレンダーID value: ページごとの予定ID
+	fail  map[string]bool
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (f *fakeEventsList) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+	// 並行して問い合わせていれば重なるよう、少し待ってから返す。
+	time.Sleep(10 * time.Millisecond)
+
+	// /calendars/{calendarId}/events
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.EscapedPath(), "/calendars/"), "/events")
+	calendarId, _ := url.PathUnescape(path)
+	w.Header().Set("Content-Type", "application/json")
+	if f.fail[calendarId] {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"code":404,"message":"Not Found","errors":[{"domain":"global","reason":"notFound"}]}}`))
+		return
+	}
+	page := 0
+	fmt.Sscanf(r.URL.Query().Get("pageToken"), "page%d", &page)
+	events := &calendar.Events{Summary: "name of " + calendarId, Items: []*calendar.Event{}}
+	if pages := f.pages[calendarId]; page < len(pages) {
+		for _, id := range pages[page] {
+			events.Items = append(events.Items, &calendar.Event{Id: id})
+		}
+		if page+1 < len(pages) {
+			events.NextPageToken = fmt.Sprintf("page%d", page+1)
+		}
+	}
+	json.NewEncoder(w).Encode(events)
+}
+
+func TestFetchEvents(t *testing.T) {
+	f := &fakeEventsList{
+		pages: map[string][][]string{
+			"a@example.com": {{"a1", "a2"}},
+			// 複数ページの予定はすべて結合する。
+			"b@example.com": {{"b1"}, {"b2", "b3"}, {"b4"}},
+			"ja.japanese#holiday@group.v.calendar.google.com": {{"h1"}},
+		},
+		fail: map[string]bool{"missing@example.com": true},
+	}
+	ids := []string{"a@example.com", "missing@example.com", "b@example.com", "empty@example.com", "ja.japanese#holiday@group.v.calendar.google.com"}
+	tests := []struct {
+		name        string
+		workers     int
+		wantWorkers int // 同時に問い合わせるカレンダー数の上限
+	}{
+		{name: "default workers", workers: 0, wantWorkers: DefaultWorkers},
+		{name: "one worker", workers: 1, wantWorkers: 1},
+		{name: "two workers", workers: 2, wantWorkers: 2},
+		{name: "more workers than calendars", workers: 10, wantWorkers: len(ids)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f.maxInFlight = 0
+			fetcher := &Fetcher{Service: newFakeService(t, f), Workers: tt.workers, Timeout: 5 * time.Second}
+			results, errs := fetcher.FetchEvents(context.Background(), ids, "2022-04-18T00:00:00+09:00", "2022-04-19T00:00:00+09:00")
+
+			// 失敗したカレンダーを除き、calendarIdsと同じ順序で返る。
+			got := make(map[string][]string)
+			order := make([]string, 0, len(results))
+			for _, r := range results {
+				order = append(order, r.CalendarId)
+				if r.Summary != "name of "+r.CalendarId {
+					t.Errorf("%s: Summary = %q", r.CalendarId, r.Summary)
+				}
+				items := make([]string, 0, len(r.Items))
+				for _, item := range r.Items {
+					items = append(items, item.Id)
+				}
+				got[r.CalendarId] = items
+			}
+			wantOrder := []string{"a@example.com", "b@example.com", "empty@example.com", "ja.japanese#holiday@group.v.calendar.google.com"}
+			if !reflect.DeepEqual(order, wantOrder) {
+				t.Errorf("calendars = %v, want %v", order, wantOrder)
+			}
+			want := map[string][]string{
+				"a@example.com":     {"a1", "a2"},
+				"b@example.com":     {"b1", "b2", "b3", "b4"},
+				"empty@example.com": {},
+				"ja.japanese#holiday@group.v.calendar.google.com": {"h1"},
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("items = %v, want %v", got, want)
+			}
+
+			if len(errs) != 1 || errs[0].CalendarId != "missing@example.com" {
+				t.Fatalf("errs = %v, want only missing@example.com", errs)
+			}
+			var apiErr *googleapi.Error
+			if !errors.As(errs[0], &apiErr) || apiErr.Code != http.StatusNotFound {
+				t.Errorf("errs[0] = %v, want 404", errs[0])
+			}
+
+			f.mu.Lock()
+			maxInFlight := f.maxInFlight
+			f.mu.Unlock()
+			if maxInFlight > tt.wantWorkers {
+				t.Errorf("max concurrent requests = %d, want <= %d", maxInFlight, tt.wantWorkers)
+			}
+			if tt.wantWorkers > 1 && maxInFlight < 2 {
+				t.Errorf("max concurrent requests = %d, want concurrent fetches", maxInFlight)
+			}
+		})
+	}
+}
+
+// TestFetchEventsCanceled キャンセルされた場合はすべてのカレンダーがエラーになる。
+func TestFetchEventsCanceled(t *testing.T) {
+	f := &fakeEventsList{pages: map[string][][]string{"a@example.com": {{"a1"}}}}
+	fetcher := &Fetcher{Service: newFakeService(t, f), Workers: 2, Timeout: 5 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, errs := fetcher.FetchEvents(ctx, []string{"a@example.com", "b@example.com"}, "2022-04-18T00:00:00+09:00", "2022-04-19T00:00:00+09:00")
+	if len(results) != 0 || len(errs) != 2 {
+		t.Fatalf("FetchEvents() = %d results, %d errors, want 0, 2", len(results), len(errs))
+	}
+	for _, err := range errs {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%v: want context.Canceled", err)
+		}
+	}
+}
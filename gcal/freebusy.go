@@ -0,0 +1,348 @@
+package gcal
+
+import (
+	"context"
+	"fmt"
+	"google-calendar-sample/cache"
+	"google.golang.org/api/calendar/v3"
+	"sort"
+	"time"
+)
+
+const (
+	// FreeBusyMaxItems FreeBusyRequest 1回あたりのカレンダー数の上限
+	FreeBusyMaxItems = 50
+	// FreeBusyMaxRange FreeBusyRequest 1回あたりの期間（timeMin〜timeMax）の上限
+	FreeBusyMaxRange = 14 * 24 * time.Hour
+	// FreeBusyMaxGroupExpansion 1グループあたりに展開できるカレンダー数の上限
+	FreeBusyMaxGroupExpansion = 100
+	// FreeBusyMaxCalendarExpansion 1回あたりに返却されるカレンダー数の上限
+	FreeBusyMaxCalendarExpansion = 50
+)
+
+// FreeBusyError FreeBusyCalendar.Errors / FreeBusyGroup.Errorsで返ってきたカレンダー（グループ）ごとのエラー
+// ex: Reason: "notFound", "internalError", "groupTooBig", "tooManyCalendarsRequested"
+type FreeBusyError struct {
+	CalendarId string
+	Domain     string
+	Reason     string
+}
+
+func (e *FreeBusyError) Error() string {
+	return fmt.Sprintf("calendar %s: %s (%s)", e.CalendarId, e.Reason, e.Domain)
+}
+
+// FreeBusyResult 分割して問い合わせたFreeBusyの結果をまとめたもの
+type FreeBusyResult struct {
+	TimeMin time.Time
+	TimeMax time.Time
+	// Busy key: カレンダーID value: 開始時刻順に並べ、重なり・隣接を結合した予定ありの期間
+	Busy map[string][]*calendar.TimePeriod
+	// Groups key: グループのメールアドレス value: 展開されたメンバーのカレンダーID（昇順）
+	Groups map[string][]string
+	// Errors カレンダー・グループごとのエラー（ID・理由の昇順）
+	Errors []*FreeBusyError
+}
+
+// CalendarIds エラーなく取得できたカレンダーID（グループ自体は除く）を昇順で返す。
+// グループのメンバーとして展開されたカレンダーも含まれる。
+func (r *FreeBusyResult) CalendarIds() []string {
+	failed := make(map[string]bool)
+	for _, e := range r.Errors {
+		failed[e.CalendarId] = true
+	}
+	ids := make([]string, 0, len(r.Busy))
+	for id := range r.Busy {
+		if _, isGroup := r.Groups[id]; isGroup || failed[id] {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// FreeBusyClient カレンダー数・期間をAPIの上限内に分割してFreeBusyを問い合わせる。
+type FreeBusyClient struct {
+	Service  *calendar.Service
+	MaxItems int           // 1回あたりのカレンダー数（0以下はFreeBusyMaxItems）
+	MaxRange time.Duration // 1回あたりの期間（0以下はFreeBusyMaxRange）
+	TimeZone string
+	// GroupExpansionMax 1グループあたりに展開するカレンダー数（0以下・上限超えはFreeBusyMaxGroupExpansion）
+	GroupExpansionMax int
+	// CalendarExpansionMax 1回あたりに返却するカレンダー数（0以下・上限超えはFreeBusyMaxCalendarExpansion）
+	CalendarExpansionMax int
+	// Cache カレンダーごと・日ごとの予定ありの期間のキャッシュ（nilはキャッシュしない）
+	Cache *cache.BusyCache
+}
+
+// NewFreeBusyClient APIの上限に合わせたFreeBusyClientを作成する。
+func NewFreeBusyClient(service *calendar.Service, timeZone string) *FreeBusyClient {
+	return &FreeBusyClient{
+		Service:              service,
+		MaxItems:             FreeBusyMaxItems,
+		MaxRange:             FreeBusyMaxRange,
+		TimeZone:             timeZone,
+		GroupExpansionMax:    FreeBusyMaxGroupExpansion,
+		CalendarExpansionMax: FreeBusyMaxCalendarExpansion,
+	}
+}
+
+// Query calendarIdsのtimeMin〜timeMaxの予定ありの期間を取得する。
+// calendarIdsにはGoogleグループのメールアドレスも指定でき、メンバーのカレンダーに展開される。
+//
+// ex: 120カレンダー × 30日の場合
+// カレンダー: [0:50] [50:100] [100:120] の3分割
+// 期間      : [0d:14d] [14d:28d] [28d:30d] の3分割
+// → 9回問い合わせて、カレンダーごとに予定ありの期間を結合する。
+//
+// Cacheがある場合は、期間を含む日単位で問い合わせてキャッシュし、キャッシュにあるカレンダーは問い合わせない。
+func (c *FreeBusyClient) Query(ctx context.Context, calendarIds []string, timeMin, timeMax time.Time) (*FreeBusyResult, error) {
+	if !timeMin.Before(timeMax) {
+		return nil, fmt.Errorf("timeMin must be before timeMax: %v - %v", timeMin, timeMax)
+	}
+	if c.Cache != nil {
+		return c.queryCached(ctx, calendarIds, timeMin, timeMax)
+	}
+	return c.queryAll(ctx, calendarIds, timeMin, timeMax)
+}
+
+// queryAll カレンダー数・期間を分割して問い合わせ、結果を結合する。
+func (c *FreeBusyClient) queryAll(ctx context.Context, calendarIds []string, timeMin, timeMax time.Time) (*FreeBusyResult, error) {
+	maxItems := c.MaxItems
+	if maxItems <= 0 {
+		maxItems = FreeBusyMaxItems
+	}
+	maxRange := c.MaxRange
+	if maxRange <= 0 {
+		maxRange = FreeBusyMaxRange
+	}
+
+	result := &FreeBusyResult{TimeMin: timeMin, TimeMax: timeMax, Busy: make(map[string][]*calendar.TimePeriod), Groups: make(map[string][]string)}
+	errs := make(map[string]*FreeBusyError)
+	// key: グループ -> メンバーのカレンダーID（期間ごとに同じメンバーが返るため重複を除く）
+	members := make(map[string]map[string]bool)
+	for start := timeMin; start.Before(timeMax); start = start.Add(maxRange) {
+		end := start.Add(maxRange)
+		if end.After(timeMax) {
+			end = timeMax
+		}
+		for i := 0; i < len(calendarIds); i += maxItems {
+			j := i + maxItems
+			if j > len(calendarIds) {
+				j = len(calendarIds)
+			}
+			resp, err := c.query(ctx, calendarIds[i:j], start, end)
+			if err != nil {
+				return nil, err
+			}
+			for id, cal := range resp.Calendars {
+				for _, e := range cal.Errors {
+					errs[id+"/"+e.Reason] = &FreeBusyError{CalendarId: id, Domain: e.Domain, Reason: e.Reason}
+				}
+				result.Busy[id] = append(result.Busy[id], cal.Busy...)
+			}
+			for id, group := range resp.Groups {
+				for _, e := range group.Errors {
+					errs[id+"/"+e.Reason] = &FreeBusyError{CalendarId: id, Domain: e.Domain, Reason: e.Reason}
+				}
+				if _, ok := members[id]; !ok {
+					members[id] = make(map[string]bool)
+				}
+				for _, member := range group.Calendars {
+					members[id][member] = true
+				}
+			}
+		}
+	}
+
+	for id, set := range members {
+		ids := make([]string, 0, len(set))
+		for member := range set {
+			ids = append(ids, member)
+		}
+		sort.Strings(ids)
+		result.Groups[id] = ids
+	}
+
+	for id, periods := range result.Busy {
+		merged, err := MergeTimePeriods(periods)
+		if err != nil {
+			return nil, fmt.Errorf("calendar %s: %w", id, err)
+		}
+		result.Busy[id] = merged
+	}
+	result.Errors = make([]*FreeBusyError, 0, len(errs))
+	for _, e := range errs {
+		result.Errors = append(result.Errors, e)
+	}
+	sort.Slice(result.Errors, func(i, j int) bool {
+		if result.Errors[i].CalendarId != result.Errors[j].CalendarId {
+			return result.Errors[i].CalendarId < result.Errors[j].CalendarId
+		}
+		return result.Errors[i].Reason < result.Errors[j].Reason
+	})
+	return result, nil
+}
+
+// queryCached キャッシュにないカレンダーのみ、timeMin〜timeMaxを含む日単位で問い合わせる。
+// 取得した予定ありの期間は日ごとにキャッシュし、timeMin〜timeMaxに切り取って返す。
+// グループ・エラーになったカレンダーはキャッシュしない。
+func (c *FreeBusyClient) queryCached(ctx context.Context, calendarIds []string, timeMin, timeMax time.Time) (*FreeBusyResult, error) {
+	loc := c.Cache.Location
+	dayMin := timeMin.In(loc)
+	dayMin = time.Date(dayMin.Year(), dayMin.Month(), dayMin.Day(), 0, 0, 0, 0, loc)
+	dayMax := timeMax.In(loc)
+	if dayMax = time.Date(dayMax.Year(), dayMax.Month(), dayMax.Day(), 0, 0, 0, 0, loc); dayMax.Before(timeMax) {
+		dayMax = dayMax.AddDate(0, 0, 1)
+	}
+
+	cached := make(map[string][]cache.Busy)
+	misses := make([]string, 0, len(calendarIds))
+	for _, id := range calendarIds {
+		busy, ok, err := c.Cache.Get(ctx, cache.NamespaceFreeBusy, id, timeMin, timeMax)
+		if err != nil {
+			// キャッシュが読めない場合はAPIから取得する。
+			ok = false
+		}
+		if ok {
+			cached[id] = busy
+			continue
+		}
+		misses = append(misses, id)
+	}
+
+	result := &FreeBusyResult{Busy: make(map[string][]*calendar.TimePeriod), Groups: make(map[string][]string), Errors: make([]*FreeBusyError, 0)}
+	if len(misses) > 0 {
+		fetched, err := c.queryAll(ctx, misses, dayMin, dayMax)
+		if err != nil {
+			return nil, err
+		}
+		result = fetched
+		for _, id := range fetched.CalendarIds() {
+			busy := make([]cache.Busy, 0, len(fetched.Busy[id]))
+			for _, p := range fetched.Busy[id] {
+				start, err := time.Parse(time.RFC3339, p.Start)
+				if err != nil {
+					return nil, err
+				}
+				end, err := time.Parse(time.RFC3339, p.End)
+				if err != nil {
+					return nil, err
+				}
+				busy = append(busy, cache.Busy{Start: start, End: end})
+			}
+			if err := c.Cache.Put(ctx, cache.NamespaceFreeBusy, id, dayMin, dayMax, busy); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for id, busy := range cached {
+		periods := make([]*calendar.TimePeriod, 0, len(busy))
+		for _, b := range busy {
+			periods = append(periods, &calendar.TimePeriod{Start: b.Start.Format(time.RFC3339), End: b.End.Format(time.RFC3339)})
+		}
+		result.Busy[id] = periods
+	}
+
+	// 日単位で問い合わせた分を、指定された期間に切り取る。
+	result.TimeMin, result.TimeMax = timeMin, timeMax
+	for id, periods := range result.Busy {
+		clipped := make([]*calendar.TimePeriod, 0, len(periods))
+		for _, p := range periods {
+			start, err := time.Parse(time.RFC3339, p.Start)
+			if err != nil {
+				return nil, err
+			}
+			end, err := time.Parse(time.RFC3339, p.End)
+			if err != nil {
+				return nil, err
+			}
+			if start.Before(timeMin) {
+				start = timeMin
+			}
+			if end.After(timeMax) {
+				end = timeMax
+			}
+			if start.Before(end) {
+				clipped = append(clipped, &calendar.TimePeriod{Start: start.Format(time.RFC3339), End: end.Format(time.RFC3339)})
+			}
+		}
+		merged, err := MergeTimePeriods(clipped)
+		if err != nil {
+			return nil, fmt.Errorf("calendar %s: %w", id, err)
+		}
+		result.Busy[id] = merged
+	}
+	return result, nil
+}
+
+// query 分割した1回分のFreeBusyRequestを送る。
+func (c *FreeBusyClient) query(ctx context.Context, calendarIds []string, timeMin, timeMax time.Time) (*calendar.FreeBusyResponse, error) {
+	items := make([]*calendar.FreeBusyRequestItem, 0, len(calendarIds))
+	for _, id := range calendarIds {
+		items = append(items, &calendar.FreeBusyRequestItem{Id: id})
+	}
+	request := &calendar.FreeBusyRequest{
+		CalendarExpansionMax: int64(clampLimit(c.CalendarExpansionMax, FreeBusyMaxCalendarExpansion)),
+		GroupExpansionMax:    int64(clampLimit(c.GroupExpansionMax, FreeBusyMaxGroupExpansion)),
+		Items:                items,
+		TimeMin:              timeMin.Format(time.RFC3339),
+		TimeMax:              timeMax.Format(time.RFC3339),
+		TimeZone:             c.TimeZone,
+	}
+	var resp *calendar.FreeBusyResponse
+	err := Retry(ctx, "freebusy.query", func() error {
+		var err error
+		resp, err = c.Service.Freebusy.Query(request).Context(ctx).Do()
+		return err
+	})
+	return resp, err
+}
+
+// clampLimit 0以下・上限超えの値を上限に丸める。
+func clampLimit(v, max int) int {
+	if v <= 0 || v > max {
+		return max
+	}
+	return v
+}
+
+// MergeTimePeriods 予定ありの期間を開始時刻順に並べ、重なっている・隣接している期間を1つにまとめる。
+//
+// 期間を分割して問い合わせると、境界をまたぐ予定が2つに分かれて返ってくるため結合する。
+// ex: [10:00-12:00] [11:00-13:00] [13:00-14:00] [15:00-16:00]
+// -> [10:00-14:00] [15:00-16:00]
+func MergeTimePeriods(periods []*calendar.TimePeriod) ([]*calendar.TimePeriod, error) {
+	type span struct{ start, end time.Time }
+	spans := make([]span, 0, len(periods))
+	for _, p := range periods {
+		if p == nil {
+			continue
+		}
+		start, err := time.Parse(time.RFC3339, p.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := time.Parse(time.RFC3339, p.End)
+		if err != nil {
+			return nil, err
+		}
+		spans = append(spans, span{start: start, end: end})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start.Before(spans[j].start) })
+
+	merged := make([]*calendar.TimePeriod, 0, len(spans))
+	for i := 0; i < len(spans); {
+		current := spans[i]
+		i++
+		for i < len(spans) && !spans[i].start.After(current.end) {
+			if spans[i].end.After(current.end) {
+				current.end = spans[i].end
+			}
+			i++
+		}
+		merged = append(merged, &calendar.TimePeriod{Start: current.start.Format(time.RFC3339), End: current.end.Format(time.RFC3339)})
+	}
+	return merged, nil
+}
@@ -0,0 +1,400 @@
+package gcal
+
+import (
+	"context"
+	"encoding/json"
+	"google-calendar-sample/cache"
+	"google.golang.org/api/calendar/v3"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var tokyo = time.FixedZone("JST", 9*60*60)
+
+// day 2022/04/dd hh:mm (JST)
+func day(dd, hh, mm int) time.Time {
+	return time.Date(2022, 4, dd, hh, mm, 0, 0, tokyo)
+}
+
+func period(start, end time.Time) *calendar.TimePeriod {
+	return &calendar.TimePeriod{Start: start.Format(time.RFC3339), End: end.Format(time.RFC3339)}
+}
+
+// fakeFreeBusy Freebusy.Queryの代わりに、リクエストの期間に切り取った予定ありの期間を返す。
+// busyにないカレンダーはnotFound、GroupExpansionMaxより多いメンバーのグループはgroupTooBigのエラーにする。
+type fakeFreeBusy struct {
+	busy   map[string][]*calendar.TimePeriod // key: カレンダーID
+	groups map[string][]string               // key: グループ value: メンバー
+
+	mu       sync.Mutex
+	requests []*calendar.FreeBusyRequest
+}
+
+func (f *fakeFreeBusy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req calendar.FreeBusyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, &req)
+	f.mu.Unlock()
+
+	timeMin, _ := time.Parse(time.RFC3339, req.TimeMin)
+	timeMax, _ := time.Parse(time.RFC3339, req.TimeMax)
+	resp := &calendar.FreeBusyResponse{Calendars: make(map[string]calendar.FreeBusyCalendar), Groups: make(map[string]calendar.FreeBusyGroup)}
+	ids := make([]string, 0)
+	for _, item := range req.Items {
+		members, isGroup := f.groups[item.Id]
+		if !isGroup {
+			ids = append(ids, item.Id)
+			continue
+		}
+		if int64(len(members)) > req.GroupExpansionMax {
+			resp.Groups[item.Id] = calendar.FreeBusyGroup{Errors: []*calendar.Error{{Domain: "global", Reason: "groupTooBig"}}}
+			continue
+		}
+		resp.Groups[item.Id] = calendar.FreeBusyGroup{Calendars: members}
+		ids = append(ids, members...)
+	}
+	for _, id := range ids {
+		periods, ok := f.busy[id]
+		if !ok {
+			resp.Calendars[id] = calendar.FreeBusyCalendar{Errors: []*calendar.Error{{Domain: "global", Reason: "notFound"}}}
+			continue
+		}
+		clipped := make([]*calendar.TimePeriod, 0)
+		for _, p := range periods {
+			start, _ := time.Parse(time.RFC3339, p.Start)
+			end, _ := time.Parse(time.RFC3339, p.End)
+			if start.Before(timeMin) {
+				start = timeMin
+			}
+			if end.After(timeMax) {
+				end = timeMax
+			}
+			if start.Before(end) {
+				clipped = append(clipped, period(start, end))
+			}
+		}
+		resp.Calendars[id] = calendar.FreeBusyCalendar{Busy: clipped}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeFreeBusy) Requests() []*calendar.FreeBusyRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*calendar.FreeBusyRequest(nil), f.requests...)
+}
+
+func TestFreeBusyQueryChunks(t *testing.T) {
+	f := &fakeFreeBusy{busy: map[string][]*calendar.TimePeriod{
+		// 期間の区切り（04/19 0時）をまたぐ予定は分割して返り、結合される。
+		"a": {period(day(18, 23, 0), day(19, 1, 0)), period(day(19, 1, 0), day(19, 2, 0)), period(day(20, 9, 0), day(20, 10, 0))},
+		"b": {period(day(18, 9, 0), day(18, 10, 0)), period(day(18, 9, 30), day(18, 11, 0))},
+		"c": {},
+		"d": {period(day(17, 0, 0), day(21, 0, 0))},
+		"e": {period(day(20, 11, 0), day(20, 13, 0))},
+	}}
+	ids := []string{"a", "b", "c", "d", "e"}
+	wantBusy := map[string][]*calendar.TimePeriod{
+		"a": {period(day(18, 23, 0), day(19, 2, 0)), period(day(20, 9, 0), day(20, 10, 0))},
+		"b": {period(day(18, 9, 0), day(18, 11, 0))},
+		"c": {},
+		"d": {period(day(18, 0, 0), day(20, 12, 0))},
+		"e": {period(day(20, 11, 0), day(20, 12, 0))},
+	}
+	tests := []struct {
+		name         string
+		maxItems     int
+		maxRange     time.Duration
+		wantRequests int
+	}{
+		{name: "single request", wantRequests: 1},
+		{name: "by calendars", maxItems: 2, wantRequests: 3},
+		{name: "by range", maxRange: 24 * time.Hour, wantRequests: 3},
+		{name: "by calendars and range", maxItems: 2, maxRange: 24 * time.Hour, wantRequests: 9},
+		{name: "one calendar per request", maxItems: 1, maxRange: 12 * time.Hour, wantRequests: 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f.requests = nil
+			c := &FreeBusyClient{Service: newFakeService(t, f), MaxItems: tt.maxItems, MaxRange: tt.maxRange}
+			timeMin, timeMax := day(18, 0, 0), day(20, 12, 0)
+			result, err := c.Query(context.Background(), ids, timeMin, timeMax)
+			if err != nil {
+				t.Fatal(err)
+			}
+			requests := f.Requests()
+			if len(requests) != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", len(requests), tt.wantRequests)
+			}
+			// すべてのカレンダー × 期間を、上限内のリクエストで1回ずつ問い合わせる。
+			covered := make(map[string]time.Duration)
+			for _, req := range requests {
+				start, _ := time.Parse(time.RFC3339, req.TimeMin)
+				end, _ := time.Parse(time.RFC3339, req.TimeMax)
+				if tt.maxItems > 0 && len(req.Items) > tt.maxItems {
+					t.Errorf("request has %d items, want <= %d", len(req.Items), tt.maxItems)
+				}
+				if tt.maxRange > 0 && end.Sub(start) > tt.maxRange {
+					t.Errorf("request range %v - %v exceeds %v", start, end, tt.maxRange)
+				}
+				for _, item := range req.Items {
+					covered[item.Id] += end.Sub(start)
+				}
+			}
+			for _, id := range ids {
+				if covered[id] != timeMax.Sub(timeMin) {
+					t.Errorf("%s is queried for %v, want %v", id, covered[id], timeMax.Sub(timeMin))
+				}
+			}
+			if !reflect.DeepEqual(result.Busy, wantBusy) {
+				t.Errorf("Busy = %s, want %s", dumpBusy(result.Busy), dumpBusy(wantBusy))
+			}
+			if got := result.CalendarIds(); !reflect.DeepEqual(got, ids) {
+				t.Errorf("CalendarIds() = %v, want %v", got, ids)
+			}
+		})
+	}
+}
+
+func dumpBusy(busy map[string][]*calendar.TimePeriod) string {
+	b, _ := json.Marshal(busy)
+	return string(b)
+}
+
+func TestFreeBusyQueryInvalidRange(t *testing.T) {
+	c := &FreeBusyClient{Service: newFakeService(t, &fakeFreeBusy{})}
+	if _, err := c.Query(context.Background(), []string{"a"}, day(18, 9, 0), day(18, 9, 0)); err == nil {
+		t.Error("Query with empty range: want error")
+	}
+}
+
+func TestFreeBusyResultCalendarIds(t *testing.T) {
+	tests := []struct {
+		name   string
+		result *FreeBusyResult
+		want   []string
+	}{
+		{name: "empty", result: &FreeBusyResult{}, want: []string{}},
+		{
+			name:   "sorted",
+			result: &FreeBusyResult{Busy: map[string][]*calendar.TimePeriod{"c": nil, "a": nil, "b": nil}},
+			want:   []string{"a", "b", "c"},
+		},
+		{
+			name: "without groups and errors",
+			result: &FreeBusyResult{
+				Busy:   map[string][]*calendar.TimePeriod{"team": nil, "a": nil, "b": nil, "missing": nil},
+				Groups: map[string][]string{"team": {"a", "b"}},
+				Errors: []*FreeBusyError{{CalendarId: "missing", Reason: "notFound"}},
+			},
+			want: []string{"a", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.result.CalendarIds(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CalendarIds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFreeBusyQueryCached 日単位で問い合わせてキャッシュし、指定された期間に切り取って返すことを確認する。
+func TestFreeBusyQueryCached(t *testing.T) {
+	f := &fakeFreeBusy{busy: map[string][]*calendar.TimePeriod{
+		"a": {period(day(18, 8, 0), day(18, 10, 0)), period(day(18, 16, 0), day(18, 18, 0)), period(day(19, 9, 0), day(19, 10, 0))},
+		"b": {period(day(17, 22, 0), day(18, 9, 30))},
+	}}
+	busyCache := cache.NewBusyCache(cache.NewLRU(100), time.Minute)
+	busyCache.Location = tokyo
+	c := &FreeBusyClient{Service: newFakeService(t, f), Cache: busyCache}
+
+	tests := []struct {
+		name             string
+		timeMin, timeMax time.Time
+		want             map[string][]*calendar.TimePeriod
+		// 問い合わせたカレンダーと期間。nilは問い合わせない（キャッシュにある）
+		wantRequest []string
+		wantMin     time.Time
+		wantMax     time.Time
+	}{
+		{
+			name:    "fetch whole days",
+			timeMin: day(18, 9, 30), timeMax: day(18, 17, 0),
+			want: map[string][]*calendar.TimePeriod{
+				"a": {period(day(18, 9, 30), day(18, 10, 0)), period(day(18, 16, 0), day(18, 17, 0))},
+				"b": {},
+				"x": {},
+			},
+			wantRequest: []string{"a", "b", "x"},
+			wantMin:     day(18, 0, 0), wantMax: day(19, 0, 0),
+		},
+		{
+			// エラーになったカレンダーはキャッシュしない。
+			name:    "cached except errors",
+			timeMin: day(18, 8, 30), timeMax: day(18, 12, 0),
+			want: map[string][]*calendar.TimePeriod{
+				"a": {period(day(18, 8, 30), day(18, 10, 0))},
+				"b": {period(day(18, 8, 30), day(18, 9, 30))},
+				"x": {},
+			},
+			wantRequest: []string{"x"},
+			wantMin:     day(18, 0, 0), wantMax: day(19, 0, 0),
+		},
+		{
+			name:    "partially cached days are fetched again",
+			timeMin: day(18, 23, 0), timeMax: day(19, 9, 30),
+			want: map[string][]*calendar.TimePeriod{
+				"a": {period(day(19, 9, 0), day(19, 9, 30))},
+				"b": {},
+				"x": {},
+			},
+			wantRequest: []string{"a", "b", "x"},
+			wantMin:     day(18, 0, 0), wantMax: day(20, 0, 0),
+		},
+		{
+			name:    "all cached",
+			timeMin: day(19, 0, 0), timeMax: day(19, 12, 0),
+			want: map[string][]*calendar.TimePeriod{
+				"a": {period(day(19, 9, 0), day(19, 10, 0))},
+				"b": {},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f.requests = nil
+			ids := make([]string, 0, len(tt.want))
+			for _, id := range []string{"a", "b", "x"} {
+				if _, ok := tt.want[id]; ok {
+					ids = append(ids, id)
+				}
+			}
+			result, err := c.Query(context.Background(), ids, tt.timeMin, tt.timeMax)
+			if err != nil {
+				t.Fatal(err)
+			}
+			requests := f.Requests()
+			if tt.wantRequest == nil {
+				if len(requests) != 0 {
+					t.Errorf("requests = %d, want 0", len(requests))
+				}
+			} else {
+				if len(requests) != 1 {
+					t.Fatalf("requests = %d, want 1", len(requests))
+				}
+				got := make([]string, 0)
+				for _, item := range requests[0].Items {
+					got = append(got, item.Id)
+				}
+				if !reflect.DeepEqual(got, tt.wantRequest) {
+					t.Errorf("requested calendars = %v, want %v", got, tt.wantRequest)
+				}
+				if requests[0].TimeMin != tt.wantMin.Format(time.RFC3339) || requests[0].TimeMax != tt.wantMax.Format(time.RFC3339) {
+					t.Errorf("requested range = %s - %s, want %v - %v", requests[0].TimeMin, requests[0].TimeMax, tt.wantMin, tt.wantMax)
+				}
+			}
+			if !result.TimeMin.Equal(tt.timeMin) || !result.TimeMax.Equal(tt.timeMax) {
+				t.Errorf("range = %v - %v, want %v - %v", result.TimeMin, result.TimeMax, tt.timeMin, tt.timeMax)
+			}
+			if !reflect.DeepEqual(result.Busy, tt.want) {
+				t.Errorf("Busy = %s, want %s", dumpBusy(result.Busy), dumpBusy(tt.want))
+			}
+		})
+	}
+}
+
+func TestFreeBusyQueryGroups(t *testing.T) {
+	f := &fakeFreeBusy{
+		busy: map[string][]*calendar.TimePeriod{
+			"a": {period(day(18, 9, 0), day(18, 10, 0))},
+			"b": {period(day(19, 9, 0), day(19, 10, 0))},
+			"c": {},
+		},
+		groups: map[string][]string{
+			"team": {"b", "a"},
+			"all":  {"a", "b", "c"},
+		},
+	}
+	tests := []struct {
+		name              string
+		groupExpansionMax int
+		ids               []string
+		wantExpansionMax  int64 // リクエストのGroupExpansionMax
+		wantGroups        map[string][]string
+		wantErrors        []*FreeBusyError
+		wantCalendarIds   []string
+	}{
+		{
+			name:             "expanded with the default limit",
+			ids:              []string{"team"},
+			wantExpansionMax: FreeBusyMaxGroupExpansion,
+			wantGroups:       map[string][]string{"team": {"a", "b"}},
+			wantErrors:       []*FreeBusyError{},
+			wantCalendarIds:  []string{"a", "b"},
+		},
+		{
+			name:              "limit above the API maximum is clamped",
+			groupExpansionMax: FreeBusyMaxGroupExpansion + 1,
+			ids:               []string{"team", "c"},
+			wantExpansionMax:  FreeBusyMaxGroupExpansion,
+			wantGroups:        map[string][]string{"team": {"a", "b"}},
+			wantErrors:        []*FreeBusyError{},
+			wantCalendarIds:   []string{"a", "b", "c"},
+		},
+		{
+			name:              "group too big",
+			groupExpansionMax: 2,
+			ids:               []string{"all", "team"},
+			wantExpansionMax:  2,
+			wantGroups:        map[string][]string{"all": {}, "team": {"a", "b"}},
+			wantErrors:        []*FreeBusyError{{CalendarId: "all", Domain: "global", Reason: "groupTooBig"}},
+			wantCalendarIds:   []string{"a", "b"},
+		},
+		{
+			name:             "member also requested directly",
+			ids:              []string{"a", "team"},
+			wantExpansionMax: FreeBusyMaxGroupExpansion,
+			wantGroups:       map[string][]string{"team": {"a", "b"}},
+			wantErrors:       []*FreeBusyError{},
+			wantCalendarIds:  []string{"a", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f.requests = nil
+			// 期間を分割しても、グループのメンバーは重複しない。
+			c := &FreeBusyClient{Service: newFakeService(t, f), MaxRange: 24 * time.Hour, GroupExpansionMax: tt.groupExpansionMax}
+			result, err := c.Query(context.Background(), tt.ids, day(18, 0, 0), day(20, 0, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, req := range f.Requests() {
+				if req.GroupExpansionMax != tt.wantExpansionMax {
+					t.Errorf("GroupExpansionMax = %d, want %d", req.GroupExpansionMax, tt.wantExpansionMax)
+				}
+			}
+			if !reflect.DeepEqual(result.Groups, tt.wantGroups) {
+				t.Errorf("Groups = %v, want %v", result.Groups, tt.wantGroups)
+			}
+			if !reflect.DeepEqual(result.Errors, tt.wantErrors) {
+				t.Errorf("Errors = %v, want %v", result.Errors, tt.wantErrors)
+			}
+			if got := result.CalendarIds(); !reflect.DeepEqual(got, tt.wantCalendarIds) {
+				t.Errorf("CalendarIds() = %v, want %v", got, tt.wantCalendarIds)
+			}
+			// メンバーの予定ありの期間も結合して返る。
+			if got := result.Busy["a"]; len(got) != 1 || got[0].Start != day(18, 9, 0).Format(time.RFC3339) {
+				t.Errorf(`Busy["a"] = %s`, dumpBusy(map[string][]*calendar.TimePeriod{"a": got}))
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package gcal
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/api/calendar/v3"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LocalNotifier Googleの代わりにプッシュ通知を送るChannelAPI（ローカルでの確認用）
+//
+// Watchしたチャンネルを保持し、Notifyでそのチャンネルのアドレスへ通知と同じヘッダーでPOSTする。
+// ex:
+// notifier := gcal.NewLocalNotifier()
+// manager := gcal.NewWatchManager(notifier, "http://localhost:8080/notifications")
+// notifier.Notify(ctx, "example@gmail.com", "exists")
+type LocalNotifier struct {
+	Client *http.Client
+
+	mu            sync.Mutex
+	channels      map[string]*calendar.Channel // key: チャンネルID
+	calendars     map[string]string            // key: チャンネルID value: カレンダーID
+	messageNumber int
+	now           func() time.Time
+}
+
+// NewLocalNotifier 空のLocalNotifierを作成する。
+func NewLocalNotifier() *LocalNotifier {
+	return &LocalNotifier{
+		Client:    http.DefaultClient,
+		channels:  make(map[string]*calendar.Channel),
+		calendars: make(map[string]string),
+		now:       time.Now,
+	}
+}
+
+func (n *LocalNotifier) Watch(_ context.Context, calendarId string, channel *calendar.Channel) (*calendar.Channel, error) {
+	ttl := DefaultChannelTTL
+	if v, ok := channel.Params["ttl"]; ok {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl: %q", v)
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	resp := *channel
+	resp.ResourceId = "local-" + calendarId
+	resp.Expiration = n.now().Add(ttl).UnixNano() / int64(time.Millisecond)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.channels[channel.Id] = &resp
+	n.calendars[channel.Id] = calendarId
+	return &resp, nil
+}
+
+func (n *LocalNotifier) Stop(_ context.Context, channel *calendar.Channel) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.channels[channel.Id]; !ok {
+		return fmt.Errorf("channel %s not found", channel.Id)
+	}
+	delete(n.channels, channel.Id)
+	delete(n.calendars, channel.Id)
+	return nil
+}
+
+// Notify calendarIdのチャンネルすべてにstate（sync / exists / not_exists）を通知する。
+func (n *LocalNotifier) Notify(ctx context.Context, calendarId, state string) error {
+	n.mu.Lock()
+	targets := make([]*calendar.Channel, 0)
+	for id, ch := range n.channels {
+		if n.calendars[id] == calendarId {
+			targets = append(targets, ch)
+		}
+	}
+	n.mu.Unlock()
+	if len(targets) == 0 {
+		return fmt.Errorf("no channel for %s", calendarId)
+	}
+
+	for _, ch := range targets {
+		n.mu.Lock()
+		n.messageNumber++
+		number := n.messageNumber
+		n.mu.Unlock()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, ch.Address, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(HeaderChannelId, ch.Id)
+		req.Header.Set(HeaderChannelToken, ch.Token)
+		req.Header.Set(HeaderResourceId, ch.ResourceId)
+		req.Header.Set(HeaderResourceState, state)
+		req.Header.Set(HeaderMessageNumber, strconv.Itoa(number))
+		resp, err := n.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("notify %s: %s", ch.Address, resp.Status)
+		}
+	}
+	return nil
+}
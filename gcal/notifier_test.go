@@ -0,0 +1,292 @@
+package gcal
+
+import (
+	"context"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEvents Events.Listの代わりに、受け取ったsyncToken（全件は""）ごとに決めたレスポンスを返す。
+// 2ページ目以降は "page=<pageToken>" をキーにする。どちらもなければ410 Goneを返す。
+type fakeEvents struct {
+	mu        sync.Mutex
+	responses map[string]string // key: syncToken value: レスポンスのJSON
+	requests  []string          // 受け取ったキー
+}
+
+func (f *fakeEvents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("syncToken")
+	if pageToken := r.URL.Query().Get("pageToken"); pageToken != "" {
+		key = "page=" + pageToken
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, key)
+	body, ok := f.responses[key]
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusGone)
+		body = `{"error":{"code":410,"message":"Sync token is no longer valid, a full sync is required.","errors":[{"domain":"global","reason":"fullSyncRequired"}]}}`
+	}
+	w.Write([]byte(body))
+}
+
+func (f *fakeEvents) Requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+// newFakeService fのhttptest.Serverに接続するcalendar.Serviceを返す。
+func newFakeService(t *testing.T, f http.Handler) *calendar.Service {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	service, err := calendar.NewService(context.Background(), option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func TestWebhookHandler(t *testing.T) {
+	ctx := context.Background()
+	m := NewWatchManager(NewLocalNotifier(), "http://localhost/notifications")
+	ch, err := m.Watch(ctx, "a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		header     map[string]string
+		wantStatus int
+		wantChange bool
+	}{
+		{
+			name:       "sync",
+			header:     map[string]string{HeaderResourceState: "sync"},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "exists",
+			header:     map[string]string{HeaderResourceState: "exists"},
+			wantStatus: http.StatusNoContent,
+			wantChange: true,
+		},
+		{
+			name:       "not_exists",
+			header:     map[string]string{HeaderResourceState: "not_exists"},
+			wantStatus: http.StatusNoContent,
+			wantChange: true,
+		},
+		{
+			name:       "unknown state",
+			header:     map[string]string{HeaderResourceState: "changed"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown channel",
+			header:     map[string]string{HeaderChannelId: "unknown", HeaderResourceState: "exists"},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "missing channel id",
+			header:     map[string]string{HeaderChannelId: "", HeaderResourceState: "exists"},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "wrong token",
+			header:     map[string]string{HeaderChannelToken: "wrong", HeaderResourceState: "exists"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "missing token",
+			header:     map[string]string{HeaderChannelToken: "", HeaderResourceState: "exists"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "wrong resource id",
+			header:     map[string]string{HeaderResourceId: "other", HeaderResourceState: "exists"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "GET",
+			method:     http.MethodGet,
+			header:     map[string]string{HeaderResourceState: "exists"},
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := make(chan string, 1)
+			h := &WebhookHandler{Manager: m, OnChange: func(_ context.Context, calendarId string) { changed <- calendarId }}
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, "/notifications", nil)
+			req.Header.Set(HeaderChannelId, ch.Id)
+			req.Header.Set(HeaderChannelToken, ch.Token)
+			req.Header.Set(HeaderResourceId, ch.ResourceId)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantChange {
+				select {
+				case id := <-changed:
+					if id != "a@example.com" {
+						t.Errorf("OnChange(%q), want a@example.com", id)
+					}
+				case <-time.After(time.Second):
+					t.Error("OnChange was not called")
+				}
+				return
+			}
+			select {
+			case id := <-changed:
+				t.Errorf("unexpected OnChange(%q)", id)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+// TestLocalNotifierTriggersSync LocalNotifierの通知でWebhookHandlerが差分同期を呼ぶことを確認する。
+func TestLocalNotifierTriggersSync(t *testing.T) {
+	ctx := context.Background()
+	events := &fakeEvents{responses: map[string]string{
+		"":   `{"items":[{"id":"e1","status":"confirmed","start":{"dateTime":"2022-04-18T09:00:00+09:00"},"end":{"dateTime":"2022-04-18T10:00:00+09:00"}}],"nextSyncToken":"s1"}`,
+		"s1": `{"items":[{"id":"e2","status":"confirmed","start":{"dateTime":"2022-04-19T09:00:00+09:00"},"end":{"dateTime":"2022-04-19T10:00:00+09:00"}},{"id":"e1","status":"cancelled"}],"nextSyncToken":"s2"}`,
+	}}
+	store := NewMemoryEventStore()
+	syncer := NewSyncer(newFakeService(t, events), store)
+
+	results := make(chan *SyncResult, 10)
+	notifier := NewLocalNotifier()
+	manager := NewWatchManager(notifier, "")
+	srv := httptest.NewServer(&WebhookHandler{Manager: manager, OnChange: func(ctx context.Context, calendarId string) {
+		result, err := syncer.Sync(ctx, calendarId)
+		if err != nil {
+			t.Errorf("sync %s: %v", calendarId, err)
+			return
+		}
+		results <- result
+	}})
+	defer srv.Close()
+	manager.Address = srv.URL
+	notifier.Client = srv.Client()
+
+	if _, err := syncer.Sync(ctx, "a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Watch(ctx, "a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Watch直後のsyncでは同期しない。
+	if err := notifier.Notify(ctx, "a@example.com", "sync"); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(ctx, "a@example.com", "exists"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case result := <-results:
+		if result.Full || result.Updated != 1 || result.Deleted != 1 || result.SyncToken != "s2" {
+			t.Errorf("result = %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync was not triggered")
+	}
+	select {
+	case result := <-results:
+		t.Errorf("unexpected sync: %+v", result)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if got, want := strings.Join(events.Requests(), ","), ",s1"; got != want {
+		t.Errorf("syncTokens = %q, want %q", got, want)
+	}
+	mirrored, err := store.Events(ctx, "a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mirrored) != 1 || mirrored[0].Id != "e2" {
+		t.Errorf("events = %v", mirrored)
+	}
+
+	// Watchしていないカレンダーには通知できない。
+	if err := notifier.Notify(ctx, "b@example.com", "exists"); err == nil {
+		t.Error("Notify for unknown calendar returned no error")
+	}
+	// 停止したチャンネルにも通知しない。
+	if err := manager.Unwatch(ctx, "a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(ctx, "a@example.com", "exists"); err == nil {
+		t.Error("Notify after Unwatch returned no error")
+	}
+}
+
+// TestLocalNotifierHeaders 通知のヘッダーがWatchで作ったチャンネルと一致することを確認する。
+func TestLocalNotifierHeaders(t *testing.T) {
+	ctx := context.Background()
+	headers := make(chan http.Header, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	notifier := NewLocalNotifier()
+	notifier.Client = srv.Client()
+	manager := NewWatchManager(notifier, srv.URL)
+	ch, err := manager.Watch(ctx, "a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range []string{"sync", "exists"} {
+		if err := notifier.Notify(ctx, "a@example.com", state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, state := range []string{"sync", "exists"} {
+		h := <-headers
+		want := map[string]string{
+			HeaderChannelId:     ch.Id,
+			HeaderChannelToken:  ch.Token,
+			HeaderResourceId:    ch.ResourceId,
+			HeaderResourceState: state,
+			HeaderMessageNumber: []string{"1", "2"}[i],
+		}
+		for k, v := range want {
+			if got := h.Get(k); got != v {
+				t.Errorf("%s = %q, want %q", k, got, v)
+			}
+		}
+	}
+
+	// 通知先がエラーを返した場合はエラーにする。
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+	if err := notifier.Notify(ctx, "a@example.com", "exists"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("err = %v", err)
+	}
+}
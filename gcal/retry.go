@@ -0,0 +1,157 @@
+package gcal
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"google.golang.org/api/googleapi"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryPolicy Calendar APIの一時的なエラーに対する再試行の設定
+type RetryPolicy struct {
+	MaxRetries int           // 再試行の最大回数（初回の呼び出しは含まない）
+	BaseDelay  time.Duration // 1回目の再試行までの待ち時間の上限
+	MaxDelay   time.Duration // 待ち時間の上限
+
+	sleep func(ctx context.Context, d time.Duration) error // テストで待たずに済ませるためのもの。nilはtimerで待つ
+}
+
+// DefaultRetryPolicy 500ms, 1s, 2s, 4s ... 最大32sまでの指数バックオフで5回まで再試行する。
+var DefaultRetryPolicy = RetryPolicy{MaxRetries: 5, BaseDelay: 500 * time.Millisecond, MaxDelay: 32 * time.Second}
+
+// RetryMetrics 再試行の回数をexpvarで公開する。
+//
+// key:
+// "<op>.calls"          呼び出し回数
+// "<op>.retries"        再試行した回数
+// "<op>.retries.<理由>"  理由ごとの再試行回数 ex: "events.list.retries.rateLimitExceeded"
+// "<op>.exhausted"      再試行の上限に達して失敗した回数
+var RetryMetrics = expvar.NewMap("gcal_retry")
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Retry DefaultRetryPolicyでfnを実行する。
+// opはメトリクスのキーに使う呼び出しの名前 ex: "events.list"
+func Retry(ctx context.Context, op string, fn func() error) error {
+	return DefaultRetryPolicy.Do(ctx, op, fn)
+}
+
+// Do fnを実行し、再試行可能なエラーであればjitter付きの指数バックオフで再試行する。
+// Retry-Afterヘッダーがある場合は、その時間以上待ってから再試行する。
+func (p RetryPolicy) Do(ctx context.Context, op string, fn func() error) error {
+	RetryMetrics.Add(op+".calls", 1)
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		reason, ok := retryableReason(err)
+		if !ok {
+			return err
+		}
+		if attempt >= p.MaxRetries {
+			RetryMetrics.Add(op+".exhausted", 1)
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		if after, ok := retryAfter(err); ok && after > delay {
+			delay = after
+		}
+		// 待っている間にキャンセルされた場合は、最後のAPIのエラーではなくキャンセルを返す。
+		if err := p.wait(ctx, delay); err != nil {
+			return err
+		}
+		RetryMetrics.Add(op+".retries", 1)
+		RetryMetrics.Add(op+".retries."+reason, 1)
+	}
+}
+
+// wait dだけ待つ。ctxがキャンセルされた場合はctx.Err()を返す。
+func (p RetryPolicy) wait(ctx context.Context, d time.Duration) error {
+	if p.sleep != nil {
+		return p.sleep(ctx, d)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// backoff attempt回目（0始まり）の待ち時間を返す。
+// BaseDelay * 2^attempt（MaxDelayが上限）の範囲でランダムに待つ（full jitter）。
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceil := p.BaseDelay
+	for i := 0; i < attempt && ceil < p.MaxDelay; i++ {
+		ceil *= 2
+	}
+	if ceil > p.MaxDelay {
+		ceil = p.MaxDelay
+	}
+	if ceil <= 0 {
+		return 0
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(ceil)))
+}
+
+// retryableReason 再試行するべきエラーかを判定し、メトリクス用の理由を返す。
+//
+// 再試行するもの:
+// 403 rateLimitExceeded / userRateLimitExceeded
+// 429 Too Many Requests
+// 5xx
+// 403の quotaExceeded(1日の上限) や forbidden などは再試行しても成功しないのでそのまま返す。
+// see: https://developers.google.com/calendar/api/guides/errors
+func retryableReason(err error) (string, bool) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return "", false
+	}
+	switch {
+	case apiErr.Code == http.StatusForbidden:
+		for _, item := range apiErr.Errors {
+			if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+				return item.Reason, true
+			}
+		}
+		return "", false
+	case apiErr.Code == http.StatusTooManyRequests:
+		return strconv.Itoa(apiErr.Code), true
+	case apiErr.Code >= 500:
+		return strconv.Itoa(apiErr.Code), true
+	}
+	return "", false
+}
+
+// retryAfter Retry-Afterヘッダー（秒数 or HTTP日付）の待ち時間を返す。
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Header == nil {
+		return 0, false
+	}
+	v := apiErr.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
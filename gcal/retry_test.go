@@ -0,0 +1,224 @@
+package gcal
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/api/googleapi"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func apiError(code int, reason string) *googleapi.Error {
+	err := &googleapi.Error{Code: code}
+	if reason != "" {
+		err.Errors = []googleapi.ErrorItem{{Reason: reason}}
+	}
+	return err
+}
+
+func TestRetryableReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		reason string
+		ok     bool
+	}{
+		{name: "rate limit", err: apiError(http.StatusForbidden, "rateLimitExceeded"), reason: "rateLimitExceeded", ok: true},
+		{name: "user rate limit", err: apiError(http.StatusForbidden, "userRateLimitExceeded"), reason: "userRateLimitExceeded", ok: true},
+		{name: "daily quota", err: apiError(http.StatusForbidden, "quotaExceeded")},
+		{name: "forbidden", err: apiError(http.StatusForbidden, "forbidden")},
+		{name: "too many requests", err: apiError(http.StatusTooManyRequests, ""), reason: "429", ok: true},
+		{name: "server error", err: apiError(http.StatusInternalServerError, "backendError"), reason: "500", ok: true},
+		{name: "unavailable", err: apiError(http.StatusServiceUnavailable, ""), reason: "503", ok: true},
+		{name: "not found", err: apiError(http.StatusNotFound, "notFound")},
+		{name: "gone", err: apiError(http.StatusGone, "")},
+		{name: "wrapped", err: &CalendarError{CalendarId: "a", Err: apiError(http.StatusBadGateway, "")}, reason: "502", ok: true},
+		{name: "not an API error", err: errors.New("connection reset")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, ok := retryableReason(tt.err)
+			if reason != tt.reason || ok != tt.ok {
+				t.Errorf("retryableReason() = %q, %v, want %q, %v", reason, ok, tt.reason, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	withHeader := func(v string) error {
+		err := apiError(http.StatusTooManyRequests, "")
+		err.Header = http.Header{"Retry-After": []string{v}}
+		return err
+	}
+	tests := []struct {
+		name     string
+		err      error
+		min, max time.Duration
+		ok       bool
+	}{
+		{name: "no header", err: apiError(http.StatusTooManyRequests, "")},
+		{name: "seconds", err: withHeader("3"), min: 3 * time.Second, max: 3 * time.Second, ok: true},
+		{name: "zero", err: withHeader("0"), ok: true},
+		{name: "negative", err: withHeader("-1")},
+		{name: "invalid", err: withHeader("soon")},
+		{
+			name: "http date",
+			err:  withHeader(time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)),
+			// HTTP日付は秒単位のため切り捨て分を許容する。
+			min: 8 * time.Second, max: 10 * time.Second, ok: true,
+		},
+		{name: "not an API error", err: errors.New("connection reset")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := retryAfter(tt.err)
+			if ok != tt.ok || got < tt.min || got > tt.max {
+				t.Errorf("retryAfter() = %v, %v, want %v〜%v, %v", got, ok, tt.min, tt.max, tt.ok)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	saved := jitterRand
+	defer func() { jitterRand = saved }()
+	jitterRand = rand.New(rand.NewSource(1))
+	p := RetryPolicy{BaseDelay: 500 * time.Millisecond, MaxDelay: 4 * time.Second}
+	// attemptごとの待ち時間の上限（BaseDelay * 2^attempt、MaxDelayまで）
+	ceils := []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, 4 * time.Second}
+	for attempt, ceil := range ceils {
+		var max time.Duration
+		for i := 0; i < 1000; i++ {
+			d := p.backoff(attempt)
+			if d < 0 || d >= ceil {
+				t.Fatalf("backoff(%d) = %v, want [0, %v)", attempt, d, ceil)
+			}
+			if d > max {
+				max = d
+			}
+		}
+		// full jitterのため上限近くまで散らばる。
+		if max < ceil*9/10 {
+			t.Errorf("backoff(%d) max = %v, want close to %v", attempt, max, ceil)
+		}
+	}
+	if d := (RetryPolicy{}).backoff(3); d != 0 {
+		t.Errorf("backoff without BaseDelay = %v, want 0", d)
+	}
+}
+
+// sleepRange 再試行の前に待った時間の範囲 [min, max]
+type sleepRange struct {
+	min, max time.Duration
+}
+
+// upTo jitter付きのバックオフ [0, ceil)
+func upTo(ceil time.Duration) sleepRange {
+	return sleepRange{max: ceil - 1}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	retryable := apiError(http.StatusServiceUnavailable, "")
+	permanent := apiError(http.StatusNotFound, "notFound")
+	rateLimited := apiError(http.StatusTooManyRequests, "")
+	rateLimited.Header = http.Header{"Retry-After": []string{"60"}}
+	tests := []struct {
+		name       string
+		errs       []error // 呼び出しごとのfnの結果。足りない分はnil
+		wantErr    error
+		wantCalls  int
+		wantSleeps []sleepRange
+	}{
+		{name: "success", wantCalls: 1, wantSleeps: []sleepRange{}},
+		{name: "permanent error", errs: []error{permanent}, wantErr: permanent, wantCalls: 1, wantSleeps: []sleepRange{}},
+		{
+			name:       "retry then success",
+			errs:       []error{retryable, retryable},
+			wantCalls:  3,
+			wantSleeps: []sleepRange{upTo(time.Second), upTo(2 * time.Second)},
+		},
+		{
+			name:       "exhausted",
+			errs:       []error{retryable, retryable, retryable, retryable},
+			wantErr:    retryable,
+			wantCalls:  4,
+			wantSleeps: []sleepRange{upTo(time.Second), upTo(2 * time.Second), upTo(4 * time.Second)},
+		},
+		{
+			name:       "permanent error after retry",
+			errs:       []error{retryable, permanent},
+			wantErr:    permanent,
+			wantCalls:  2,
+			wantSleeps: []sleepRange{upTo(time.Second)},
+		},
+		{
+			// Retry-Afterがバックオフより長い場合はRetry-Afterだけ待つ。
+			name:       "retry after",
+			errs:       []error{rateLimited},
+			wantCalls:  2,
+			wantSleeps: []sleepRange{{min: 60 * time.Second, max: 60 * time.Second}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sleeps := make([]time.Duration, 0)
+			p := RetryPolicy{MaxRetries: 3, BaseDelay: time.Second, MaxDelay: 4 * time.Second}
+			p.sleep = func(_ context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+			calls := 0
+			err := p.Do(context.Background(), "test", func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if err != tt.wantErr {
+				t.Errorf("Do() = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if len(sleeps) != len(tt.wantSleeps) {
+				t.Fatalf("sleeps = %v, want %d sleeps", sleeps, len(tt.wantSleeps))
+			}
+			for i, want := range tt.wantSleeps {
+				if sleeps[i] < want.min || sleeps[i] > want.max {
+					t.Errorf("sleeps[%d] = %v, want %v", i, sleeps[i], want)
+				}
+			}
+		})
+	}
+}
+
+// TestRetryPolicyDoCanceled バックオフ中にキャンセルされたらAPIのエラーではなくctx.Err()を返す。
+func TestRetryPolicyDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := RetryPolicy{MaxRetries: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	calls := 0
+	done := make(chan error)
+	go func() {
+		done <- p.Do(ctx, "test", func() error {
+			calls++
+			return apiError(http.StatusServiceUnavailable, "")
+		})
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Do() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Do() did not return after cancel")
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
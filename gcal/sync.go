@@ -0,0 +1,111 @@
+package gcal
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+	"net/http"
+	"sync"
+)
+
+// SyncResult カレンダー1件分の同期結果
+type SyncResult struct {
+	CalendarId string
+	Full       bool // 全件同期したか（初回・syncTokenの期限切れ）
+	Updated    int  // 追加・更新された予定の数
+	Deleted    int  // 削除（status: cancelled）された予定の数
+	SyncToken  string
+}
+
+// Syncer Events.ListのsyncTokenで差分だけを取得し、EventStoreに反映する。
+//
+// 初回は全件を取得してnextSyncTokenを保存し、以降はその後の変更（削除を含む）のみを取得する。
+// syncTokenが無効になった場合（410 Gone）は、保持している予定を捨てて全件を取得し直す。
+// see: https://developers.google.com/calendar/api/guides/sync
+type Syncer struct {
+	Service *calendar.Service
+	Store   EventStore
+	// SingleEvents 繰り返しの予定を1回ずつに展開して同期する（全件・差分で同じ指定にする必要がある）
+	SingleEvents bool
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex // 同じカレンダーを同時に同期しないためのロック
+}
+
+// NewSyncer 繰り返しの予定を展開して同期するSyncerを作成する。
+func NewSyncer(service *calendar.Service, store EventStore) *Syncer {
+	return &Syncer{Service: service, Store: store, SingleEvents: true}
+}
+
+func (s *Syncer) lock(calendarId string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locks == nil {
+		s.locks = make(map[string]*sync.Mutex)
+	}
+	if _, ok := s.locks[calendarId]; !ok {
+		s.locks[calendarId] = &sync.Mutex{}
+	}
+	return s.locks[calendarId]
+}
+
+// Sync calendarIdを同期する。保存済みのsyncTokenがあれば差分、なければ全件を取得する。
+func (s *Syncer) Sync(ctx context.Context, calendarId string) (*SyncResult, error) {
+	l := s.lock(calendarId)
+	l.Lock()
+	defer l.Unlock()
+
+	token, err := s.Store.SyncToken(ctx, calendarId)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.sync(ctx, calendarId, token)
+	if token != "" && IsSyncTokenExpired(err) {
+		// syncTokenの期限切れ・無効化。ミラーを捨てて全件を取得し直す。
+		result, err = s.sync(ctx, calendarId, "")
+	}
+	return result, err
+}
+
+// sync tokenが空なら全件、そうでなければ差分を取得して反映する。
+// すべてのページを取得してからまとめて反映し、途中で失敗した場合はミラーを変更しない。
+func (s *Syncer) sync(ctx context.Context, calendarId, token string) (*SyncResult, error) {
+	result := &SyncResult{CalendarId: calendarId, Full: token == ""}
+	var changes []*calendar.Event
+	err := Retry(ctx, "events.list", func() error {
+		// 途中のページで失敗した場合は最初から取得し直す。
+		changes = make([]*calendar.Event, 0)
+		call := s.Service.Events.List(calendarId).MaxResults(defaultMaxResults).SingleEvents(s.SingleEvents)
+		if token != "" {
+			call = call.SyncToken(token)
+		}
+		return call.Pages(ctx, func(events *calendar.Events) error {
+			changes = append(changes, events.Items...)
+			if events.NextSyncToken != "" {
+				result.SyncToken = events.NextSyncToken
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range changes {
+		if e.Status == "cancelled" {
+			result.Deleted++
+		} else {
+			result.Updated++
+		}
+	}
+	if err := s.Store.Apply(ctx, calendarId, changes, result.SyncToken, result.Full); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// IsSyncTokenExpired syncTokenが無効になった（410 Gone）エラーかを判定する。
+func IsSyncTokenExpired(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusGone
+}
@@ -0,0 +1,152 @@
+package gcal
+
+import (
+	"context"
+	"google.golang.org/api/calendar/v3"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func eventIds(events []*calendar.Event) []string {
+	ids := make([]string, len(events))
+	for i, e := range events {
+		ids[i] = e.Id
+	}
+	return ids
+}
+
+func TestMirrorSorted(t *testing.T) {
+	m := &mirror{Events: map[string]*calendar.Event{
+		// 01:00Z
+		"tokyo": {Id: "tokyo", Start: &calendar.EventDateTime{DateTime: "2022-04-18T10:00:00+09:00"}},
+		// 00:00Z（文字列では tokyo より後）
+		"newyork": {Id: "newyork", Start: &calendar.EventDateTime{DateTime: "2022-04-17T20:00:00-04:00"}},
+		// 00:30Z
+		"utc": {Id: "utc", Start: &calendar.EventDateTime{DateTime: "2022-04-18T00:30:00Z"}},
+		// 2022-04-17T15:00Z
+		"allday-tokyo": {Id: "allday-tokyo", Start: &calendar.EventDateTime{Date: "2022-04-18", TimeZone: "Asia/Tokyo"}},
+		// 2022-04-18T04:00Z
+		"allday-newyork": {Id: "allday-newyork", Start: &calendar.EventDateTime{Date: "2022-04-18", TimeZone: "America/New_York"}},
+		// 同じ時刻はIDの順
+		"same-b": {Id: "same-b", Start: &calendar.EventDateTime{DateTime: "2022-04-18T14:00:00+09:00"}},
+		"same-a": {Id: "same-a", Start: &calendar.EventDateTime{DateTime: "2022-04-18T05:00:00Z"}},
+		// 開始日時がない予定は先頭
+		"nostart": {Id: "nostart"},
+	}}
+	want := []string{"nostart", "allday-tokyo", "newyork", "utc", "tokyo", "allday-newyork", "same-a", "same-b"}
+	if got := eventIds(m.sorted()); !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted = %v, want %v", got, want)
+	}
+}
+
+// TestSyncerSync 全件 → 差分 → syncTokenの期限切れ（410 Gone）で全件を取得し直す流れを確認する。
+func TestSyncerSync(t *testing.T) {
+	const (
+		full = `{"items":[` +
+			`{"id":"e2","status":"confirmed","start":{"dateTime":"2022-04-18T10:00:00+09:00"}},` +
+			`{"id":"e1","status":"confirmed","start":{"dateTime":"2022-04-17T20:00:00-04:00"}}` +
+			`],"nextPageToken":"p2"}`
+		fullPage2 = `{"items":[{"id":"e3","status":"confirmed","start":{"date":"2022-04-19"}}],"nextSyncToken":"s1"}`
+		changes   = `{"items":[` +
+			`{"id":"e1","status":"cancelled"},` +
+			`{"id":"e4","status":"confirmed","start":{"dateTime":"2022-04-20T09:00:00+09:00"}}` +
+			`],"nextSyncToken":"s2"}`
+	)
+
+	stores := map[string]func(t *testing.T) EventStore{
+		"memory": func(t *testing.T) EventStore { return NewMemoryEventStore() },
+		"file":   func(t *testing.T) EventStore { return &FileEventStore{Dir: t.TempDir()} },
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			// s2 は期限切れとして410を返す。
+			events := &fakeEvents{responses: map[string]string{"": full, "page=p2": fullPage2, "s1": changes}}
+			store := newStore(t)
+			syncer := NewSyncer(newFakeService(t, events), store)
+
+			steps := []struct {
+				want    SyncResult
+				wantIds []string
+			}{
+				{SyncResult{CalendarId: "a@example.com", Full: true, Updated: 3, SyncToken: "s1"}, []string{"e1", "e2", "e3"}},
+				{SyncResult{CalendarId: "a@example.com", Updated: 1, Deleted: 1, SyncToken: "s2"}, []string{"e2", "e3", "e4"}},
+				// 410で全件を取得し直すと、差分で追加したe4は消え、削除したe1は戻る。
+				{SyncResult{CalendarId: "a@example.com", Full: true, Updated: 3, SyncToken: "s1"}, []string{"e1", "e2", "e3"}},
+			}
+			for i, step := range steps {
+				result, err := syncer.Sync(ctx, "a@example.com")
+				if err != nil {
+					t.Fatalf("sync %d: %v", i+1, err)
+				}
+				if *result != step.want {
+					t.Errorf("sync %d: result = %+v, want %+v", i+1, *result, step.want)
+				}
+				mirrored, err := store.Events(ctx, "a@example.com")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := eventIds(mirrored); !reflect.DeepEqual(got, step.wantIds) {
+					t.Errorf("sync %d: events = %v, want %v", i+1, got, step.wantIds)
+				}
+				token, err := store.SyncToken(ctx, "a@example.com")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if token != step.want.SyncToken {
+					t.Errorf("sync %d: stored syncToken = %q, want %q", i+1, token, step.want.SyncToken)
+				}
+			}
+
+			if got, want := strings.Join(events.Requests(), ","), ",page=p2,s1,s2,,page=p2"; got != want {
+				t.Errorf("requests = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestSyncerSyncGoneOnLaterPage 差分の途中のページで410になった場合は、取得済みのページを捨てて全件を取得し直す。
+// 全件の取得にも失敗した場合はミラーとsyncTokenを変更しない。
+func TestSyncerSyncGoneOnLaterPage(t *testing.T) {
+	ctx := context.Background()
+	events := &fakeEvents{responses: map[string]string{
+		"":   `{"items":[{"id":"e1","status":"confirmed","start":{"date":"2022-04-18"}}],"nextSyncToken":"s1"}`,
+		"s1": `{"items":[{"id":"e2","status":"confirmed"}],"nextPageToken":"broken"}`,
+	}}
+	store := NewMemoryEventStore()
+	syncer := NewSyncer(newFakeService(t, events), store)
+	if _, err := syncer.Sync(ctx, "a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2ページ目が410になるため全件を取得し直すが、初回と同じ内容になる。
+	// 差分の1ページ目（e2）は反映しない。
+	result, err := syncer.Sync(ctx, "a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Full || result.SyncToken != "s1" {
+		t.Errorf("result = %+v", result)
+	}
+	mirrored, err := store.Events(ctx, "a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := eventIds(mirrored); !reflect.DeepEqual(got, []string{"e1"}) {
+		t.Errorf("events = %v", got)
+	}
+
+	events.mu.Lock()
+	delete(events.responses, "")
+	events.mu.Unlock()
+	if _, err := syncer.Sync(ctx, "a@example.com"); !IsSyncTokenExpired(err) {
+		t.Fatalf("err = %v, want 410", err)
+	}
+	if token, err := store.SyncToken(ctx, "a@example.com"); err != nil || token != "s1" {
+		t.Errorf("syncToken after failure = %q, %v", token, err)
+	}
+	if mirrored, err := store.Events(ctx, "a@example.com"); err != nil || len(mirrored) != 1 {
+		t.Errorf("events after failure = %v, %v", eventIds(mirrored), err)
+	}
+}
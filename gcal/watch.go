@@ -0,0 +1,314 @@
+package gcal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"google.golang.org/api/calendar/v3"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultChannelTTL 通知チャンネルの有効期間（APIの既定・上限は1週間）
+	DefaultChannelTTL = 7 * 24 * time.Hour
+	// DefaultRenewBefore 期限のこの時間前にチャンネルを作り直す。
+	DefaultRenewBefore = time.Hour
+)
+
+// プッシュ通知のヘッダー see: https://developers.google.com/calendar/api/guides/push
+const (
+	HeaderChannelId     = "X-Goog-Channel-ID"
+	HeaderChannelToken  = "X-Goog-Channel-Token"
+	HeaderResourceId    = "X-Goog-Resource-ID"
+	HeaderResourceState = "X-Goog-Resource-State" // sync / exists / not_exists
+	HeaderMessageNumber = "X-Goog-Message-Number"
+)
+
+// ChannelAPI 通知チャンネルの作成・停止
+// 本番はServiceChannelAPI、ローカルではLocalNotifierを使う。
+type ChannelAPI interface {
+	Watch(ctx context.Context, calendarId string, channel *calendar.Channel) (*calendar.Channel, error)
+	Stop(ctx context.Context, channel *calendar.Channel) error
+}
+
+// ServiceChannelAPI Events.Watch / Channels.Stop を呼ぶChannelAPI
+type ServiceChannelAPI struct {
+	Service *calendar.Service
+}
+
+func (a *ServiceChannelAPI) Watch(ctx context.Context, calendarId string, channel *calendar.Channel) (*calendar.Channel, error) {
+	var resp *calendar.Channel
+	err := Retry(ctx, "events.watch", func() error {
+		var err error
+		resp, err = a.Service.Events.Watch(calendarId, channel).Context(ctx).Do()
+		return err
+	})
+	return resp, err
+}
+
+func (a *ServiceChannelAPI) Stop(ctx context.Context, channel *calendar.Channel) error {
+	return Retry(ctx, "channels.stop", func() error {
+		return a.Service.Channels.Stop(channel).Context(ctx).Do()
+	})
+}
+
+// Channel カレンダー1件の予定の変更を通知するチャンネル
+type Channel struct {
+	Id         string
+	ResourceId string
+	CalendarId string
+	Token      string // 通知のX-Goog-Channel-Tokenと照合する。
+	Expiration time.Time
+}
+
+// WatchManager カレンダーごとの通知チャンネルを作成し、期限の前に作り直す。
+//
+// 作り直す際は新しいチャンネルを作ってから古いチャンネルを停止し、通知が途切れないようにする。
+// チャンネルはプロセス内で保持するため、再起動した場合はWatchし直す。
+type WatchManager struct {
+	API         ChannelAPI
+	Address     string        // 通知を受けるHTTPSのURL
+	TTL         time.Duration // 0以下はDefaultChannelTTL
+	RenewBefore time.Duration // 0以下はDefaultRenewBefore
+
+	mu         sync.Mutex
+	channels   map[string]*Channel // key: チャンネルID
+	byCalendar map[string]*Channel // key: カレンダーID
+	now        func() time.Time
+}
+
+// NewWatchManager addressに通知するWatchManagerを作成する。
+func NewWatchManager(api ChannelAPI, address string) *WatchManager {
+	return &WatchManager{
+		API:         api,
+		Address:     address,
+		TTL:         DefaultChannelTTL,
+		RenewBefore: DefaultRenewBefore,
+		channels:    make(map[string]*Channel),
+		byCalendar:  make(map[string]*Channel),
+		now:         time.Now,
+	}
+}
+
+// Watch calendarIdの通知チャンネルを作成する。既にあれば作り直す。
+func (m *WatchManager) Watch(ctx context.Context, calendarId string) (*Channel, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	token, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	ttl := m.TTL
+	if ttl <= 0 {
+		ttl = DefaultChannelTTL
+	}
+	resp, err := m.API.Watch(ctx, calendarId, &calendar.Channel{
+		Id:      id,
+		Type:    "web_hook",
+		Address: m.Address,
+		Token:   token,
+		Params:  map[string]string{"ttl": strconv.Itoa(int(ttl.Seconds()))},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("watch %s: %w", calendarId, err)
+	}
+	ch := &Channel{Id: id, ResourceId: resp.ResourceId, CalendarId: calendarId, Token: token}
+	if resp.Expiration > 0 {
+		// Expirationはミリ秒のUnix時間
+		ch.Expiration = time.Unix(0, resp.Expiration*int64(time.Millisecond))
+	} else {
+		ch.Expiration = m.now().Add(ttl)
+	}
+
+	m.mu.Lock()
+	old := m.byCalendar[calendarId]
+	m.channels[ch.Id] = ch
+	m.byCalendar[calendarId] = ch
+	if old != nil {
+		delete(m.channels, old.Id)
+	}
+	m.mu.Unlock()
+
+	if old != nil {
+		if err := m.stop(ctx, old); err != nil {
+			// 停止できなくても期限で止まるため、新しいチャンネルは使い続ける。
+			log.Printf("stop channel %s: %v", old.Id, err)
+		}
+	}
+	return ch, nil
+}
+
+// Unwatch calendarIdの通知チャンネルを停止する。
+func (m *WatchManager) Unwatch(ctx context.Context, calendarId string) error {
+	m.mu.Lock()
+	ch, ok := m.byCalendar[calendarId]
+	if ok {
+		delete(m.byCalendar, calendarId)
+		delete(m.channels, ch.Id)
+	}
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return m.stop(ctx, ch)
+}
+
+func (m *WatchManager) stop(ctx context.Context, ch *Channel) error {
+	return m.API.Stop(ctx, &calendar.Channel{Id: ch.Id, ResourceId: ch.ResourceId})
+}
+
+// Renew 期限までRenewBefore以内のチャンネルを作り直し、作り直したカレンダーIDを返す。
+// 失敗したカレンダーがあれば、作り直せた分のカレンダーIDとRenewErrorを返す。
+func (m *WatchManager) Renew(ctx context.Context) ([]string, error) {
+	renewBefore := m.RenewBefore
+	if renewBefore <= 0 {
+		renewBefore = DefaultRenewBefore
+	}
+	deadline := m.now().Add(renewBefore)
+	m.mu.Lock()
+	expiring := make([]string, 0)
+	for calendarId, ch := range m.byCalendar {
+		if ch.Expiration.Before(deadline) {
+			expiring = append(expiring, calendarId)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Strings(expiring)
+
+	// 1件のカレンダーで作り直しに失敗しても残りのカレンダーは続ける。
+	renewed := make([]string, 0, len(expiring))
+	var errs []*CalendarError
+	for _, calendarId := range expiring {
+		if _, err := m.Watch(ctx, calendarId); err != nil {
+			errs = append(errs, &CalendarError{CalendarId: calendarId, Err: err})
+			continue
+		}
+		renewed = append(renewed, calendarId)
+	}
+	if len(errs) > 0 {
+		return renewed, &RenewError{Errors: errs}
+	}
+	return renewed, nil
+}
+
+// RenewError Renewで作り直せなかったカレンダーごとのエラー
+type RenewError struct {
+	Errors []*CalendarError
+}
+
+func (e *RenewError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("failed to renew %d channels: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+// Run ctxが終了するまでinterval（0以下はRenewBeforeの半分）ごとにRenewする。
+func (m *WatchManager) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = m.RenewBefore / 2
+		if interval <= 0 {
+			interval = DefaultRenewBefore / 2
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			renewed, err := m.Renew(ctx)
+			if err != nil {
+				log.Printf("renew channels: %v", err)
+			}
+			for _, calendarId := range renewed {
+				log.Printf("renewed channel for %s", calendarId)
+			}
+		}
+	}
+}
+
+// Lookup チャンネルIDからチャンネルを返す。
+func (m *WatchManager) Lookup(channelId string) (*Channel, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch, ok := m.channels[channelId]
+	return ch, ok
+}
+
+// Channels 保持しているチャンネルをカレンダーIDごとに返す。
+func (m *WatchManager) Channels() map[string]*Channel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	channels := make(map[string]*Channel, len(m.byCalendar))
+	for calendarId, ch := range m.byCalendar {
+		copied := *ch
+		channels[calendarId] = &copied
+	}
+	return channels
+}
+
+// WebhookHandler プッシュ通知を受け、変更があったカレンダーのOnChangeを呼ぶHTTPハンドラー
+//
+// チャンネルID・トークン・リソースIDが保持しているチャンネルと一致しない通知は拒否する。
+// X-Goog-Resource-State: sync はチャンネル作成時の確認のため何もしない。
+// 通知にはすぐ応答する必要があるため、OnChange（差分同期）は別のgoroutineで呼ぶ。
+type WebhookHandler struct {
+	Manager  *WatchManager
+	OnChange func(ctx context.Context, calendarId string)
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	ch, ok := h.Manager.Lookup(r.Header.Get(HeaderChannelId))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	token := r.Header.Get(HeaderChannelToken)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(ch.Token)) != 1 {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if resourceId := r.Header.Get(HeaderResourceId); resourceId != "" && ch.ResourceId != "" && resourceId != ch.ResourceId {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	switch state := r.Header.Get(HeaderResourceState); state {
+	case "sync":
+	case "exists", "not_exists":
+		if h.OnChange != nil {
+			go h.OnChange(context.Background(), ch.CalendarId)
+		}
+	default:
+		http.Error(w, "unknown resource state: "+state, http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
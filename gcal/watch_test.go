@@ -0,0 +1,87 @@
+package gcal
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/api/calendar/v3"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingAPI failのカレンダーだけWatchに失敗するChannelAPI
+type failingAPI struct {
+	*LocalNotifier
+	fail map[string]bool
+}
+
+func (a *failingAPI) Watch(ctx context.Context, calendarId string, channel *calendar.Channel) (*calendar.Channel, error) {
+	if a.fail[calendarId] {
+		return nil, errors.New("backend error")
+	}
+	return a.LocalNotifier.Watch(ctx, calendarId, channel)
+}
+
+func TestWatchManagerRenewContinuesOnError(t *testing.T) {
+	ctx := context.Background()
+	api := &failingAPI{LocalNotifier: NewLocalNotifier(), fail: make(map[string]bool)}
+	m := NewWatchManager(api, "http://localhost/notifications")
+	calendarIds := []string{"a@example.com", "b@example.com", "c@example.com"}
+	old := make(map[string]*Channel)
+	for _, id := range calendarIds {
+		ch, err := m.Watch(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old[id] = ch
+	}
+
+	// 期限の直前まで進め、bだけ作り直しに失敗させる。
+	now := time.Now().Add(DefaultChannelTTL - time.Minute)
+	m.now = func() time.Time { return now }
+	api.now = m.now
+	api.fail["b@example.com"] = true
+
+	renewed, err := m.Renew(ctx)
+	if want := []string{"a@example.com", "c@example.com"}; !reflect.DeepEqual(renewed, want) {
+		t.Errorf("renewed = %v, want %v", renewed, want)
+	}
+	var renewErr *RenewError
+	if !errors.As(err, &renewErr) {
+		t.Fatalf("err = %v, want *RenewError", err)
+	}
+	if len(renewErr.Errors) != 1 || renewErr.Errors[0].CalendarId != "b@example.com" {
+		t.Errorf("errors = %v", renewErr.Errors)
+	}
+	if !strings.Contains(err.Error(), "b@example.com") || !strings.Contains(err.Error(), "backend error") {
+		t.Errorf("err = %q", err)
+	}
+
+	// 作り直したチャンネルは新しいIDになり、古いチャンネルは引けなくなる。失敗したbは古いチャンネルのまま。
+	for _, id := range calendarIds {
+		_, oldFound := m.Lookup(old[id].Id)
+		if id == "b@example.com" {
+			if !oldFound {
+				t.Errorf("%s: old channel removed after failed renew", id)
+			}
+			continue
+		}
+		if oldFound {
+			t.Errorf("%s: old channel still registered", id)
+		}
+	}
+	if got := len(m.Channels()); got != len(calendarIds) {
+		t.Errorf("channels = %d, want %d", got, len(calendarIds))
+	}
+
+	// 失敗が解消すれば次のRenewで作り直す。
+	api.fail["b@example.com"] = false
+	renewed, err = m.Renew(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b@example.com"}; !reflect.DeepEqual(renewed, want) {
+		t.Errorf("renewed = %v, want %v", renewed, want)
+	}
+}
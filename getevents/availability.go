@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"google-calendar-sample/cache"
+	"google-calendar-sample/gcal"
+	"google-calendar-sample/ical"
+	"google-calendar-sample/schedule"
+	"google.golang.org/api/calendar/v3"
+	"log"
+	"time"
+)
+
+// options 予定の取得・空き時間の計算条件
+// CLIではフラグから、HTTPではリクエストごとに作成する。
+type options struct {
+	MeetingMinutes int
+	StepMinutes    int
+	Workers        int
+	Timeout        time.Duration
+	Feeds          []string         // .icsファイル・URL ex: staff@example.com=https://example.com/staff.ics
+	Locale         Locale           // 曜日・日付・時刻の表示形式
+	Cache          *cache.BusyCache // nilはキャッシュしない
+}
+
+// availability 期間内の予定をカレンダーごとに集約したもの
+type availability struct {
+	From         time.Time
+	To           time.Time
+	CalendarIds  []string // 取得できたカレンダーID
+	HolidayDates []string // ex: ["2022-04-29", "2022-05-02"]
+	Events       []*schedule.Event
+	Bookings     []*schedule.Event // 予約上限の判定に使う予約経由の予定
+	Bits         *schedule.DenseBits
+	Busy         map[string]schedule.Intervals
+}
+
+// fetchAvailability 明日からDaysRange日分の祝日・予定を取得し、カレンダー × 日付のbitsに集約する。
+//
+// 取得に失敗したカレンダーはログに残し、取得できたカレンダーのみで集約する。
+// 予約の週の上限のため、期間の前後で同じ週の予約もBookingsに入れる。
+func fetchAvailability(ctx context.Context, calendarService *calendar.Service, now time.Time, opts options) (*availability, error) {
+	// 今日 + 翌日のスケジュール(+1d) + 期間(+DaysRange d) + 翌日(+1d)からnano秒マイナスして0時直前を取得(-1 nano)
+	datetimeMin := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.Local)
+	datetimeMax := time.Date(now.Year(), now.Month(), now.Day()+2+DaysRange, 0, 0, 0, 0, time.Local).Add(-1 * time.Nanosecond)
+	timeMin := datetimeMin.Format(time.RFC3339)
+	timeMax := datetimeMax.Format(time.RFC3339)
+	// 予約の週の上限（MaxPerWeek）を数えるため、Googleカレンダーの予定は期間を含むISO週（月曜0時〜翌週の月曜0時）の分を取得する。
+	// 期間外の予定は予約の集計にのみ使う。
+	weekMin := isoWeekStart(datetimeMin)
+	weekMax := isoWeekStart(datetimeMax).AddDate(0, 0, 7)
+	a := &availability{From: datetimeMin, To: datetimeMax, Busy: make(map[string]schedule.Intervals)}
+
+	// 祝日のdateを保持する配列
+	// ex: ["2022-04-29", "2022-05-02"]
+	a.HolidayDates = make([]string, 0, 0)
+	holidayCalendarId := "ja.japanese#holiday@group.v.calendar.google.com"
+	var holidayCalendarEvents *calendar.Events
+	err := gcal.Retry(ctx, "events.list", func() error {
+		var err error
+		holidayCalendarEvents, err = calendarService.Events.List(holidayCalendarId).MaxResults(int64(250)).TimeMin(timeMin).TimeMax(timeMax).TimeZone(DefaultTimeZone).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range holidayCalendarEvents.Items {
+		a.HolidayDates = append(a.HolidayDates, v.Start.Date)
+	}
+
+	// キャッシュにない日があるカレンダーのみ、並行して予定を取得する。
+	// key: カレンダーID
+	googleEvents := make(map[string][]*schedule.Event)
+	misses := make([]string, 0, len(calendarIds))
+	for _, id := range calendarIds {
+		if opts.Cache != nil {
+			busy, ok, err := opts.Cache.Get(ctx, cache.NamespaceEvents, id, weekMin, weekMax)
+			if err != nil {
+				log.Printf("cache: %v", err)
+			}
+			if ok {
+				googleEvents[id] = eventsFromCache(id, busy)
+				continue
+			}
+		}
+		misses = append(misses, id)
+	}
+	if len(misses) > 0 {
+		fetcher := &gcal.Fetcher{Service: calendarService, Workers: opts.Workers, Timeout: opts.Timeout, TimeZone: DefaultTimeZone}
+		calendarEvents, calendarErrs := fetcher.FetchEvents(ctx, misses, weekMin.Format(time.RFC3339), weekMax.Format(time.RFC3339))
+		for _, err := range calendarErrs {
+			log.Printf("skip calendar: %v", err)
+		}
+		for _, events := range calendarEvents {
+			calendarId := events.CalendarId
+			converted := make([]*schedule.Event, 0, len(events.Items))
+			for _, item := range events.Items {
+				event, err := schedule.NewEvent(calendarId, events.Summary, item.Summary, item, time.Local)
+				if err != nil {
+					return nil, err
+				}
+				// log.Printf("calendar name: %v\ttitle: %+v\tstart: %+v\tend: %+v\n", event.CalendarName, event.Title, event.StartDateTime, event.EndDateTime)
+				converted = append(converted, event)
+			}
+			googleEvents[calendarId] = converted
+			if opts.Cache != nil {
+				if err := opts.Cache.Put(ctx, cache.NamespaceEvents, calendarId, weekMin, weekMax, busyForCache(converted)); err != nil {
+					log.Printf("cache: %v", err)
+				}
+			}
+		}
+	}
+
+	// Google以外のカレンダー（.icsファイル・URL）の予定も同じカレンダーとして扱う。
+	// key: カレンダーID
+	feedEvents := make(map[string][]*schedule.Event)
+	feedIds := make([]string, 0)
+	for _, source := range opts.Feeds {
+		feed := ical.ParseFeed(source)
+		events, err := feed.Events(ctx, datetimeMin, datetimeMax, time.Local)
+		if err != nil {
+			log.Printf("skip calendar: %v", err)
+			continue
+		}
+		feedEvents[feed.Id] = events
+		feedIds = append(feedIds, feed.Id)
+	}
+	if len(googleEvents) == 0 && len(feedIds) == 0 {
+		return nil, fmt.Errorf("no calendar could be fetched")
+	}
+
+	// カレンダー × 日付の領域をまとめて確保し、予定のbitsを集約する。
+	// 予定がない日・カレンダーは0（空き）のままになる。
+	a.CalendarIds = make([]string, 0, len(googleEvents)+len(feedIds))
+	for _, id := range calendarIds {
+		if _, ok := googleEvents[id]; ok {
+			a.CalendarIds = append(a.CalendarIds, id)
+		}
+	}
+	a.CalendarIds = append(a.CalendarIds, feedIds...)
+	a.Bits = schedule.NewDenseBits(a.CalendarIds, datetimeMin, DaysRange+1, time.Local)
+
+	a.Bookings = make([]*schedule.Event, 0)
+	a.Events = make([]*schedule.Event, 0)
+	for _, id := range a.CalendarIds {
+		events := googleEvents[id]
+		if events == nil {
+			events = feedEvents[id]
+		}
+		for _, event := range events {
+			if !event.EndDateTime.After(a.From) || event.StartDateTime.After(a.To) {
+				// 週の上限の集計のために取得した期間外の予定
+				if event.IsBooking {
+					a.Bookings = append(a.Bookings, event)
+				}
+				continue
+			}
+			a.addEvent(event)
+		}
+	}
+	return a, nil
+}
+
+// isoWeekStart tを含むISO週の月曜0時を返す。
+func isoWeekStart(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7 // 月曜からの日数
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+}
+
+// busyForCache キャッシュに入れる予定ありの期間に変換する。
+func busyForCache(events []*schedule.Event) []cache.Busy {
+	busy := make([]cache.Busy, 0, len(events))
+	for _, e := range events {
+		busy = append(busy, cache.Busy{Id: e.Id, Start: e.StartDateTime, End: e.EndDateTime, Booking: e.IsBooking, Tentative: e.IsTentative})
+	}
+	return busy
+}
+
+// eventsFromCache キャッシュの予定ありの期間をEventに戻す。タイトルなどは復元しない。
+func eventsFromCache(calendarId string, busy []cache.Busy) []*schedule.Event {
+	events := make([]*schedule.Event, 0, len(busy))
+	for _, b := range busy {
+		events = append(events, &schedule.Event{
+			Id:            b.Id,
+			CalendarId:    calendarId,
+			IsBooking:     b.Booking,
+			IsTentative:   b.Tentative,
+			StartDateTime: b.Start.In(time.Local),
+			EndDateTime:   b.End.In(time.Local),
+		})
+	}
+	return events
+}
+
+// addEvent 予定をbitsと予定ありの期間に加える。
+func (a *availability) addEvent(event *schedule.Event) {
+	// "2022/04/16": 000000000000000000001111110001100001000110000000
+	// 1日に複数イベントがある場合や日をまたぐ場合もDenseBits側で集約する。
+	a.Bits.AddEvent(event)
+	if event.IsBooking {
+		a.Bookings = append(a.Bookings, event)
+	}
+	a.Busy[event.CalendarId] = append(a.Busy[event.CalendarId], schedule.Interval{Start: event.StartDateTime, End: event.EndDateTime})
+	a.Events = append(a.Events, event)
+}
+
+// FreeTimeSchedules 予約上限を反映したCalendarBitsと、レスポンス用のFreeTimeSchedulesを返す。
+func (a *availability) FreeTimeSchedules(meetingMinutes, stepMinutes int, lang Locale) (schedule.CalendarBits, FreeTimeSchedules, error) {
+	calendarBits := a.Bits.CalendarBits()
+
+	// 予約の上限に達したカレンダーはその日（週）の空き時間の対象から外す。
+	applyBookingCaps(calendarBits, a.Bookings, bookingCaps, meetingMinutes)
+
+	// for date, v := range calendarBits {
+	// 	fmt.Printf("------ %v ------\n", date)
+	// 	for email, bits := range v {
+	// 		fmt.Printf("\t%v %064b\n", email, bits)
+	// 	}
+	// }
+
+	schedules, err := buildFreeTimeSchedules(calendarBits, a.Busy, a.HolidayDates, meetingMinutes, stepMinutes, lang)
+	if err != nil {
+		return nil, nil, err
+	}
+	return calendarBits, schedules, nil
+}
+
+// FreeBusy 仮の予定をBUSY-TENTATIVEとして区別できるよう、予定から直接VFREEBUSYを作成する。
+func (a *availability) FreeBusy() []ical.FreeBusy {
+	components := ical.FromEvents(a.CalendarIds, a.Events, a.From, a.To)
+	return ical.WithAggregate(components, a.From, a.To)
+}
+
+// formatter 出力形式がicsの場合は、この期間の予定から作ったVFREEBUSYを持たせる。
+// CLI（-format ics）とHTTP（?format=ics / Accept: text/calendar）で同じ出力にする。
+func (a *availability) formatter(f Formatter, now time.Time) Formatter {
+	if _, ok := f.(icsFormatter); ok {
+		return icsFormatter{components: a.FreeBusy(), now: now}
+	}
+	return f
+}
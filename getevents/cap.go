@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"google-calendar-sample/schedule"
+	"time"
+)
+
+// BookingCap カレンダーごとの予約数の上限
+// 0の項目は上限なしとして扱う。
+type BookingCap struct {
+	MaxPerDay        int // 1日あたりの予約件数
+	MaxPerWeek       int // 1週間（月曜始まり）あたりの予約件数
+	MaxMinutesPerDay int // 1日あたりの予約時間（分）の合計
+}
+
+// sample booking caps
+// key: カレンダーID
+var bookingCaps = map[string]BookingCap{
+	"kg090637fo0f1lg5s3ham2bhk8@group.calendar.google.com": {MaxPerDay: 3, MaxPerWeek: 10, MaxMinutesPerDay: 240},
+	"0lqtb45e5rpi3jmvjs4kcrrh94@group.calendar.google.com": {MaxPerDay: 2, MaxPerWeek: 8},
+}
+
+// bookingUsage 期間内の予約件数と予約時間（分）の集計
+type bookingUsage struct {
+	Count   int
+	Minutes int
+}
+
+// weekKey ISO週（月曜始まり）のキーを返す。 ex: 2022-W16
+func weekKey(date time.Time) string {
+	year, week := date.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// applyBookingCaps 予約の上限に達したカレンダーを、その日（週）の残りの期間すべて予定ありにする。
+//
+// 日付:bits の集約は論理積のため、上限に達したカレンダーのbitsをすべて1にすると
+// そのカレンダー（ホスト）は空き時間の対象から外れる。
+// ex: MaxPerDay: 2 で 2022/04/18 に予約が2件ある場合
+// {"2022/04/18": {"hoge@example.com": 0000001100110000...}}
+// -> {"2022/04/18": {"hoge@example.com": 1111111111111111...}}
+//
+// meetingMinutes はこれから入れる会議の時間で、MaxMinutesPerDayを超えてしまう日も対象外にする。
+// MaxPerWeekを判定するため、bookingsには期間を含む週の予約をすべて渡す（fetchAvailabilityを参照）。
+func applyBookingCaps(calendarBits schedule.CalendarBits, bookings []*schedule.Event, caps map[string]BookingCap, meetingMinutes int) {
+	// key: カレンダーID -> 日付 / 週
+	dailyUsage := make(map[string]map[string]*bookingUsage)
+	weeklyUsage := make(map[string]map[string]*bookingUsage)
+	for _, booking := range bookings {
+		id := booking.CalendarId
+		if _, ok := dailyUsage[id]; !ok {
+			dailyUsage[id] = make(map[string]*bookingUsage)
+			weeklyUsage[id] = make(map[string]*bookingUsage)
+		}
+		date := booking.StartDateTime.Format(FormatDate)
+		week := weekKey(booking.StartDateTime)
+		if _, ok := dailyUsage[id][date]; !ok {
+			dailyUsage[id][date] = &bookingUsage{}
+		}
+		if _, ok := weeklyUsage[id][week]; !ok {
+			weeklyUsage[id][week] = &bookingUsage{}
+		}
+		minutes := int(booking.EndDateTime.Sub(booking.StartDateTime).Minutes())
+		dailyUsage[id][date].Count++
+		dailyUsage[id][date].Minutes += minutes
+		weeklyUsage[id][week].Count++
+		weeklyUsage[id][week].Minutes += minutes
+	}
+
+	for strDate, v := range calendarBits {
+		date, err := time.ParseInLocation(FormatDate, strDate, time.Local)
+		if err != nil {
+			continue
+		}
+		for id := range v {
+			bookingCap, ok := caps[id]
+			if !ok {
+				continue
+			}
+			if bookingCap.isReached(dailyUsage[id][strDate], weeklyUsage[id][weekKey(date)], meetingMinutes) {
+				calendarBits[strDate][id] = schedule.FullDayBits
+			}
+		}
+	}
+}
+
+// isReached 1日分・1週間分の予約状況が上限に達しているかを判定する。
+// 予約がない日（daily == nil）でも、会議時間だけでMaxMinutesPerDayを超える場合は上限に達しているとする。
+func (c BookingCap) isReached(daily, weekly *bookingUsage, meetingMinutes int) bool {
+	if daily == nil {
+		daily = &bookingUsage{}
+	}
+	if c.MaxPerDay > 0 && daily.Count >= c.MaxPerDay {
+		return true
+	}
+	if c.MaxMinutesPerDay > 0 && daily.Minutes+meetingMinutes > c.MaxMinutesPerDay {
+		return true
+	}
+	if weekly != nil {
+		if c.MaxPerWeek > 0 && weekly.Count >= c.MaxPerWeek {
+			return true
+		}
+	}
+	return false
+}
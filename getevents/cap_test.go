@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"google-calendar-sample/schedule"
+	"google.golang.org/api/calendar/v3"
+	"testing"
+	"time"
+)
+
+func TestBookingCapIsReached(t *testing.T) {
+	tests := []struct {
+		name           string
+		cap            BookingCap
+		daily, weekly  *bookingUsage
+		meetingMinutes int
+		want           bool
+	}{
+		{name: "no cap", cap: BookingCap{}, daily: &bookingUsage{Count: 10, Minutes: 600}, meetingMinutes: 30, want: false},
+		{name: "below daily count", cap: BookingCap{MaxPerDay: 2}, daily: &bookingUsage{Count: 1}, meetingMinutes: 30, want: false},
+		{name: "daily count reached", cap: BookingCap{MaxPerDay: 2}, daily: &bookingUsage{Count: 2}, meetingMinutes: 30, want: true},
+		{name: "daily minutes exceeded by meeting", cap: BookingCap{MaxMinutesPerDay: 120}, daily: &bookingUsage{Count: 1, Minutes: 90}, meetingMinutes: 60, want: true},
+		{name: "daily minutes just fit", cap: BookingCap{MaxMinutesPerDay: 120}, daily: &bookingUsage{Count: 1, Minutes: 60}, meetingMinutes: 60, want: false},
+		{name: "no bookings but meeting longer than cap", cap: BookingCap{MaxMinutesPerDay: 60}, meetingMinutes: 90, want: true},
+		{name: "no bookings and meeting fits", cap: BookingCap{MaxMinutesPerDay: 60, MaxPerDay: 1}, meetingMinutes: 60, want: false},
+		{name: "weekly count reached", cap: BookingCap{MaxPerWeek: 3}, weekly: &bookingUsage{Count: 3}, meetingMinutes: 30, want: true},
+		{name: "below weekly count", cap: BookingCap{MaxPerWeek: 3}, daily: &bookingUsage{Count: 1}, weekly: &bookingUsage{Count: 2}, meetingMinutes: 30, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cap.isReached(tt.daily, tt.weekly, tt.meetingMinutes); got != tt.want {
+				t.Errorf("isReached() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyBookingCaps(t *testing.T) {
+	const a, b = "a@example.com", "b@example.com"
+	booking := func(id string, day, hour, minutes int) *schedule.Event {
+		start := time.Date(2022, 4, day, hour, 0, 0, 0, time.Local)
+		return &schedule.Event{CalendarId: id, IsBooking: true, StartDateTime: start, EndDateTime: start.Add(time.Duration(minutes) * time.Minute)}
+	}
+	// 2022/04/18(月)〜2022/04/24(日) は同じISO週、2022/04/25(月) は翌週
+	dates := []string{"2022/04/18", "2022/04/19", "2022/04/20", "2022/04/25"}
+	newBits := func() schedule.CalendarBits {
+		bits := schedule.NewCalendarBits()
+		for _, date := range dates {
+			bits.Set(date, a, 0)
+			bits.Set(date, b, 0)
+		}
+		return bits
+	}
+
+	t.Run("daily cap", func(t *testing.T) {
+		bits := newBits()
+		caps := map[string]BookingCap{a: {MaxPerDay: 2}}
+		applyBookingCaps(bits, []*schedule.Event{booking(a, 18, 10, 30), booking(a, 18, 13, 30), booking(a, 19, 10, 30)}, caps, 30)
+		want := map[string]uint64{"2022/04/18": schedule.FullDayBits, "2022/04/19": 0, "2022/04/20": 0, "2022/04/25": 0}
+		for date, bitsA := range want {
+			if got := bits[date][a]; got != bitsA {
+				t.Errorf("%s %s = %064b, want %064b", date, a, got, bitsA)
+			}
+			if got := bits[date][b]; got != 0 {
+				t.Errorf("%s %s (no cap) = %064b, want 0", date, b, got)
+			}
+		}
+	})
+
+	t.Run("weekly cap", func(t *testing.T) {
+		bits := newBits()
+		caps := map[string]BookingCap{a: {MaxPerWeek: 2}}
+		applyBookingCaps(bits, []*schedule.Event{booking(a, 18, 10, 30), booking(a, 19, 10, 30)}, caps, 30)
+		for _, date := range []string{"2022/04/18", "2022/04/19", "2022/04/20"} {
+			if got := bits[date][a]; got != schedule.FullDayBits {
+				t.Errorf("%s = %064b, want full day", date, got)
+			}
+		}
+		if got := bits["2022/04/25"][a]; got != 0 {
+			t.Errorf("next week = %064b, want 0", got)
+		}
+	})
+
+	t.Run("minutes cap on day without bookings", func(t *testing.T) {
+		bits := newBits()
+		caps := map[string]BookingCap{a: {MaxMinutesPerDay: 60}}
+		applyBookingCaps(bits, nil, caps, 90)
+		for _, date := range dates {
+			if got := bits[date][a]; got != schedule.FullDayBits {
+				t.Errorf("%s = %064b, want full day", date, got)
+			}
+		}
+	})
+}
+
+// TestFetchAvailabilityWeeklyCap 取得期間の前後で同じ週の予約もMaxPerWeekに数えることを確認する。
+func TestFetchAvailabilityWeeklyCap(t *testing.T) {
+	id := calendarIds[1] // MaxPerDay: 2, MaxPerWeek: 8
+	if got := bookingCaps[id].MaxPerWeek; got != 8 {
+		t.Fatalf("MaxPerWeek = %d, want 8", got)
+	}
+	items := make([]*calendar.Event, 0)
+	book := func(day int, hours ...int) {
+		for _, hour := range hours {
+			start := time.Date(2022, 4, day, hour, 0, 0, 0, time.Local)
+			event := fakeEvent(start, start.Add(30*time.Minute), "confirmed")
+			event.Id = fmt.Sprintf("booking%d", len(items))
+			event.ExtendedProperties = &calendar.EventExtendedProperties{Private: map[string]string{schedule.BookingPropertyKey: "true"}}
+			items = append(items, event)
+		}
+	}
+	// 2022/04/20(水)に取得すると期間は04/21(木)〜05/05(木)、取得する週は04/18(月)〜05/08(日)
+	book(18, 10, 11)
+	book(19, 10, 11)
+	book(20, 10, 11)
+	book(21, 10, 11) // 期間内。同じ週で8件目
+	// 05/06(金)〜05/08(日) 期間後だが05/02(月)からの週で8件
+	book(36, 10, 11)
+	book(37, 10, 11, 12)
+	book(38, 10, 11, 12)
+	book(45, 10) // 取得する週の外
+
+	service := newFakeCalendarService(t, map[string][]*calendar.Event{id: items})
+
+	now := time.Date(2022, 4, 20, 12, 0, 0, 0, time.Local)
+	a, err := fetchAvailability(context.Background(), service, now, options{Workers: 2, Timeout: 5 * time.Second, Locale: locales[DefaultLang]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(a.Bookings); got != 16 {
+		t.Errorf("len(Bookings) = %d, want 16", got)
+	}
+	// 期間外の予定は空き時間の計算には使わない。
+	events := 0
+	for _, e := range a.Events {
+		if e.CalendarId == id {
+			events++
+		}
+	}
+	if events != 2 {
+		t.Errorf("events in range = %d, want 2", events)
+	}
+
+	bits, _, err := a.FreeTimeSchedules(30, 30, locales[DefaultLang])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, date := range []string{"2022/04/21", "2022/04/22", "2022/04/24", "2022/05/02", "2022/05/05"} {
+		if got := bits[date][id]; got != schedule.FullDayBits {
+			t.Errorf("%s = %048b, want full day", date, got)
+		}
+	}
+	if got := bits["2022/04/25"][id]; got != 0 {
+		t.Errorf("2022/04/25 = %048b, want 0", got)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"google-calendar-sample/ical"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formatter FreeTimeSchedulesの出力形式
+type Formatter interface {
+	// ContentType HTTPで返すContent-Type
+	ContentType() string
+	Format(w io.Writer, schedules FreeTimeSchedules) error
+}
+
+// formatters key: -format / ?format= で指定する名前
+var formatters = map[string]Formatter{
+	"json":     jsonFormatter{},
+	"csv":      csvFormatter{},
+	"markdown": markdownFormatter{},
+	"text":     textFormatter{},
+	"ics":      icsFormatter{},
+}
+
+// DefaultFormat 指定がない場合の出力形式
+const DefaultFormat = "json"
+
+// formatterByName 名前から出力形式を返す。 ex: "csv", "md"
+func formatterByName(name string) (Formatter, error) {
+	if name == "md" {
+		name = "markdown"
+	}
+	f, ok := formatters[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q (json, csv, markdown, text or ics)", name)
+	}
+	return f, nil
+}
+
+// formatterByAccept Acceptヘッダーから、qの大きい順に対応している出力形式を返す。
+// 対応する形式がなければfalseを返す。Acceptヘッダーがない・*/* の場合はDefaultFormat
+//
+// ex: Accept: text/csv;q=0.9, text/markdown -> markdown
+func formatterByAccept(accept string) (Formatter, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return formatters[DefaultFormat], true
+	}
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	ranges := make([]mediaRange, 0)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, r := range ranges {
+		switch r.mediaType {
+		case "application/json", "*/*", "application/*":
+			return formatters["json"], true
+		case "text/csv":
+			return formatters["csv"], true
+		case "text/markdown", "text/x-markdown":
+			return formatters["markdown"], true
+		case "text/plain", "text/*":
+			return formatters["text"], true
+		case "text/calendar":
+			return formatters["ics"], true
+		}
+	}
+	return nil, false
+}
+
+// jsonFormatter インデントしたJSON
+type jsonFormatter struct{}
+
+func (jsonFormatter) ContentType() string { return "application/json; charset=utf-8" }
+
+func (jsonFormatter) Format(w io.Writer, schedules FreeTimeSchedules) error {
+	b, err := json.MarshalIndent(schedules, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// csvFormatter 空き時間ごとに1行のCSV（スプレッドシート用）
+//
+// ex:
+// date,weekday,time,calendars
+// 2022/04/18,月,10:00,example@gmail.com example2@gmail.com
+type csvFormatter struct{}
+
+func (csvFormatter) ContentType() string { return "text/csv; charset=utf-8" }
+
+func (csvFormatter) Format(w io.Writer, schedules FreeTimeSchedules) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"date", "weekday", "time", "calendars"}); err != nil {
+		return err
+	}
+	for _, s := range schedules {
+		for _, t := range s.FreeTimes {
+			record := []string{s.FreeTimeDate.Value, s.FreeTimeDate.Weekday, t.Text, strings.Join(t.CalendarIds, " ")}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// markdownFormatter チャットに貼り付ける用のMarkdownの表
+//
+// ex:
+// | date | weekday | time | calendars |
+// | --- | --- | --- | --- |
+// | 04/18 | 月 | 10:00 | example@gmail.com, example2@gmail.com |
+type markdownFormatter struct{}
+
+func (markdownFormatter) ContentType() string { return "text/markdown; charset=utf-8" }
+
+func (markdownFormatter) Format(w io.Writer, schedules FreeTimeSchedules) error {
+	var b strings.Builder
+	b.WriteString("| date | weekday | time | calendars |\n")
+	b.WriteString("| --- | --- | --- | --- |\n")
+	for _, s := range schedules {
+		for _, t := range s.FreeTimes {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n",
+				markdownCell(s.FreeTimeDate.Text), markdownCell(s.FreeTimeDate.Weekday), markdownCell(t.Text), markdownCell(strings.Join(t.CalendarIds, ", ")))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownCell 表が崩れないよう | と改行をエスケープする。
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+// textFormatter 日付ごとに空き時間を並べたテキスト
+//
+// ex:
+//
+//	04/18 (月)
+//	  10:00  example@gmail.com, example2@gmail.com
+//	04/19 (火)
+//	  -
+type textFormatter struct{}
+
+func (textFormatter) ContentType() string { return "text/plain; charset=utf-8" }
+
+func (textFormatter) Format(w io.Writer, schedules FreeTimeSchedules) error {
+	var b strings.Builder
+	for _, s := range schedules {
+		fmt.Fprintf(&b, "%s (%s)\n", s.FreeTimeDate.Text, s.FreeTimeDate.Weekday)
+		if len(s.FreeTimes) == 0 {
+			b.WriteString("  -\n")
+			continue
+		}
+		for _, t := range s.FreeTimes {
+			fmt.Fprintf(&b, "  %s  %s\n", t.Text, strings.Join(t.CalendarIds, ", "))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// icsFormatter 予定から作ったVFREEBUSYのiCalendar（仮の予定はBUSY-TENTATIVE）
+//
+// 空き時間（FreeTimeSchedules）ではなく予定から作るため、availability.formatterでVFREEBUSYを持たせてから使う。
+type icsFormatter struct {
+	components []ical.FreeBusy
+	now        time.Time
+}
+
+func (icsFormatter) ContentType() string { return ical.ContentType }
+
+func (f icsFormatter) Format(w io.Writer, _ FreeTimeSchedules) error {
+	return ical.WriteFreeBusy(w, f.components, f.now)
+}
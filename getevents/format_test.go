@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// testSchedules 空き時間のある日・ない日を含むFreeTimeSchedules
+var testSchedules = FreeTimeSchedules{
+	{
+		FreeTimeDate: FreeTimeDate{Value: "2022/04/18", Text: "04/18", Weekday: "月"},
+		FreeTimes: []FreeTime{
+			{Value: "2022-04-18T10:00:00+09:00", Text: "10:00", CalendarIds: []string{"a@example.com", "b@example.com"}},
+			// Markdownの表が崩れないよう | はエスケープする。
+			{Value: "2022-04-18T10:30:00+09:00", Text: "10:30", CalendarIds: []string{"a|b@example.com"}},
+		},
+		FreeWindows: []FreeWindow{},
+	},
+	{
+		FreeTimeDate: FreeTimeDate{Value: "2022/04/19", Text: "04/19", Weekday: "火"},
+		FreeTimes:    []FreeTime{},
+		FreeWindows:  []FreeWindow{},
+	},
+}
+
+func TestFormatters(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		want        string
+	}{
+		{
+			name:        "csv",
+			contentType: "text/csv; charset=utf-8",
+			want: "date,weekday,time,calendars\n" +
+				"2022/04/18,月,10:00,a@example.com b@example.com\n" +
+				"2022/04/18,月,10:30,a|b@example.com\n",
+		},
+		{
+			name:        "markdown",
+			contentType: "text/markdown; charset=utf-8",
+			want: "| date | weekday | time | calendars |\n" +
+				"| --- | --- | --- | --- |\n" +
+				"| 04/18 | 月 | 10:00 | a@example.com, b@example.com |\n" +
+				"| 04/18 | 月 | 10:30 | a\\|b@example.com |\n",
+		},
+		{
+			name:        "text",
+			contentType: "text/plain; charset=utf-8",
+			want: "04/18 (月)\n" +
+				"  10:00  a@example.com, b@example.com\n" +
+				"  10:30  a|b@example.com\n" +
+				"04/19 (火)\n" +
+				"  -\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := formatterByName(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.ContentType(); got != tt.contentType {
+				t.Errorf("ContentType() = %q, want %q", got, tt.contentType)
+			}
+			var buf bytes.Buffer
+			if err := f.Format(&buf, testSchedules); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("Format() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONFormatterRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (jsonFormatter{}).Format(&buf, testSchedules); err != nil {
+		t.Fatal(err)
+	}
+	var got FreeTimeSchedules
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, testSchedules) {
+		t.Errorf("round trip = %+v, want %+v", got, testSchedules)
+	}
+}
+
+func TestFormatterByName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string // 出力形式のContentType
+		wantErr bool
+	}{
+		{name: "json", want: "application/json; charset=utf-8"},
+		{name: "CSV", want: "text/csv; charset=utf-8"},
+		{name: "md", want: "text/markdown; charset=utf-8"},
+		{name: "ics", want: "text/calendar; charset=utf-8"},
+		{name: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := formatterByName(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("formatterByName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && f.ContentType() != tt.want {
+				t.Errorf("ContentType() = %q, want %q", f.ContentType(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatterByAccept(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string // 出力形式の名前。空は406
+	}{
+		{accept: "", want: "json"},
+		{accept: "*/*", want: "json"},
+		{accept: "application/json", want: "json"},
+		{accept: "application/*", want: "json"},
+		{accept: "text/csv", want: "csv"},
+		{accept: "text/markdown", want: "markdown"},
+		{accept: "text/x-markdown", want: "markdown"},
+		{accept: "text/plain", want: "text"},
+		{accept: "text/*", want: "text"},
+		{accept: "text/calendar", want: "ics"},
+		{accept: "text/csv;q=0.9, text/markdown", want: "markdown"},
+		{accept: "text/csv;q=0.5, text/plain;q=0.8, */*;q=0.1", want: "text"},
+		// qが同じ場合は先に書かれた方
+		{accept: "text/csv, text/plain", want: "csv"},
+		// 対応していない形式は飛ばす。
+		{accept: "text/html, application/xhtml+xml, */*;q=0.8", want: "json"},
+		{accept: "text/csv;q=0, text/plain;q=0.1", want: "text"},
+		{accept: "text/csv;q=abc, text/plain", want: "text"},
+		{accept: "text/html", want: ""},
+		{accept: "text/csv;q=0", want: ""},
+		{accept: "invalid;;", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			f, ok := formatterByAccept(tt.accept)
+			if ok != (tt.want != "") {
+				t.Fatalf("formatterByAccept() ok = %v, want %v", ok, tt.want != "")
+			}
+			if !ok {
+				return
+			}
+			if got, want := f.ContentType(), formatters[tt.want].ContentType(); got != want {
+				t.Errorf("ContentType() = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLang 指定がない場合の言語
+const DefaultLang = "ja"
+
+// Locale 曜日・日付・時刻の表示形式
+type Locale struct {
+	Lang        string
+	Weekdays    [7]string // 日曜始まり
+	DateLayout  string    // time.Formatの書式
+	Hour12      bool      // 12時間表記
+	AM          string
+	PM          string
+	PeriodFirst bool // 午前・午後を時刻の前に置く ex: 午後1:00
+}
+
+// locales key: 言語（ISO 639-1）
+var locales = map[string]Locale{
+	"ja": {Lang: "ja", Weekdays: [7]string{"日", "月", "火", "水", "木", "金", "土"}, DateLayout: "01/02", AM: "午前", PM: "午後", PeriodFirst: true},
+	"en": {Lang: "en", Weekdays: [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}, DateLayout: "Jan 2", Hour12: true, AM: " AM", PM: " PM"},
+	"ko": {Lang: "ko", Weekdays: [7]string{"일", "월", "화", "수", "목", "금", "토"}, DateLayout: "1월 2일", AM: "오전 ", PM: "오후 ", PeriodFirst: true},
+	"zh": {Lang: "zh", Weekdays: [7]string{"日", "一", "二", "三", "四", "五", "六"}, DateLayout: "1月2日", AM: "上午", PM: "下午", PeriodFirst: true},
+}
+
+// Weekday ex: 土, Sat
+func (l Locale) Weekday(t time.Time) string {
+	return l.Weekdays[t.Weekday()]
+}
+
+// Date ex: 04/16, Apr 16, 4월 16일, 4月16日
+func (l Locale) Date(t time.Time) string {
+	return t.Format(l.DateLayout)
+}
+
+// Time ex: 19:00, 7:00 PM, 午後7:00
+func (l Locale) Time(t time.Time) string {
+	if !l.Hour12 {
+		return t.Format("15:04")
+	}
+	period := l.AM
+	if t.Hour() >= 12 {
+		period = l.PM
+	}
+	if l.PeriodFirst {
+		return period + t.Format("3:04")
+	}
+	return t.Format("3:04") + period
+}
+
+// WithClock 12時間表記（clock: "12"）か24時間表記（clock: "24"）に切り替えたLocaleを返す。空文字は言語の既定のまま
+func (l Locale) WithClock(clock string) (Locale, error) {
+	switch clock {
+	case "":
+	case "12":
+		l.Hour12 = true
+	case "24":
+		l.Hour12 = false
+	default:
+		return l, fmt.Errorf("clock must be 12 or 24: %q", clock)
+	}
+	return l, nil
+}
+
+// localeByName 言語タグからLocaleを返す。地域・文字体系は区別しない。 ex: ja-JP -> ja, zh-Hant-TW -> zh
+func localeByName(tag string) (Locale, error) {
+	lang := strings.ToLower(strings.SplitN(strings.ReplaceAll(tag, "_", "-"), "-", 2)[0])
+	l, ok := locales[lang]
+	if !ok {
+		return Locale{}, fmt.Errorf("unsupported language %q (ja, en, ko or zh)", tag)
+	}
+	return l, nil
+}
+
+// localeByAcceptLanguage Accept-Languageヘッダーから、qの大きい順に対応している言語のLocaleを返す。
+// 対応する言語がなければDefaultLang
+//
+// ex: Accept-Language: en-US,en;q=0.9,ja;q=0.8 -> en
+func localeByAcceptLanguage(header string) Locale {
+	type languageRange struct {
+		tag string
+		q   float64
+	}
+	ranges := make([]languageRange, 0)
+	for _, part := range strings.Split(header, ",") {
+		// "ja;q=0.8" をメディアタイプと同じ形式として読み込む。
+		tag, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, languageRange{tag: tag, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	for _, r := range ranges {
+		if l, err := localeByName(r.tag); err == nil {
+			return l
+		}
+	}
+	return locales[DefaultLang]
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocaleByAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: DefaultLang},
+		{header: "en", want: "en"},
+		{header: "en-US,en;q=0.9,ja;q=0.8", want: "en"},
+		{header: "ja;q=0.5, ko", want: "ko"},
+		{header: "zh-Hant-TW", want: "zh"},
+		{header: "EN_us", want: "en"},
+		// qが同じ場合は先に書かれた方
+		{header: "ko, zh", want: "ko"},
+		// 対応していない言語は飛ばす。
+		{header: "fr-FR, de;q=0.9, en;q=0.1", want: "en"},
+		{header: "en;q=0, ko;q=0.1", want: "ko"},
+		{header: "en;q=abc, zh;q=0.2", want: "zh"},
+		// 対応する言語がなければDefaultLang
+		{header: "fr, de", want: DefaultLang},
+		{header: "*", want: DefaultLang},
+		{header: "en;q=0", want: DefaultLang},
+		{header: ";;", want: DefaultLang},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := localeByAcceptLanguage(tt.header); got.Lang != tt.want {
+				t.Errorf("localeByAcceptLanguage(%q) = %q, want %q", tt.header, got.Lang, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocaleTime(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2022, 4, 18, h, m, 0, 0, time.Local) }
+	tests := []struct {
+		lang  string
+		clock string
+		t     time.Time
+		want  string
+	}{
+		{lang: "en", t: at(0, 0), want: "12:00 AM"},
+		{lang: "en", t: at(0, 30), want: "12:30 AM"},
+		{lang: "en", t: at(11, 59), want: "11:59 AM"},
+		{lang: "en", t: at(12, 0), want: "12:00 PM"},
+		{lang: "en", t: at(13, 5), want: "1:05 PM"},
+		{lang: "en", t: at(23, 59), want: "11:59 PM"},
+		{lang: "en", clock: "24", t: at(0, 0), want: "00:00"},
+		{lang: "en", clock: "24", t: at(19, 0), want: "19:00"},
+		{lang: "ja", t: at(0, 0), want: "00:00"},
+		{lang: "ja", t: at(19, 0), want: "19:00"},
+		{lang: "ja", clock: "12", t: at(12, 0), want: "午後12:00"},
+		{lang: "ja", clock: "12", t: at(19, 0), want: "午後7:00"},
+		{lang: "ko", clock: "12", t: at(0, 0), want: "오전 12:00"},
+		{lang: "zh", clock: "12", t: at(9, 30), want: "上午9:30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lang+"/"+tt.clock+"/"+tt.want, func(t *testing.T) {
+			l, err := locales[tt.lang].WithClock(tt.clock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := l.Time(tt.t); got != tt.want {
+				t.Errorf("Time(%v) = %q, want %q", tt.t.Format("15:04"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocaleWithClockInvalid(t *testing.T) {
+	if _, err := locales["en"].WithClock("13"); err == nil {
+		t.Error("WithClock(13): want error")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"google-calendar-sample/schedule"
+	"sort"
+	"time"
+)
+
+// buildFreeTimeSchedules CalendarBitsを日付順に並んだレスポンス用のFreeTimeSchedulesに変換する。
+//
+// 曜日・日付・時刻の表示はlangの形式にする。
+// CalendarBitsはmapのため、rangeの順序は実行ごとに変わる。
+// 日付・時間枠・カレンダーIDはすべて昇順に並べ、同じデータからは同じ出力になるようにする。
+func buildFreeTimeSchedules(calendarBits schedule.CalendarBits, busyIntervals map[string]schedule.Intervals, holidayDates []string, meetingMinutes, stepMinutes int, lang Locale) (FreeTimeSchedules, error) {
+	strDates := calendarBits.Dates()
+
+	schedules := make(FreeTimeSchedules, 0, len(strDates))
+	for _, strDate := range strDates {
+		date, err := time.Parse(FormatDate, strDate)
+		if err != nil {
+			return nil, err
+		}
+
+		calendarDate := FreeTimeDate{Value: date.Format(FormatDate), Text: lang.Date(date), Weekday: lang.Weekday(date)}
+		bt := FreeTimeSchedule{
+			FreeTimeDate: calendarDate,
+			FreeTimes:    make([]FreeTime, 0),
+			FreeWindows:  make([]FreeWindow, 0),
+		}
+
+		// 休日、祝日の場合は空いていないという形に変換する。
+		// Todo: そもそもbit換算時に全部1にできると良いかも
+		// times(FreeTimes)のみ空で返すでOK
+		if isHoliday(date, holidayDates) {
+			schedules = append(schedules, bt)
+			continue
+		}
+
+		// 会議時間分の空き枠が連続している開始時刻のみを空き時間とする。
+		// ex: 90分の会議なら30分枠が3つ連続して0である必要がある。
+		freeTimes, err := freeTimesForDate(date, calendarBits[strDate], meetingMinutes, stepMinutes, lang)
+		if err != nil {
+			return nil, err
+		}
+		bt.FreeTimes = freeTimes
+		bt.FreeWindows = freeWindowsForDate(date, calendarBits[strDate], busyIntervals, meetingMinutes, lang)
+		schedules = append(schedules, bt)
+	}
+	return schedules, nil
+}
+
+// freeWindowsForDate 営業時間内で、誰か1人でもmeetingMinutes以上続けて空いている期間を分単位で返す。
+//
+// 予約上限などで1日すべて予定ありにしたカレンダー（bitsがすべて1）は対象外にする。
+// ex: 10:00~10:15 / 11:45~12:00 に予定がある場合
+// bits（30分枠）では 10:30~11:30 の空きになるが、windowsでは 10:15~11:45 になる。
+func freeWindowsForDate(date time.Time, calendars map[string]uint64, busyIntervals map[string]schedule.Intervals, meetingMinutes int, lang Locale) []FreeWindow {
+	window := schedule.Interval{
+		Start: time.Date(date.Year(), date.Month(), date.Day(), StartMinTimeHour, 0, 0, 0, time.Local),
+		End:   time.Date(date.Year(), date.Month(), date.Day(), EndMaxTimeHour, 0, 0, 0, time.Local),
+	}
+	busy := make([]schedule.Intervals, 0, len(calendars))
+	for _, id := range sortedCalendarIds(calendars) {
+		if calendars[id]&schedule.FullDayBits == schedule.FullDayBits {
+			continue
+		}
+		busy = append(busy, busyIntervals[id])
+	}
+	// 全員が終日予定ありの日は空き時間がない（FreeWindowsはbusyが空だとwindow全体を返す）。
+	if len(busy) == 0 {
+		return make([]FreeWindow, 0)
+	}
+
+	free := schedule.FreeWindows(busy, window).AtLeast(time.Duration(meetingMinutes) * time.Minute)
+	windows := make([]FreeWindow, 0, len(free))
+	for _, w := range free {
+		w = schedule.Interval{Start: w.Start.In(time.Local), End: w.End.In(time.Local)}
+		windows = append(windows, FreeWindow{
+			Start: w.Start.Format(time.RFC3339),
+			End:   w.End.Format(time.RFC3339),
+			Text:  lang.Time(w.Start) + "-" + lang.Time(w.End),
+		})
+	}
+	return windows
+}
+
+// isHoliday 定休日（曜日）または祝日かを判定する。
+func isHoliday(date time.Time, holidayDates []string) bool {
+	for _, holiday := range regularHolidayWeekdays {
+		if holiday == date.Weekday() {
+			return true
+		}
+	}
+	for _, holiday := range holidayDates {
+		if holiday == date.Format("2006-01-02") {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedCalendarIds カレンダーIDを昇順で返す。
+func sortedCalendarIds(calendars map[string]uint64) []string {
+	ids := make([]string, 0, len(calendars))
+	for id := range calendars {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"google-calendar-sample/schedule"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// go test ./getevents -run TestBuildFreeTimeSchedules -update でgoldenファイルを作り直す。
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+func TestMain(m *testing.M) {
+	// 空き時間の時刻はtime.Localで出力されるため、実行環境によらず固定する。
+	loc, err := time.LoadLocation(DefaultTimeZone)
+	if err != nil {
+		panic(err)
+	}
+	time.Local = loc
+	os.Exit(m.Run())
+}
+
+// busyBits "HH:MM-HH:MM" の予定ありの期間からbitsを作る。終了は"24:00"も指定できる。
+func busyBits(t *testing.T, ranges ...string) uint64 {
+	t.Helper()
+	var bits uint64
+	for _, r := range ranges {
+		var sh, sm, eh, em int
+		if _, err := fmt.Sscanf(r, "%d:%d-%d:%d", &sh, &sm, &eh, &em); err != nil {
+			t.Fatalf("invalid range %q: %v", r, err)
+		}
+		bits |= schedule.RangeBits(sh*60+sm, eh*60+em)
+	}
+	return bits
+}
+
+func TestBuildFreeTimeSchedules(t *testing.T) {
+	const a, b = "a@example.com", "b@example.com"
+	day := func(d int) time.Time { return time.Date(2022, 4, d, 0, 0, 0, 0, time.Local) }
+	at := func(d, hour, minute int) time.Time {
+		return day(d).Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	}
+
+	tests := []struct {
+		name           string
+		bits           func(t *testing.T) schedule.CalendarBits
+		busy           map[string]schedule.Intervals
+		holidays       []string
+		meetingMinutes int
+		stepMinutes    int
+		lang           string
+	}{
+		{
+			// 2022/04/18(月) 2人とも一部予定あり、2022/04/19(火) 予定なし
+			name: "two_calendars",
+			bits: func(t *testing.T) schedule.CalendarBits {
+				c := schedule.NewCalendarBits()
+				c.Set("2022/04/18", a, busyBits(t, "08:00-12:00", "13:00-20:00"))
+				c.Set("2022/04/18", b, busyBits(t, "08:00-10:00", "11:00-19:30"))
+				c.Set("2022/04/19", a, 0)
+				c.Set("2022/04/19", b, busyBits(t, "00:00-24:00"))
+				return c
+			},
+			busy: map[string]schedule.Intervals{
+				a: {{Start: at(18, 8, 0), End: at(18, 12, 0)}, {Start: at(18, 13, 0), End: at(18, 20, 0)}},
+				b: {{Start: at(18, 8, 0), End: at(18, 10, 0)}, {Start: at(18, 11, 0), End: at(18, 19, 30)}, {Start: day(19), End: day(20)}},
+			},
+			meetingMinutes: 30,
+			stepMinutes:    30,
+			lang:           "ja",
+		},
+		{
+			// 90分の会議は30分枠が3つ連続して空いている開始時刻のみ。別々の人の空きはつなげない。
+			name: "long_meeting",
+			bits: func(t *testing.T) schedule.CalendarBits {
+				c := schedule.NewCalendarBits()
+				c.Set("2022/04/18", a, busyBits(t, "09:00-10:00", "11:30-20:00"))
+				c.Set("2022/04/18", b, busyBits(t, "08:00-11:00", "12:00-13:00", "14:00-20:00"))
+				return c
+			},
+			busy: map[string]schedule.Intervals{
+				a: {{Start: at(18, 9, 0), End: at(18, 10, 0)}, {Start: at(18, 11, 30), End: at(18, 20, 0)}},
+				b: {{Start: at(18, 8, 0), End: at(18, 11, 0)}, {Start: at(18, 12, 0), End: at(18, 13, 0)}, {Start: at(18, 14, 0), End: at(18, 20, 0)}},
+			},
+			meetingMinutes: 90,
+			stepMinutes:    30,
+			lang:           "en",
+		},
+		{
+			// 2022/04/20(水) は定休日、2022/04/29(金) は祝日のため空き時間なし
+			name: "holidays",
+			bits: func(t *testing.T) schedule.CalendarBits {
+				c := schedule.NewCalendarBits()
+				for _, date := range []string{"2022/04/20", "2022/04/22", "2022/04/29"} {
+					c.Set(date, a, busyBits(t, "08:00-18:00"))
+				}
+				return c
+			},
+			busy: map[string]schedule.Intervals{
+				a: {{Start: at(20, 8, 0), End: at(20, 18, 0)}, {Start: at(22, 8, 0), End: at(22, 18, 0)}, {Start: at(29, 8, 0), End: at(29, 18, 0)}},
+			},
+			holidays:       []string{"2022-04-29"},
+			meetingMinutes: 60,
+			stepMinutes:    60,
+			lang:           "ja",
+		},
+		{
+			// 予約上限で1日すべて予定ありにしたカレンダーはwindowsの対象外
+			name: "capped_calendar",
+			bits: func(t *testing.T) schedule.CalendarBits {
+				c := schedule.NewCalendarBits()
+				c.Set("2022/04/18", a, schedule.FullDayBits)
+				c.Set("2022/04/18", b, busyBits(t, "08:00-17:15"))
+				return c
+			},
+			busy: map[string]schedule.Intervals{
+				a: {{Start: at(18, 10, 0), End: at(18, 11, 0)}},
+				b: {{Start: at(18, 8, 0), End: at(18, 17, 15)}},
+			},
+			meetingMinutes: 60,
+			stepMinutes:    30,
+			lang:           "ko",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lang, err := localeByName(tt.lang)
+			if err != nil {
+				t.Fatal(err)
+			}
+			schedules, err := buildFreeTimeSchedules(tt.bits(t), tt.busy, tt.holidays, tt.meetingMinutes, tt.stepMinutes, lang)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.MarshalIndent(schedules, "", "    ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := filepath.Join("testdata", tt.name+".golden.json")
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("FreeTimeSchedules differ from %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+// TestBuildFreeTimeSchedulesDeterministic mapの順序によらず同じ出力になることを確認する。
+func TestBuildFreeTimeSchedulesDeterministic(t *testing.T) {
+	c := schedule.NewCalendarBits()
+	for _, date := range []string{"2022/04/25", "2022/04/18", "2022/04/22", "2022/04/19"} {
+		for _, id := range []string{"c@example.com", "a@example.com", "b@example.com"} {
+			c.Set(date, id, busyBits(t, "12:00-13:00"))
+		}
+	}
+	lang := locales[DefaultLang]
+	first, err := buildFreeTimeSchedules(c, nil, nil, 30, 30, lang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(first)
+	for i := 0; i < 20; i++ {
+		again, err := buildFreeTimeSchedules(c, nil, nil, 30, 30, lang)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := json.Marshal(again); !bytes.Equal(got, want) {
+			t.Fatalf("output changed between runs")
+		}
+	}
+	for i := 1; i < len(first); i++ {
+		if first[i-1].FreeTimeDate.Value >= first[i].FreeTimeDate.Value {
+			t.Errorf("dates not sorted: %s, %s", first[i-1].FreeTimeDate.Value, first[i].FreeTimeDate.Value)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"google.golang.org/api/calendar/v3"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// freeTimesHandler 空き時間を返すHTTPハンドラー
+//
+// 出力形式は ?format= を優先し、なければAcceptヘッダーから選ぶ。
+// format=ics / Accept: text/calendar は予定から作ったVFREEBUSY（仮の予定はBUSY-TENTATIVE）を返す。
+// ?duration= / ?step= で会議時間・開始時刻の刻み幅（分）を上書きできる。
+// 表示の言語は ?lang= を優先し、なければAccept-Languageヘッダーから選ぶ。?clock=12 で12時間表記にする。
+//
+// ex: GET /freetimes?duration=60&clock=12 (Accept: text/csv, Accept-Language: en-US)
+type freeTimesHandler struct {
+	Service *calendar.Service
+	Options options
+}
+
+func (h *freeTimesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var formatter Formatter
+	if name := r.URL.Query().Get("format"); name != "" {
+		f, err := formatterByName(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		formatter = f
+	} else {
+		f, ok := formatterByAccept(r.Header.Get("Accept"))
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+			return
+		}
+		formatter = f
+	}
+
+	opts := h.Options
+	for name, dst := range map[string]*int{"duration": &opts.MeetingMinutes, "step": &opts.StepMinutes} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "invalid "+name, http.StatusBadRequest)
+			return
+		}
+		*dst = n
+	}
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		l, err := localeByName(lang)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.Locale = l
+	} else if header := r.Header.Get("Accept-Language"); header != "" {
+		opts.Locale = localeByAcceptLanguage(header)
+	}
+	l, err := opts.Locale.WithClock(r.URL.Query().Get("clock"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Locale = l
+	if _, err := minutesToSlots(opts.MeetingMinutes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := minutesToSlots(opts.StepMinutes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	a, err := fetchAvailability(r.Context(), h.Service, now, opts)
+	if err != nil {
+		log.Printf("freetimes: %v", err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	_, schedules, err := a.FreeTimeSchedules(opts.MeetingMinutes, opts.StepMinutes, opts.Locale)
+	if err != nil {
+		log.Printf("freetimes: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := a.formatter(formatter, now).Format(&buf, schedules); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", formatter.ContentType())
+	w.Header().Add("Vary", "Accept, Accept-Language")
+	w.Write(buf.Bytes())
+}
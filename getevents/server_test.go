@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"google-calendar-sample/ical"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeCalendarService Events.Listにカレンダーごとの予定を返すhttptest.Serverに接続するcalendar.Serviceを返す。
+// itemsにないカレンダーは予定なしとする。APIと同じくtimeMin〜timeMaxにかかる予定のみ返す。
+func newFakeCalendarService(t *testing.T, items map[string][]*calendar.Event) *calendar.Service {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// /calendars/{calendarId}/events
+		parts := strings.Split(r.URL.Path, "/")
+		calendarId := ""
+		for i, p := range parts {
+			if p == "calendars" && i+1 < len(parts) {
+				calendarId = parts[i+1]
+			}
+		}
+		timeMin, _ := time.Parse(time.RFC3339, r.URL.Query().Get("timeMin"))
+		timeMax, _ := time.Parse(time.RFC3339, r.URL.Query().Get("timeMax"))
+		events := &calendar.Events{Summary: calendarId, Items: []*calendar.Event{}}
+		for _, item := range items[calendarId] {
+			start, _ := time.Parse(time.RFC3339, item.Start.DateTime)
+			end, _ := time.Parse(time.RFC3339, item.End.DateTime)
+			if !timeMax.IsZero() && !start.Before(timeMax) || !timeMin.IsZero() && !end.After(timeMin) {
+				continue
+			}
+			events.Items = append(events.Items, item)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events)
+	}))
+	t.Cleanup(srv.Close)
+	service, err := calendar.NewService(context.Background(), option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func fakeEvent(start, end time.Time, status string) *calendar.Event {
+	return &calendar.Event{
+		Summary: "meeting",
+		Status:  status,
+		Start:   &calendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+		End:     &calendar.EventDateTime{DateTime: end.Format(time.RFC3339)},
+	}
+}
+
+// TestFreeTimesHandlerICS HTTPでも予定から作ったVFREEBUSY（BUSY-TENTATIVEを含む）を返すことを確認する。
+func TestFreeTimesHandlerICS(t *testing.T) {
+	now := time.Now()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.Local)
+	service := newFakeCalendarService(t, map[string][]*calendar.Event{
+		calendarIds[0]: {fakeEvent(tomorrow.Add(10*time.Hour), tomorrow.Add(11*time.Hour), "tentative")},
+		calendarIds[1]: {fakeEvent(tomorrow.Add(10*time.Hour), tomorrow.Add(12*time.Hour), "confirmed")},
+		calendarIds[2]: {fakeEvent(tomorrow.Add(9*time.Hour), tomorrow.Add(13*time.Hour), "confirmed")},
+	})
+	h := &freeTimesHandler{Service: service, Options: options{MeetingMinutes: 30, StepMinutes: 30, Workers: 2, Timeout: 5 * time.Second, Locale: locales[DefaultLang]}}
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/freetimes?format=ics", nil),
+		func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/freetimes", nil)
+			r.Header.Set("Accept", "text/calendar")
+			return r
+		}(),
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", req.URL, rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("Content-Type"); got != ical.ContentType {
+			t.Errorf("Content-Type = %q", got)
+		}
+		cal, err := ical.Parse(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fbType := make(map[string]string)
+		for _, c := range cal.Children("VFREEBUSY") {
+			if p := c.Get("FREEBUSY"); p != nil {
+				fbType[c.Value("UID")] = p.Params["FBTYPE"]
+			}
+		}
+		want := map[string]string{
+			calendarIds[0]:     "BUSY-TENTATIVE",
+			calendarIds[1]:     "BUSY",
+			calendarIds[2]:     "BUSY",
+			ical.AggregatedUID: "BUSY-TENTATIVE",
+		}
+		for uid, w := range want {
+			if fbType[uid] != w {
+				t.Errorf("%s: FBTYPE = %q, want %q", uid, fbType[uid], w)
+			}
+		}
+	}
+}
+
+func TestFreeTimesHandlerStatus(t *testing.T) {
+	h := &freeTimesHandler{
+		Service: newFakeCalendarService(t, nil),
+		Options: options{MeetingMinutes: 30, StepMinutes: 30, Workers: 2, Timeout: 5 * time.Second, Locale: locales[DefaultLang]},
+	}
+	tests := []struct {
+		name            string
+		method          string
+		target          string
+		accept          string
+		wantStatus      int
+		wantContentType string
+	}{
+		{name: "default", target: "/freetimes", wantStatus: http.StatusOK, wantContentType: "application/json; charset=utf-8"},
+		{name: "accept csv", target: "/freetimes", accept: "text/csv", wantStatus: http.StatusOK, wantContentType: "text/csv; charset=utf-8"},
+		{name: "accept q-values", target: "/freetimes", accept: "text/csv;q=0.5, text/plain", wantStatus: http.StatusOK, wantContentType: "text/plain; charset=utf-8"},
+		{name: "accept wildcard", target: "/freetimes", accept: "text/html, */*;q=0.1", wantStatus: http.StatusOK, wantContentType: "application/json; charset=utf-8"},
+		{name: "not acceptable", target: "/freetimes", accept: "text/html", wantStatus: http.StatusNotAcceptable},
+		{name: "format wins over accept", target: "/freetimes?format=md", accept: "text/html", wantStatus: http.StatusOK, wantContentType: "text/markdown; charset=utf-8"},
+		{name: "unknown format", target: "/freetimes?format=xml", wantStatus: http.StatusBadRequest},
+		{name: "invalid duration", target: "/freetimes?duration=abc", wantStatus: http.StatusBadRequest},
+		{name: "method not allowed", method: http.MethodPost, target: "/freetimes", wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantContentType != "" {
+				if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+					t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultMeetingMinutes = 30 // 会議時間のデフォルト
+	DefaultStepMinutes    = 30 // 開始時刻の刻み幅のデフォルト
+)
+
+// businessHoursMask 営業時間(StartMinTimeHour〜EndMaxTimeHour)の枠だけ1にしたmask
+//
+// ex: 30分枠 & 08:00~20:00の場合
+// 右から17bit目(08:00~08:30)〜40bit目(19:30~20:00)が1になる。
+func businessHoursMask() uint64 {
+	startBit := uint(StartMinTimeHour * (60 / EventTimeFrameMinutes))
+	endBit := uint(EndMaxTimeHour * (60 / EventTimeFrameMinutes))
+	return (1<<endBit - 1) &^ (1<<startBit - 1)
+}
+
+// freeRunStartBits 空き枠(0)がslots個連続している箇所の開始bitのみ1にして返す。
+//
+// 空きを1に反転したfreeに対し、右シフトしたものとの論理積を取ると
+// 「自分と1つ上の枠が両方空いている」bitだけが残る。
+// シフト幅を倍々にしていくことで、slots個の連続もlog2(slots)回程度の演算で求められる。
+//
+// ex: slots = 3 (30分枠で90分の会議)
+// free               : 0111101110
+// free & free>>1     : 0011100110  (2枠連続の開始)
+// run2 & run2>>1     : 0001100010  (3枠連続の開始)
+func freeRunStartBits(busyBits, mask uint64, slots uint) uint64 {
+	if slots == 0 {
+		return 0
+	}
+	free := ^busyBits & mask
+	run := free
+	covered := uint(1)
+	for covered < slots {
+		shift := covered
+		if covered*2 > slots {
+			shift = slots - covered
+		}
+		run &= run >> shift
+		covered += shift
+	}
+	return run
+}
+
+// stepMask 営業開始の枠から刻み幅(stepSlots)ごとの開始位置だけ1にしたmask
+func stepMask(stepSlots uint) uint64 {
+	startBit := uint(StartMinTimeHour * (60 / EventTimeFrameMinutes))
+	var mask uint64
+	for i := startBit; i < 64; i += stepSlots {
+		mask |= 1 << i
+	}
+	return mask
+}
+
+// minutesToSlots 分を時間枠の個数に換算する。時間枠で割り切れない場合はエラー。
+func minutesToSlots(minutes int) (uint, error) {
+	if minutes <= 0 || minutes%EventTimeFrameMinutes != 0 {
+		return 0, fmt.Errorf("minutes must be a positive multiple of %d: %d", EventTimeFrameMinutes, minutes)
+	}
+	return uint(minutes / EventTimeFrameMinutes), nil
+}
+
+// freeTimesForDate 1日分のカレンダーごとのbitsから、meetingMinutesの会議が入れられる開始時刻の一覧を返す。
+// 開始時刻は営業開始からstepMinutes刻みで、会議の終了が営業終了を超えるものは含めない。
+// 誰か1人でも会議時間分空いていれば空き時間とし、空いているカレンダーIDを昇順で添える。
+func freeTimesForDate(date time.Time, calendars map[string]uint64, meetingMinutes, stepMinutes int, lang Locale) ([]FreeTime, error) {
+	meetingSlots, err := minutesToSlots(meetingMinutes)
+	if err != nil {
+		return nil, err
+	}
+	stepSlots, err := minutesToSlots(stepMinutes)
+	if err != nil {
+		return nil, err
+	}
+
+	// カレンダーごとに会議を開始できるbitを求め、論理和で集約する。
+	// 1人ずつ連続枠を判定するため、別々の人の空きをつなげた枠は空きにならない。
+	ids := sortedCalendarIds(calendars)
+	mask := businessHoursMask()
+	steps := stepMask(stepSlots)
+	calendarStarts := make([]uint64, len(ids))
+	var starts uint64
+	for n, id := range ids {
+		calendarStarts[n] = freeRunStartBits(calendars[id], mask, meetingSlots) & steps
+		starts |= calendarStarts[n]
+	}
+
+	freeTimes := make([]FreeTime, 0, EndMaxTimeHour*2) // 仮で30分枠で*2している。
+	for i := uint(0); i < 64; i++ {
+		if starts&(1<<i) == 0 {
+			continue
+		}
+		// 右からi番目のbitを時・分に換算する（convertToBitsの逆変換）
+		hour := i / (60 / EventTimeFrameMinutes)
+		minute := i % (60 / EventTimeFrameMinutes) * EventTimeFrameMinutes
+		freeTime := time.Date(date.Year(), date.Month(), date.Day(), int(hour), int(minute), 0, 0, time.Local)
+		freeIds := make([]string, 0, len(ids))
+		for n, id := range ids {
+			if calendarStarts[n]&(1<<i) != 0 {
+				freeIds = append(freeIds, id)
+			}
+		}
+		freeTimes = append(freeTimes, FreeTime{Value: freeTime.Format(time.RFC3339), Text: lang.Time(freeTime), CalendarIds: freeIds})
+	}
+	return freeTimes, nil
+}
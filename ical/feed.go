@@ -0,0 +1,247 @@
+package ical
+
+import (
+	"context"
+	"fmt"
+	"google-calendar-sample/schedule"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Calendar 読み込んだiCalendar（VCALENDAR）
+type Calendar struct {
+	Name  string // X-WR-CALNAME
+	root  *Component
+	zones map[string]zone
+}
+
+// ReadCalendar iCalendarを読み込み、VTIMEZONEを解釈する。
+func ReadCalendar(r io.Reader) (*Calendar, error) {
+	root, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	zs, err := zones(root)
+	if err != nil {
+		return nil, err
+	}
+	return &Calendar{Name: unescapeText(root.Value("X-WR-CALNAME")), root: root, zones: zs}, nil
+}
+
+// Events [from, to) にかかる予定をschedule.Eventに変換する。繰り返しの予定は期間内で展開する。
+//
+// Googleカレンダーの予定と同じく、終日の予定はlocの開始日の0時〜終了日の0時とする。
+// キャンセル（STATUS:CANCELLED）・予定なし（TRANSP:TRANSPARENT）の予定は空き時間に影響しないため含めない。
+// RECURRENCE-IDで変更された回は、元の回の代わりに変更後の予定を使う。
+func (c *Calendar) Events(calendarId string, from, to time.Time, loc *time.Location) ([]*schedule.Event, error) {
+	vevents := c.root.Children("VEVENT")
+
+	// key: UID -> 変更された回の元の開始時刻
+	overridden := make(map[string]map[int64]bool)
+	for _, v := range vevents {
+		p := v.Get("RECURRENCE-ID")
+		if p == nil {
+			continue
+		}
+		rid, err := parseDateTime(p.Value, p.Params, c.zones, loc)
+		if err != nil {
+			return nil, fmt.Errorf("RECURRENCE-ID: %w", err)
+		}
+		uid := v.Value("UID")
+		if _, ok := overridden[uid]; !ok {
+			overridden[uid] = make(map[int64]bool)
+		}
+		overridden[uid][rid.abs().Unix()] = true
+	}
+
+	events := make([]*schedule.Event, 0)
+	for _, v := range vevents {
+		if strings.EqualFold(v.Value("STATUS"), "CANCELLED") || strings.EqualFold(v.Value("TRANSP"), "TRANSPARENT") {
+			continue
+		}
+		occurrences, err := c.occurrences(v, overridden[v.Value("UID")], from, to, loc)
+		if err != nil {
+			return nil, fmt.Errorf("VEVENT %s: %w", v.Value("UID"), err)
+		}
+		for _, o := range occurrences {
+			events = append(events, &schedule.Event{
+				// 繰り返しの各回を区別するため、UIDに開始時刻を付ける（RECURRENCE-IDと同じ考え方）。
+				Id:            fmt.Sprintf("%s/%d", v.Value("UID"), o.start.Unix()),
+				CalendarId:    calendarId,
+				CalendarName:  c.Name,
+				Title:         unescapeText(v.Value("SUMMARY")),
+				IsAllDay:      o.isDate,
+				IsTentative:   strings.EqualFold(v.Value("STATUS"), "TENTATIVE"),
+				StartDateTime: o.start,
+				EndDateTime:   o.end,
+			})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].StartDateTime.Before(events[j].StartDateTime) })
+	return events, nil
+}
+
+type occurrence struct {
+	start, end time.Time
+	isDate     bool
+}
+
+// occurrences 1つのVEVENTの [from, to) にかかる回を返す。
+func (c *Calendar) occurrences(v *Component, overridden map[int64]bool, from, to time.Time, loc *time.Location) ([]occurrence, error) {
+	p := v.Get("DTSTART")
+	if p == nil {
+		return nil, fmt.Errorf("missing DTSTART")
+	}
+	start, err := parseDateTime(p.Value, p.Params, c.zones, loc)
+	if err != nil {
+		return nil, fmt.Errorf("DTSTART: %w", err)
+	}
+
+	// 終了は DTEND / DURATION / 省略（終日なら1日、それ以外は0）のいずれか
+	// 終日の予定は日数、それ以外は経過時間で各回の終了を求める。
+	days, duration := 0, time.Duration(0)
+	if start.isDate {
+		days = 1
+	}
+	if p := v.Get("DTEND"); p != nil {
+		end, err := parseDateTime(p.Value, p.Params, c.zones, loc)
+		if err != nil {
+			return nil, fmt.Errorf("DTEND: %w", err)
+		}
+		if start.isDate {
+			days = int(end.wall.Sub(start.wall).Hours() / 24)
+		} else {
+			duration = end.abs().Sub(start.abs())
+		}
+	} else if p := v.Get("DURATION"); p != nil {
+		if days, duration, err = parseDuration(p.Value); err != nil {
+			return nil, err
+		}
+		if !start.isDate {
+			// 日単位の期間も経過時間として扱う。
+			duration += time.Duration(days) * 24 * time.Hour
+			days = 0
+		}
+	}
+
+	// 各回の開始（壁時計の時刻と絶対時刻）
+	starts := []dateTime{start}
+	if rule := v.Value("RRULE"); rule != "" && v.Get("RECURRENCE-ID") == nil {
+		r, err := parseRRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		starts = starts[:0]
+		r.each(start.wall, start.zone.at, func(wall time.Time) bool {
+			if !start.zone.at(wall).Before(to) {
+				return false
+			}
+			starts = append(starts, dateTime{wall: wall, zone: start.zone, isDate: start.isDate})
+			return true
+		})
+	}
+	rdates, err := parseDateTimes(v.All("RDATE"), c.zones, loc)
+	if err != nil {
+		return nil, err
+	}
+	starts = append(starts, rdates...)
+	exdates, err := parseDateTimes(v.All("EXDATE"), c.zones, loc)
+	if err != nil {
+		return nil, err
+	}
+	excluded := make(map[int64]bool)
+	for _, d := range exdates {
+		excluded[d.abs().Unix()] = true
+	}
+
+	result := make([]occurrence, 0, len(starts))
+	seen := make(map[int64]bool)
+	for _, d := range starts {
+		s := d.abs()
+		key := s.Unix()
+		if excluded[key] || overridden[key] || seen[key] {
+			continue
+		}
+		seen[key] = true
+		e := s.Add(duration)
+		if start.isDate {
+			e = d.zone.at(d.wall.AddDate(0, 0, days))
+		}
+		if !e.After(s) || !e.After(from) || !s.Before(to) {
+			continue
+		}
+		result = append(result, occurrence{start: s.In(loc), end: e.In(loc), isDate: start.isDate})
+	}
+	return result, nil
+}
+
+// Feed Google以外のカレンダーの予定（.icsファイル・URL）
+type Feed struct {
+	Id     string // CalendarBitsで使うカレンダーID
+	Source string // ファイルのパス または http(s) / webcal のURL
+	Client *http.Client
+}
+
+// ParseFeed "id=source" または "source" を読み込む。idを省略した場合はsourceをidにする。
+//
+// ex:
+// staff@example.com=https://example.com/staff.ics
+// ./holidays.ics
+func ParseFeed(s string) *Feed {
+	if i := strings.Index(s, "="); i > 0 && !strings.ContainsAny(s[:i], "/:?") {
+		return &Feed{Id: s[:i], Source: s[i+1:]}
+	}
+	return &Feed{Id: s, Source: s}
+}
+
+// Open 予定を読み込むReaderを返す。
+func (f *Feed) Open(ctx context.Context) (io.ReadCloser, error) {
+	source := f.Source
+	if strings.HasPrefix(source, "webcal://") {
+		source = "https://" + strings.TrimPrefix(source, "webcal://")
+	}
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.Open(source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", source, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Events フィードを読み込み、[from, to) にかかる予定を返す。
+func (f *Feed) Events(ctx context.Context, from, to time.Time, loc *time.Location) ([]*schedule.Event, error) {
+	r, err := f.Open(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Id, err)
+	}
+	defer r.Close()
+	cal, err := ReadCalendar(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Id, err)
+	}
+	events, err := cal.Events(f.Id, from, to, loc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Id, err)
+	}
+	return events, nil
+}
@@ -0,0 +1,266 @@
+package ical
+
+import (
+	"context"
+	"google-calendar-sample/schedule"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sampleCalendar VTIMEZONE・繰り返し・EXDATE・RECURRENCE-IDを含むiCalendar
+// Custom EasternはIANAにないTZIDのため、VTIMEZONEの定義から時差を求める。
+var sampleCalendar = strings.Join([]string{
+	"BEGIN:VCALENDAR",
+	"VERSION:2.0",
+	"PRODID:-//test//EN",
+	`X-WR-CALNAME:Team\, Tokyo`,
+	"BEGIN:VTIMEZONE",
+	"TZID:Custom Eastern",
+	"BEGIN:STANDARD",
+	"DTSTART:20071104T020000",
+	"RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU",
+	"TZOFFSETFROM:-0400",
+	"TZOFFSETTO:-0500",
+	"TZNAME:EST",
+	"END:STANDARD",
+	"BEGIN:DAYLIGHT",
+	"DTSTART:20070311T020000",
+	"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU",
+	"TZOFFSETFROM:-0500",
+	"TZOFFSETTO:-0400",
+	"TZNAME:EDT",
+	"END:DAYLIGHT",
+	"END:VTIMEZONE",
+	// 毎週火・木 09:00-10:00（Custom Eastern）を8回。3/3は除外、3/8は3/9 14:00に移動
+	"BEGIN:VEVENT",
+	"UID:weekly@example.com",
+	"SUMMARY:Weekly sync",
+	"DTSTART;TZID=Custom Eastern:20220301T090000",
+	"DTEND;TZID=Custom Eastern:20220301T100000",
+	"RRULE:FREQ=WEEKLY;BYDAY=TU,TH;COUNT=8",
+	"EXDATE;TZID=Custom Eastern:20220303T090000",
+	"END:VEVENT",
+	"BEGIN:VEVENT",
+	"UID:weekly@example.com",
+	"RECURRENCE-ID;TZID=Custom Eastern:20220308T090000",
+	"SUMMARY:Weekly sync (moved)",
+	"STATUS:TENTATIVE",
+	"DTSTART;TZID=Custom Eastern:20220309T140000",
+	"DURATION:PT30M",
+	"END:VEVENT",
+	"BEGIN:VEVENT",
+	"UID:allday@example.com",
+	"SUMMARY:Offsite",
+	"DTSTART;VALUE=DATE:20220315",
+	"DTEND;VALUE=DATE:20220317",
+	"END:VEVENT",
+	"BEGIN:VEVENT",
+	"UID:cancelled@example.com",
+	"SUMMARY:Cancelled",
+	"STATUS:CANCELLED",
+	"DTSTART:20220302T000000Z",
+	"DTEND:20220302T010000Z",
+	"END:VEVENT",
+	"BEGIN:VEVENT",
+	"UID:transparent@example.com",
+	"SUMMARY:Reminder",
+	"TRANSP:TRANSPARENT",
+	"DTSTART:20220302T000000Z",
+	"DTEND:20220302T010000Z",
+	"END:VEVENT",
+	"END:VCALENDAR",
+	"",
+}, "\r\n")
+
+type wantEvent struct {
+	title      string
+	start, end string // Asia/Tokyo の RFC3339
+	allDay     bool
+	tentative  bool
+}
+
+var sampleWant = []wantEvent{
+	// 3/13の夏時間開始前は 09:00 EST = 23:00 JST
+	{title: "Weekly sync", start: "2022-03-01T23:00:00+09:00", end: "2022-03-02T00:00:00+09:00"},
+	{title: "Weekly sync (moved)", start: "2022-03-10T04:00:00+09:00", end: "2022-03-10T04:30:00+09:00", tentative: true},
+	{title: "Weekly sync", start: "2022-03-10T23:00:00+09:00", end: "2022-03-11T00:00:00+09:00"},
+	{title: "Offsite", start: "2022-03-15T00:00:00+09:00", end: "2022-03-17T00:00:00+09:00", allDay: true},
+	// 夏時間開始後は 09:00 EDT = 22:00 JST
+	{title: "Weekly sync", start: "2022-03-15T22:00:00+09:00", end: "2022-03-15T23:00:00+09:00"},
+	{title: "Weekly sync", start: "2022-03-17T22:00:00+09:00", end: "2022-03-17T23:00:00+09:00"},
+	{title: "Weekly sync", start: "2022-03-22T22:00:00+09:00", end: "2022-03-22T23:00:00+09:00"},
+	{title: "Weekly sync", start: "2022-03-24T22:00:00+09:00", end: "2022-03-24T23:00:00+09:00"},
+}
+
+func tokyo(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+	return loc
+}
+
+func checkEvents(t *testing.T, calendarId string, got []*schedule.Event, want []wantEvent) {
+	t.Helper()
+	if len(got) != len(want) {
+		for _, e := range got {
+			t.Logf("%s %s - %s", e.Title, e.StartDateTime.Format(time.RFC3339), e.EndDateTime.Format(time.RFC3339))
+		}
+		t.Fatalf("events = %d, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		e := got[i]
+		if e.CalendarId != calendarId || e.CalendarName != "Team, Tokyo" || e.Title != w.title ||
+			e.StartDateTime.Format(time.RFC3339) != w.start || e.EndDateTime.Format(time.RFC3339) != w.end ||
+			e.IsAllDay != w.allDay || e.IsTentative != w.tentative {
+			t.Errorf("event %d = {%s %s %q %s %s allDay:%v tentative:%v}, want %+v", i,
+				e.CalendarId, e.CalendarName, e.Title, e.StartDateTime.Format(time.RFC3339), e.EndDateTime.Format(time.RFC3339),
+				e.IsAllDay, e.IsTentative, w)
+		}
+	}
+}
+
+func TestCalendarEvents(t *testing.T) {
+	loc := tokyo(t)
+	cal, err := ReadCalendar(strings.NewReader(sampleCalendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2022, 3, 1, 0, 0, 0, 0, loc)
+	events, err := cal.Events("team@example.com", from, from.AddDate(0, 1, 0), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, "team@example.com", events, sampleWant)
+
+	// 期間にかかる回だけを返す。
+	events, err = cal.Events("team@example.com", time.Date(2022, 3, 15, 12, 0, 0, 0, loc), time.Date(2022, 3, 17, 22, 0, 0, 0, loc), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, "team@example.com", events, sampleWant[3:5])
+}
+
+func TestVTimezoneOffsets(t *testing.T) {
+	cal, err := ReadCalendar(strings.NewReader(sampleCalendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := cal.zones["Custom Eastern"]
+	if z == nil {
+		t.Fatal("Custom Eastern not found")
+	}
+	tests := []struct {
+		wall string
+		want string
+	}{
+		{"20070101T120000", "2007-01-01T12:00:00-05:00"},
+		{"20220313T015959", "2022-03-13T01:59:59-05:00"},
+		{"20220313T030000", "2022-03-13T03:00:00-04:00"},
+		{"20220701T120000", "2022-07-01T12:00:00-04:00"},
+		{"20221106T003000", "2022-11-06T00:30:00-04:00"},
+		{"20221106T030000", "2022-11-06T03:00:00-05:00"},
+		// 最初の切り替えより前は切り替え前の時差
+		{"20000101T000000", "2000-01-01T00:00:00-05:00"},
+	}
+	for _, tt := range tests {
+		wall, err := time.Parse(formatLocal, tt.wall)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := z.at(wall).Format(time.RFC3339); got != tt.want {
+			t.Errorf("at(%s) = %s, want %s", tt.wall, got, tt.want)
+		}
+	}
+}
+
+func TestParseUTCOffset(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int
+		wantErr bool
+	}{
+		{s: "+0900", want: 9 * 3600},
+		{s: "-0430", want: -(4*3600 + 30*60)},
+		{s: "+053730", want: 5*3600 + 37*60 + 30},
+		{s: "0900", wantErr: true},
+		{s: "+9", wantErr: true},
+		{s: "+09ab", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseUTCOffset(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseUTCOffset(%q) = %d, %v", tt.s, got, err)
+		}
+	}
+}
+
+func TestFeedEvents(t *testing.T) {
+	loc := tokyo(t)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/team.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("Accept"); got != "text/calendar" {
+			t.Errorf("Accept = %q", got)
+		}
+		w.Header().Set("Content-Type", ContentType)
+		w.Write([]byte(sampleCalendar))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	from := time.Date(2022, 3, 1, 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 1, 0)
+
+	for _, source := range []string{
+		"team@example.com=" + srv.URL + "/team.ics",
+		"team@example.com=" + strings.Replace(srv.URL, "https://", "webcal://", 1) + "/team.ics",
+	} {
+		feed := ParseFeed(source)
+		feed.Client = srv.Client()
+		events, err := feed.Events(ctx, from, to, loc)
+		if err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		checkEvents(t, "team@example.com", events, sampleWant)
+	}
+
+	feed := ParseFeed(srv.URL + "/missing.ics")
+	feed.Client = srv.Client()
+	if _, err := feed.Events(ctx, from, to, loc); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing feed: err = %v", err)
+	}
+
+	// ファイルのパスも読み込める。idを省略した場合はパスがidになる。
+	path := filepath.Join(t.TempDir(), "team.ics")
+	if err := os.WriteFile(path, []byte(sampleCalendar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	events, err := ParseFeed(path).Events(ctx, from, to, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, path, events, sampleWant)
+}
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct{ s, id, source string }{
+		{"staff@example.com=https://example.com/staff.ics", "staff@example.com", "https://example.com/staff.ics"},
+		{"./holidays.ics", "./holidays.ics", "./holidays.ics"},
+		{"https://example.com/cal.ics?key=abc", "https://example.com/cal.ics?key=abc", "https://example.com/cal.ics?key=abc"},
+	}
+	for _, tt := range tests {
+		f := ParseFeed(tt.s)
+		if f.Id != tt.id || f.Source != tt.source {
+			t.Errorf("ParseFeed(%q) = {%q %q}, want {%q %q}", tt.s, f.Id, f.Source, tt.id, tt.source)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Package ical iCalendar（RFC 5545）の読み書きを行う。
+package ical
+
+import (
+	"fmt"
+	"google-calendar-sample/schedule"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ProdID 出力するiCalendarのPRODID
+	ProdID = "-//google-calendar-sample//freebusy//JA"
+	// ContentType iCalendarのMIMEタイプ
+	ContentType = "text/calendar; charset=utf-8"
+
+	formatUTC = "20060102T150405Z"
+)
+
+// FBType FREEBUSYのFBTYPE
+type FBType string
+
+const (
+	FBTypeBusy          FBType = "BUSY"
+	FBTypeBusyTentative FBType = "BUSY-TENTATIVE"
+)
+
+// Period 予定ありの期間
+type Period struct {
+	schedule.Interval
+	Type FBType
+}
+
+// FreeBusy 1つのVFREEBUSYコンポーネント
+type FreeBusy struct {
+	UID       string
+	Organizer string // カレンダーID（メールアドレス）。集約したものは空
+	Comment   string
+	Start     time.Time
+	End       time.Time
+	Busy      []Period
+}
+
+// Aggregate 全員が予定ありの期間（誰も空いていない期間）を1つのVFREEBUSYにまとめる。
+//
+// getevents と同じく「誰か1人でも空いていれば空き」とするため、各カレンダーの予定ありの期間の積集合を取る。
+// いずれかが仮の予定（BUSY-TENTATIVE）であれば、その期間はBUSY-TENTATIVEとする。
+func Aggregate(uid string, calendars []FreeBusy, start, end time.Time) FreeBusy {
+	aggregated := FreeBusy{UID: uid, Comment: fmt.Sprintf("aggregated availability of %d calendars", len(calendars)), Start: start, End: end}
+	if len(calendars) == 0 {
+		return aggregated
+	}
+
+	all := schedule.Intervals{{Start: start, End: end}}
+	tentative := make(schedule.Intervals, 0)
+	for _, c := range calendars {
+		busy := make(schedule.Intervals, 0, len(c.Busy))
+		for _, p := range c.Busy {
+			busy = append(busy, p.Interval)
+			if p.Type == FBTypeBusyTentative {
+				tentative = append(tentative, p.Interval)
+			}
+		}
+		all = schedule.Intersect(all, busy)
+	}
+
+	tentativeInAll := schedule.Intersect(all, tentative)
+	for _, i := range schedule.Subtract(all, tentativeInAll) {
+		aggregated.Busy = append(aggregated.Busy, Period{Interval: i, Type: FBTypeBusy})
+	}
+	for _, i := range tentativeInAll {
+		aggregated.Busy = append(aggregated.Busy, Period{Interval: i, Type: FBTypeBusyTentative})
+	}
+	sortPeriods(aggregated.Busy)
+	return aggregated
+}
+
+// WriteFreeBusy VCALENDARにVFREEBUSYを並べて出力する。
+//
+// ex:
+//
+//	BEGIN:VCALENDAR
+//	VERSION:2.0
+//	PRODID:-//google-calendar-sample//freebusy//JA
+//	METHOD:PUBLISH
+//	BEGIN:VFREEBUSY
+//	UID:example@gmail.com
+//	DTSTAMP:20220418T000000Z
+//	ORGANIZER:mailto:example@gmail.com
+//	DTSTART:20220418T000000Z
+//	DTEND:20220502T000000Z
+//	FREEBUSY;FBTYPE=BUSY:20220418T010000Z/20220418T023000Z
+//	END:VFREEBUSY
+//	END:VCALENDAR
+func WriteFreeBusy(w io.Writer, components []FreeBusy, now time.Time) error {
+	lw := &lineWriter{w: w}
+	lw.line("BEGIN:VCALENDAR")
+	lw.line("VERSION:2.0")
+	lw.line("PRODID:" + ProdID)
+	lw.line("METHOD:PUBLISH")
+	for _, c := range components {
+		lw.line("BEGIN:VFREEBUSY")
+		lw.line("UID:" + escapeText(c.UID))
+		lw.line("DTSTAMP:" + now.UTC().Format(formatUTC))
+		if c.Organizer != "" {
+			lw.line("ORGANIZER:mailto:" + c.Organizer)
+		}
+		if c.Comment != "" {
+			lw.line("COMMENT:" + escapeText(c.Comment))
+		}
+		lw.line("DTSTART:" + c.Start.UTC().Format(formatUTC))
+		lw.line("DTEND:" + c.End.UTC().Format(formatUTC))
+		periods := append([]Period{}, c.Busy...)
+		sortPeriods(periods)
+		for _, p := range periods {
+			fbType := p.Type
+			if fbType == "" {
+				fbType = FBTypeBusy
+			}
+			lw.line(fmt.Sprintf("FREEBUSY;FBTYPE=%s:%s/%s", fbType, p.Start.UTC().Format(formatUTC), p.End.UTC().Format(formatUTC)))
+		}
+		lw.line("END:VFREEBUSY")
+	}
+	lw.line("END:VCALENDAR")
+	return lw.err
+}
+
+func sortPeriods(periods []Period) {
+	sort.SliceStable(periods, func(i, j int) bool { return periods[i].Start.Before(periods[j].Start) })
+}
+
+// escapeText TEXT型の値をエスケープする。 see: RFC 5545 3.3.11
+func escapeText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// lineWriter CRLFで改行し、75オクテットを超える行を折り返す。 see: RFC 5545 3.1
+type lineWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (lw *lineWriter) line(s string) {
+	if lw.err != nil {
+		return
+	}
+	const limit = 75
+	var b strings.Builder
+	width := 0
+	for _, r := range s {
+		n := len(string(r))
+		if width+n > limit {
+			// 折り返した行は先頭の空白1文字も含めて75オクテットに収める。
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += n
+	}
+	b.WriteString("\r\n")
+	_, lw.err = io.WriteString(lw.w, b.String())
+}
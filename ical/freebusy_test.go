@@ -0,0 +1,182 @@
+package ical
+
+import (
+	"bytes"
+	"google-calendar-sample/schedule"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2022, 4, 18, hour, minute, 0, 0, time.UTC)
+}
+
+func period(startHour, startMinute, endHour, endMinute int, fbType FBType) Period {
+	return Period{Interval: schedule.Interval{Start: at(startHour, startMinute), End: at(endHour, endMinute)}, Type: fbType}
+}
+
+func TestWriteFreeBusy(t *testing.T) {
+	now := at(0, 0)
+	components := []FreeBusy{{
+		UID:       "a@example.com",
+		Organizer: "a@example.com",
+		Comment:   "team, sales; tokyo",
+		Start:     at(0, 0),
+		End:       at(0, 0).AddDate(0, 0, 1),
+		// 開始時刻の順に並べ、FBTYPEがないものはBUSYにする。
+		Busy: []Period{period(10, 0, 11, 0, FBTypeBusyTentative), period(1, 0, 2, 30, "")},
+	}}
+	var buf bytes.Buffer
+	if err := WriteFreeBusy(&buf, components, now); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:" + ProdID,
+		"METHOD:PUBLISH",
+		"BEGIN:VFREEBUSY",
+		"UID:a@example.com",
+		"DTSTAMP:20220418T000000Z",
+		"ORGANIZER:mailto:a@example.com",
+		`COMMENT:team\, sales\; tokyo`,
+		"DTSTART:20220418T000000Z",
+		"DTEND:20220419T000000Z",
+		"FREEBUSY;FBTYPE=BUSY:20220418T010000Z/20220418T023000Z",
+		"FREEBUSY;FBTYPE=BUSY-TENTATIVE:20220418T100000Z/20220418T110000Z",
+		"END:VFREEBUSY",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	// 入力の並びは変更しない。
+	if components[0].Busy[0].Type != FBTypeBusyTentative {
+		t.Error("WriteFreeBusy sorted the input periods")
+	}
+}
+
+func TestLineWriterFolding(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"short", "SUMMARY:short", "SUMMARY:short\r\n"},
+		{"exactly 75", strings.Repeat("a", 75), strings.Repeat("a", 75) + "\r\n"},
+		{"76", strings.Repeat("a", 76), strings.Repeat("a", 75) + "\r\n a\r\n"},
+		{
+			// 2行目以降は先頭の空白を含めて75オクテット
+			"multiple folds",
+			strings.Repeat("a", 75+74+1),
+			strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 74) + "\r\n a\r\n",
+		},
+		{
+			// マルチバイト文字（3オクテット）の途中では折り返さない。
+			"multibyte",
+			strings.Repeat("a", 73) + "空き",
+			strings.Repeat("a", 73) + "\r\n 空き\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			lw := &lineWriter{w: &buf}
+			lw.line(tt.line)
+			if lw.err != nil {
+				t.Fatal(lw.err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			lines, err := unfold(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lines) != 1 || lines[0] != tt.line {
+				t.Errorf("unfold = %q, want %q", lines, tt.line)
+			}
+		})
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	start, end := at(0, 0), at(0, 0).AddDate(0, 0, 1)
+	calendars := []FreeBusy{
+		{UID: "a", Busy: []Period{period(9, 0, 12, 0, FBTypeBusy), period(14, 0, 16, 0, FBTypeBusyTentative)}},
+		{UID: "b", Busy: []Period{period(10, 0, 11, 0, FBTypeBusy), period(11, 30, 15, 0, FBTypeBusy)}},
+	}
+	got := Aggregate("agg", calendars, start, end)
+	want := []Period{
+		period(10, 0, 11, 0, FBTypeBusy),
+		period(11, 30, 12, 0, FBTypeBusy),
+		// aが仮の予定の期間はBUSY-TENTATIVE
+		period(14, 0, 15, 0, FBTypeBusyTentative),
+	}
+	if !reflect.DeepEqual(got.Busy, want) {
+		t.Errorf("Busy = %v, want %v", got.Busy, want)
+	}
+	if got.UID != "agg" || got.Organizer != "" || !got.Start.Equal(start) || !got.End.Equal(end) {
+		t.Errorf("Aggregate = %+v", got)
+	}
+	if got := Aggregate("agg", nil, start, end); len(got.Busy) != 0 {
+		t.Errorf("Aggregate of no calendars = %v", got.Busy)
+	}
+	// 予定がまったくないカレンダーがあれば、集約は空き
+	if got := Aggregate("agg", append(calendars, FreeBusy{UID: "c"}), start, end); len(got.Busy) != 0 {
+		t.Errorf("Aggregate with free calendar = %v", got.Busy)
+	}
+}
+
+func TestWithAggregate(t *testing.T) {
+	start, end := at(0, 0), at(0, 0).AddDate(0, 0, 1)
+	components := []FreeBusy{
+		{UID: "a", Organizer: "a", Busy: []Period{period(9, 0, 10, 0, FBTypeBusy)}},
+		{UID: "b", Organizer: "b", Busy: []Period{period(9, 30, 11, 0, FBTypeBusy)}},
+	}
+	got := WithAggregate(components, start, end)
+	if len(got) != 3 {
+		t.Fatalf("len = %d, want 3", len(got))
+	}
+	if got[0].UID != AggregatedUID || !reflect.DeepEqual(got[0].Busy, []Period{period(9, 30, 10, 0, FBTypeBusy)}) {
+		t.Errorf("aggregated = %+v", got[0])
+	}
+	if got[1].UID != "a" || got[2].UID != "b" {
+		t.Errorf("components = %v, %v", got[1].UID, got[2].UID)
+	}
+	if got[0].Comment != "aggregated availability of 2 calendars" {
+		t.Errorf("Comment = %q", got[0].Comment)
+	}
+}
+
+func TestFromEvents(t *testing.T) {
+	start, end := at(0, 0), at(0, 0).AddDate(0, 0, 1)
+	events := []*schedule.Event{
+		{CalendarId: "a", StartDateTime: at(9, 0), EndDateTime: at(10, 0)},
+		// 確定の予定と重なる仮の予定はBUSYを優先する。
+		{CalendarId: "a", IsTentative: true, StartDateTime: at(9, 30), EndDateTime: at(11, 0)},
+		// 期間外は切り詰める。
+		{CalendarId: "b", StartDateTime: at(22, 0), EndDateTime: at(26, 0)},
+		{CalendarId: "unknown", StartDateTime: at(9, 0), EndDateTime: at(10, 0)},
+	}
+	got := FromEvents([]string{"a", "b", "c"}, events, start, end)
+	want := map[string][]Period{
+		"a": {period(9, 0, 10, 0, FBTypeBusy), period(10, 0, 11, 0, FBTypeBusyTentative)},
+		"b": {period(22, 0, 24, 0, FBTypeBusy)},
+		"c": nil,
+	}
+	if len(got) != 3 {
+		t.Fatalf("len = %d, want 3", len(got))
+	}
+	for _, c := range got {
+		if !reflect.DeepEqual(c.Busy, want[c.UID]) {
+			t.Errorf("%s: Busy = %v, want %v", c.UID, c.Busy, want[c.UID])
+		}
+		if c.Organizer != c.UID {
+			t.Errorf("%s: Organizer = %q", c.UID, c.Organizer)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package ical
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Source リクエストごとに最新の空き状況（カレンダーごとのVFREEBUSY）を取得する。
+type Source func(ctx context.Context, start, end time.Time) ([]FreeBusy, error)
+
+// Handler 空き状況をiCalendar（VFREEBUSY）で返すHTTPハンドラー
+//
+// クエリパラメータ:
+// calendar: 対象のカレンダーID（複数指定可）。省略時はすべて
+// aggregate: "false" の場合は集約したVFREEBUSYを含めない
+//
+// ex: GET /freebusy.ics?calendar=example@gmail.com
+type Handler struct {
+	Source Source
+	Days   int // 取得する期間（日数）
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	now := time.Now()
+	start, end := now, now.AddDate(0, 0, h.Days)
+	components, err := h.Source(r.Context(), start, end)
+	if err != nil {
+		log.Printf("freebusy.ics: %v", err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	if ids := r.URL.Query()["calendar"]; len(ids) > 0 {
+		want := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			want[id] = true
+		}
+		filtered := make([]FreeBusy, 0, len(ids))
+		for _, c := range components {
+			if want[c.UID] {
+				filtered = append(filtered, c)
+			}
+		}
+		if len(filtered) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		components = filtered
+	}
+	if r.URL.Query().Get("aggregate") != "false" {
+		components = WithAggregate(components, start, end)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteFreeBusy(&buf, components, now); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,93 @@
+package ical
+
+import (
+	"context"
+	"errors"
+	"google-calendar-sample/schedule"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	source := func(_ context.Context, start, end time.Time) ([]FreeBusy, error) {
+		if got := end.Sub(start); got != 14*24*time.Hour {
+			t.Errorf("period = %v, want 14 days", got)
+		}
+		busy := []Period{{Interval: schedule.Interval{Start: start.Add(time.Hour), End: start.Add(2 * time.Hour)}, Type: FBTypeBusyTentative}}
+		return []FreeBusy{
+			{UID: "a@example.com", Organizer: "a@example.com", Start: start, End: end, Busy: busy},
+			{UID: "b@example.com", Organizer: "b@example.com", Start: start, End: end},
+		}, nil
+	}
+	h := &Handler{Source: source, Days: 14}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		wantUIDs   []string
+	}{
+		{name: "all", target: "/freebusy.ics", wantStatus: http.StatusOK, wantUIDs: []string{AggregatedUID, "a@example.com", "b@example.com"}},
+		{name: "calendar", target: "/freebusy.ics?calendar=b@example.com", wantStatus: http.StatusOK, wantUIDs: []string{AggregatedUID, "b@example.com"}},
+		{name: "without aggregate", target: "/freebusy.ics?calendar=a@example.com&aggregate=false", wantStatus: http.StatusOK, wantUIDs: []string{"a@example.com"}},
+		{name: "unknown calendar", target: "/freebusy.ics?calendar=c@example.com", wantStatus: http.StatusNotFound},
+		{name: "HEAD", method: http.MethodHead, target: "/freebusy.ics", wantStatus: http.StatusOK},
+		{name: "POST", method: http.MethodPost, target: "/freebusy.ics", wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(method, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != ContentType {
+				t.Errorf("Content-Type = %q", got)
+			}
+			if method == http.MethodHead {
+				if rec.Body.Len() != 0 {
+					t.Errorf("HEAD returned a body")
+				}
+				return
+			}
+			cal, err := Parse(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			uids := make([]string, 0)
+			for _, c := range cal.Children("VFREEBUSY") {
+				uids = append(uids, c.Value("UID"))
+				if c.Value("UID") == "a@example.com" {
+					if fb := c.Get("FREEBUSY"); fb == nil || fb.Params["FBTYPE"] != string(FBTypeBusyTentative) {
+						t.Errorf("FREEBUSY = %+v, want BUSY-TENTATIVE", fb)
+					}
+				}
+			}
+			if strings.Join(uids, ",") != strings.Join(tt.wantUIDs, ",") {
+				t.Errorf("UIDs = %v, want %v", uids, tt.wantUIDs)
+			}
+		})
+	}
+}
+
+func TestHandlerSourceError(t *testing.T) {
+	h := &Handler{Days: 1, Source: func(context.Context, time.Time, time.Time) ([]FreeBusy, error) {
+		return nil, errors.New("backend error")
+	}}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/freebusy.ics", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,173 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Property コンテンツ行（名前・パラメーター・値）
+//
+// ex: DTSTART;TZID=Asia/Tokyo:20220418T100000
+// -> Name: "DTSTART", Params: {"TZID": "Asia/Tokyo"}, Value: "20220418T100000"
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Component BEGIN〜ENDで囲まれたコンポーネント（VCALENDAR / VEVENT / VTIMEZONE など）
+type Component struct {
+	Name       string
+	Properties []*Property
+	Components []*Component
+}
+
+// Get 名前が一致する最初のプロパティを返す。なければnil
+func (c *Component) Get(name string) *Property {
+	for _, p := range c.Properties {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Value 名前が一致する最初のプロパティの値を返す。なければ空文字
+func (c *Component) Value(name string) string {
+	if p := c.Get(name); p != nil {
+		return p.Value
+	}
+	return ""
+}
+
+// All 名前が一致するプロパティをすべて返す。
+func (c *Component) All(name string) []*Property {
+	props := make([]*Property, 0)
+	for _, p := range c.Properties {
+		if p.Name == name {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// Children 名前が一致する子コンポーネントを返す。
+func (c *Component) Children(name string) []*Component {
+	children := make([]*Component, 0)
+	for _, child := range c.Components {
+		if child.Name == name {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// Parse iCalendarを読み込み、最初のVCALENDARを返す。
+func Parse(r io.Reader) (*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var root *Component
+	stack := make([]*Component, 0)
+	for n, line := range lines {
+		p, err := parseProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		switch p.Name {
+		case "BEGIN":
+			c := &Component{Name: strings.ToUpper(p.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, c)
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(p.Value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", n+1, p.Value)
+			}
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 && c.Name == "VCALENDAR" {
+				root = c
+			}
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: property %s outside of component", n+1, p.Name)
+			}
+			c := stack[len(stack)-1]
+			c.Properties = append(c.Properties, p)
+		}
+		if root != nil {
+			break
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("VCALENDAR not found")
+	}
+	return root, nil
+}
+
+// unfold 折り返された行（先頭が空白・タブ）を前の行につなげる。 see: RFC 5545 3.1
+func unfold(r io.Reader) ([]string, error) {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseProperty 1行をプロパティに分解する。パラメーターの値はダブルクォートで囲まれていることがある。
+func parseProperty(line string) (*Property, error) {
+	p := &Property{Params: make(map[string]string)}
+	quoted := false
+	start := 0
+	var key string
+	for i := 0; i < len(line); i++ {
+		switch ch := line[i]; {
+		case ch == '"':
+			quoted = !quoted
+		case quoted:
+		case ch == ';' || ch == ':':
+			field := line[start:i]
+			if p.Name == "" {
+				p.Name = strings.ToUpper(field)
+			} else if key != "" {
+				p.Params[key] = strings.Trim(field, `"`)
+			}
+			key = ""
+			start = i + 1
+			if ch == ':' {
+				if p.Name == "" {
+					return nil, fmt.Errorf("missing property name: %q", line)
+				}
+				p.Value = line[i+1:]
+				return p, nil
+			}
+		case ch == '=' && p.Name != "" && key == "":
+			key = strings.ToUpper(line[start:i])
+			start = i + 1
+		}
+	}
+	return nil, fmt.Errorf("missing ':' in %q", line)
+}
+
+// unescapeText TEXT型の値のエスケープを戻す。escapeTextの逆変換
+func unescapeText(s string) string {
+	r := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return r.Replace(s)
+}
@@ -0,0 +1,128 @@
+package ical
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnfold(t *testing.T) {
+	input := "BEGIN:VCALENDAR\r\n" +
+		"DESCRIPTION:This is a lo\r\n" +
+		" ng description\r\n" +
+		"\tthat exists on a long line.\r\n" +
+		"\r\n" +
+		"SUMMARY:LF only\n" +
+		" folded\n" +
+		"END:VCALENDAR\r\n"
+	got, err := unfold(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"BEGIN:VCALENDAR",
+		"DESCRIPTION:This is a long descriptionthat exists on a long line.",
+		"SUMMARY:LF onlyfolded",
+		"END:VCALENDAR",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unfold = %q, want %q", got, want)
+	}
+}
+
+func TestParseProperty(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    *Property
+		wantErr bool
+	}{
+		{
+			line: "dtstart;tzid=Asia/Tokyo:20220418T090000",
+			want: &Property{Name: "DTSTART", Params: map[string]string{"TZID": "Asia/Tokyo"}, Value: "20220418T090000"},
+		},
+		{
+			// クォートされたパラメーターの値には ; : を含められる。値の : はそのまま残す。
+			line: `ATTENDEE;CN="Doe; John";DELEGATED-FROM="mailto:a@example.com":mailto:john@example.com`,
+			want: &Property{
+				Name:   "ATTENDEE",
+				Params: map[string]string{"CN": "Doe; John", "DELEGATED-FROM": "mailto:a@example.com"},
+				Value:  "mailto:john@example.com",
+			},
+		},
+		{line: "SUMMARY:", want: &Property{Name: "SUMMARY", Params: map[string]string{}, Value: ""}},
+		{line: "SUMMARY", wantErr: true},
+		{line: ":value", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseProperty(tt.line)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseProperty(%q) returned no error", tt.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseProperty(%q): %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseProperty(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct{ text, escaped string }{
+		{"plain", "plain"},
+		{"a,b;c", `a\,b\;c`},
+		{`C:\path`, `C:\\path`},
+		{"line1\nline2", `line1\nline2`},
+		{`\n is not a newline`, `\\n is not a newline`},
+	}
+	for _, tt := range tests {
+		if got := escapeText(tt.text); got != tt.escaped {
+			t.Errorf("escapeText(%q) = %q, want %q", tt.text, got, tt.escaped)
+		}
+		if got := unescapeText(tt.escaped); got != tt.text {
+			t.Errorf("unescapeText(%q) = %q, want %q", tt.escaped, got, tt.text)
+		}
+	}
+	if got := unescapeText(`upper\Ncase`); got != "upper\ncase" {
+		t.Errorf(`unescapeText(\N) = %q`, got)
+	}
+}
+
+// TestWriteFreeBusyRoundTrip 書き出した行が75オクテット以内に折り返され、Parseで元に戻ることを確認する。
+func TestWriteFreeBusyRoundTrip(t *testing.T) {
+	comment := strings.Repeat("空き時間の確認用コメント, ", 10) + "end; done"
+	start := time.Date(2022, 4, 18, 0, 0, 0, 0, time.UTC)
+	fb := FreeBusy{
+		UID:     "a@example.com",
+		Comment: comment,
+		Start:   start,
+		End:     start.AddDate(0, 0, 1),
+	}
+	var buf bytes.Buffer
+	if err := WriteFreeBusy(&buf, []FreeBusy{fb}, start); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets (%d): %q", len(line), line)
+		}
+	}
+
+	cal, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vfreebusy := cal.Children("VFREEBUSY")
+	if len(vfreebusy) != 1 {
+		t.Fatalf("VFREEBUSY = %d, want 1", len(vfreebusy))
+	}
+	if got := unescapeText(vfreebusy[0].Value("COMMENT")); got != comment {
+		t.Errorf("COMMENT = %q, want %q", got, comment)
+	}
+}
@@ -0,0 +1,312 @@
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRRulePeriods 繰り返しを展開する期間（日・週・月・年）の上限
+// 条件に一致する日がない繰り返し（ex: 2月30日）で無限に展開しないようにする。
+const maxRRulePeriods = 100000
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// weekdayNum BYDAYの1項目 ex: 2SU -> 第2日曜, -1FR -> 最終金曜, MO -> 毎週月曜
+type weekdayNum struct {
+	n       int
+	weekday time.Weekday
+}
+
+// rrule 繰り返しルール see: RFC 5545 3.3.10
+//
+// FREQ（DAILY / WEEKLY / MONTHLY / YEARLY）, INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY, BYMONTH, WKST に対応する。
+// 時刻は常にDTSTARTの時刻で、BYHOUR / BYSETPOS などには対応しない。
+type rrule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time // UTCの絶対時刻
+	untilWall  time.Time // 日付・浮動時刻の場合の壁時計の時刻
+	byDay      []weekdayNum
+	byMonthDay []int
+	byMonth    []time.Month
+	wkst       time.Weekday
+}
+
+// parseRRule ex: FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;UNTIL=20220630T150000Z
+func parseRRule(s string) (*rrule, error) {
+	r := &rrule{interval: 1, wkst: time.Monday}
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid RRULE: %q", s)
+		}
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		var err error
+		switch key {
+		case "FREQ":
+			r.freq = value
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(value)
+			if err == nil && r.interval < 1 {
+				err = fmt.Errorf("INTERVAL must be positive")
+			}
+		case "COUNT":
+			r.count, err = strconv.Atoi(value)
+		case "UNTIL":
+			switch {
+			case strings.HasSuffix(value, "Z"):
+				r.until, err = time.Parse(formatLocal, strings.TrimSuffix(value, "Z"))
+			case len(value) == len(formatDate):
+				// 日付の場合はその日を含む。
+				r.untilWall, err = time.Parse(formatDate, value)
+				r.untilWall = r.untilWall.Add(24*time.Hour - time.Second)
+			default:
+				r.untilWall, err = time.Parse(formatLocal, value)
+			}
+		case "BYDAY":
+			for _, v := range strings.Split(value, ",") {
+				if len(v) < 2 {
+					return nil, fmt.Errorf("invalid BYDAY: %q", value)
+				}
+				wd, ok := weekdays[v[len(v)-2:]]
+				if !ok {
+					return nil, fmt.Errorf("invalid BYDAY: %q", value)
+				}
+				n := 0
+				if v[:len(v)-2] != "" {
+					if n, err = strconv.Atoi(v[:len(v)-2]); err != nil {
+						return nil, fmt.Errorf("invalid BYDAY: %q", value)
+					}
+				}
+				r.byDay = append(r.byDay, weekdayNum{n: n, weekday: wd})
+			}
+		case "BYMONTHDAY":
+			for _, v := range strings.Split(value, ",") {
+				d, convErr := strconv.Atoi(v)
+				if convErr != nil || d == 0 || d < -31 || d > 31 {
+					return nil, fmt.Errorf("invalid BYMONTHDAY: %q", value)
+				}
+				r.byMonthDay = append(r.byMonthDay, d)
+			}
+		case "BYMONTH":
+			for _, v := range strings.Split(value, ",") {
+				m, convErr := strconv.Atoi(v)
+				if convErr != nil || m < 1 || m > 12 {
+					return nil, fmt.Errorf("invalid BYMONTH: %q", value)
+				}
+				r.byMonth = append(r.byMonth, time.Month(m))
+			}
+		case "WKST":
+			wd, ok := weekdays[value]
+			if !ok {
+				return nil, fmt.Errorf("invalid WKST: %q", value)
+			}
+			r.wkst = wd
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE %s: %w", key, err)
+		}
+	}
+	switch r.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, fmt.Errorf("unsupported RRULE FREQ: %q", r.freq)
+	}
+	return r, nil
+}
+
+// each dtstart（壁時計の時刻）から繰り返しを順に展開し、fnを呼ぶ。fnがfalseを返すと終了する。
+// toAbsは壁時計の時刻を絶対時刻に換算するもので、UTCで指定されたUNTILの判定に使う。
+func (r *rrule) each(dtstart time.Time, toAbs func(time.Time) time.Time, fn func(time.Time) bool) {
+	emitted := 0
+	for period := 0; period < maxRRulePeriods; period++ {
+		for _, t := range r.candidates(dtstart, period*r.interval) {
+			if t.Before(dtstart) {
+				continue
+			}
+			if !r.until.IsZero() && toAbs(t).After(r.until) {
+				return
+			}
+			if !r.untilWall.IsZero() && t.After(r.untilWall) {
+				return
+			}
+			if r.count > 0 && emitted >= r.count {
+				return
+			}
+			emitted++
+			if !fn(t) {
+				return
+			}
+		}
+	}
+}
+
+// candidates dtstartからstep期間後の期間内で、条件に一致する日時を昇順で返す。
+func (r *rrule) candidates(dtstart time.Time, step int) []time.Time {
+	y, m, d := dtstart.Date()
+	hh, mm, ss := dtstart.Clock()
+	date := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, hh, mm, ss, 0, time.UTC) }
+
+	result := make([]time.Time, 0)
+	switch r.freq {
+	case "DAILY":
+		t := date(y, m, d+step)
+		if r.matchMonth(t.Month()) && r.matchMonthDay(t) && r.matchWeekday(t.Weekday()) {
+			result = append(result, t)
+		}
+	case "WEEKLY":
+		weekStart := d - (int(dtstart.Weekday())-int(r.wkst)+7)%7 + 7*step
+		days := r.byDay
+		if len(days) == 0 {
+			days = []weekdayNum{{weekday: dtstart.Weekday()}}
+		}
+		for _, wd := range days {
+			t := date(y, m, weekStart+(int(wd.weekday)-int(r.wkst)+7)%7)
+			if r.matchMonth(t.Month()) {
+				result = append(result, t)
+			}
+		}
+	case "MONTHLY":
+		first := date(y, m+time.Month(step), 1)
+		if r.matchMonth(first.Month()) {
+			result = append(result, r.monthDays(first, d)...)
+		}
+	case "YEARLY":
+		year := y + step
+		switch {
+		case len(r.byMonth) == 0 && len(r.byMonthDay) == 0 && len(r.byDay) > 0:
+			// BYMONTHなしのBYDAYは年単位の第n曜日
+			result = append(result, r.yearWeekdays(date(year, time.January, 1))...)
+		case len(r.byMonth) == 0 && len(r.byMonthDay) > 0:
+			for month := time.January; month <= time.December; month++ {
+				result = append(result, r.monthDays(date(year, month, 1), d)...)
+			}
+		case len(r.byMonth) == 0:
+			if t := date(year, m, d); t.Month() == m {
+				result = append(result, t)
+			}
+		default:
+			for _, month := range r.byMonth {
+				result = append(result, r.monthDays(date(year, month, 1), d)...)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Before(result[j]) })
+	return result
+}
+
+// monthDays firstの月で条件に一致する日を返す。BYMONTHDAY / BYDAY がなければdefaultDay
+func (r *rrule) monthDays(first time.Time, defaultDay int) []time.Time {
+	last := first.AddDate(0, 1, -1).Day()
+	days := make(map[int]bool)
+	for _, md := range r.byMonthDay {
+		if md < 0 {
+			md = last + md + 1
+		}
+		if md >= 1 && md <= last {
+			days[md] = true
+		}
+	}
+	if len(r.byDay) > 0 {
+		weekdayDays := make(map[int]bool)
+		for _, wd := range r.byDay {
+			for _, day := range nthWeekdays(first, last, wd) {
+				weekdayDays[day] = true
+			}
+		}
+		// BYMONTHDAYとBYDAYの両方がある場合は両方に一致する日
+		if len(r.byMonthDay) > 0 {
+			for day := range days {
+				if !weekdayDays[day] {
+					delete(days, day)
+				}
+			}
+		} else {
+			days = weekdayDays
+		}
+	}
+	if len(r.byMonthDay) == 0 && len(r.byDay) == 0 && defaultDay <= last {
+		days[defaultDay] = true
+	}
+
+	result := make([]time.Time, 0, len(days))
+	for day := range days {
+		result = append(result, first.AddDate(0, 0, day-1))
+	}
+	return result
+}
+
+// yearWeekdays firstの年でBYDAYに一致する日を返す。
+func (r *rrule) yearWeekdays(first time.Time) []time.Time {
+	last := first.AddDate(1, 0, -1).YearDay()
+	result := make([]time.Time, 0)
+	for _, wd := range r.byDay {
+		for _, day := range nthWeekdays(first, last, wd) {
+			result = append(result, first.AddDate(0, 0, day-1))
+		}
+	}
+	return result
+}
+
+// nthWeekdays first（1日目）からlast日目までの期間で、wdに一致する日（1始まり）を返す。
+// wd.nが0であればすべて、正であれば先頭から、負であれば末尾から数えたn番目
+func nthWeekdays(first time.Time, last int, wd weekdayNum) []int {
+	firstDay := 1 + (int(wd.weekday)-int(first.Weekday())+7)%7
+	all := make([]int, 0, 5)
+	for day := firstDay; day <= last; day += 7 {
+		all = append(all, day)
+	}
+	switch {
+	case wd.n == 0:
+		return all
+	case wd.n > 0 && wd.n <= len(all):
+		return []int{all[wd.n-1]}
+	case wd.n < 0 && -wd.n <= len(all):
+		return []int{all[len(all)+wd.n]}
+	}
+	return nil
+}
+
+func (r *rrule) matchMonth(m time.Month) bool {
+	if len(r.byMonth) == 0 {
+		return true
+	}
+	for _, month := range r.byMonth {
+		if month == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rrule) matchMonthDay(t time.Time) bool {
+	if len(r.byMonthDay) == 0 {
+		return true
+	}
+	last := t.AddDate(0, 1, -t.Day()).Day()
+	for _, md := range r.byMonthDay {
+		if md == t.Day() || md < 0 && last+md+1 == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rrule) matchWeekday(wd time.Weekday) bool {
+	if len(r.byDay) == 0 {
+		return true
+	}
+	for _, d := range r.byDay {
+		if d.weekday == wd {
+			return true
+		}
+	}
+	return false
+}
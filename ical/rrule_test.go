@@ -0,0 +1,126 @@
+package ical
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRRuleEach(t *testing.T) {
+	utc := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(formatLocal, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart string
+		offset  time.Duration // UNTIL（UTC）の判定に使う時差
+		want    []string
+	}{
+		{
+			name:    "daily count",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: "20220418T090000",
+			want:    []string{"20220418T090000", "20220419T090000", "20220420T090000"},
+		},
+		{
+			name:    "daily interval",
+			rule:    "FREQ=DAILY;INTERVAL=3;COUNT=3",
+			dtstart: "20220430T090000",
+			want:    []string{"20220430T090000", "20220503T090000", "20220506T090000"},
+		},
+		{
+			name:    "weekly byday until date",
+			rule:    "FREQ=WEEKLY;BYDAY=TU,TH;UNTIL=20220428",
+			dtstart: "20220419T100000",
+			want:    []string{"20220419T100000", "20220421T100000", "20220426T100000", "20220428T100000"},
+		},
+		{
+			// UNTILはUTCのため、+09:00の10:00（01:00Z）は 20220426T010000Z まで含む。
+			name:    "weekly until utc",
+			rule:    "FREQ=WEEKLY;UNTIL=20220426T010000Z",
+			dtstart: "20220412T100000",
+			offset:  9 * time.Hour,
+			want:    []string{"20220412T100000", "20220419T100000", "20220426T100000"},
+		},
+		{
+			name:    "weekly interval byday",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR;COUNT=4",
+			dtstart: "20220418T090000",
+			want:    []string{"20220418T090000", "20220422T090000", "20220502T090000", "20220506T090000"},
+		},
+		{
+			name:    "monthly last friday",
+			rule:    "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3",
+			dtstart: "20220325T100000",
+			want:    []string{"20220325T100000", "20220429T100000", "20220527T100000"},
+		},
+		{
+			name:    "monthly second tuesday",
+			rule:    "FREQ=MONTHLY;BYDAY=2TU;UNTIL=20220630T235959Z",
+			dtstart: "20220412T090000",
+			want:    []string{"20220412T090000", "20220510T090000", "20220614T090000"},
+		},
+		{
+			// 31日がない月は飛ばす。
+			name:    "monthly day 31",
+			rule:    "FREQ=MONTHLY;COUNT=3",
+			dtstart: "20220131T090000",
+			want:    []string{"20220131T090000", "20220331T090000", "20220531T090000"},
+		},
+		{
+			name:    "monthly bymonthday last",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3",
+			dtstart: "20220131T090000",
+			want:    []string{"20220131T090000", "20220228T090000", "20220331T090000"},
+		},
+		{
+			// 米国の夏時間の開始（3月第2日曜）
+			name:    "yearly bymonth byday",
+			rule:    "FREQ=YEARLY;BYMONTH=3;BYDAY=2SU;COUNT=3",
+			dtstart: "20070311T020000",
+			want:    []string{"20070311T020000", "20080309T020000", "20090308T020000"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseRRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			toAbs := func(wall time.Time) time.Time { return wall.Add(-tt.offset) }
+			got := make([]string, 0)
+			r.each(utc(tt.dtstart), toAbs, func(t time.Time) bool {
+				got = append(got, t.Format(formatLocal))
+				return len(got) < 20
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRRuleInvalid(t *testing.T) {
+	for _, rule := range []string{
+		"FREQ=HOURLY",
+		"COUNT=3",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=YEARLY;BYMONTH=13",
+		"FREQ=DAILY;UNTIL=tomorrow",
+		"FREQ",
+	} {
+		if _, err := parseRRule(rule); err == nil {
+			t.Errorf("parseRRule(%q) returned no error", rule)
+		}
+	}
+}
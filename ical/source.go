@@ -0,0 +1,70 @@
+package ical
+
+import (
+	"google-calendar-sample/gcal"
+	"google-calendar-sample/schedule"
+	"time"
+)
+
+// AggregatedUID 集約したVFREEBUSYのUID
+const AggregatedUID = "aggregated"
+
+// FromFreeBusy FreeBusyの結果からカレンダーごとのVFREEBUSYを作成する。
+// FreeBusy APIは仮の予定を区別しないため、すべてBUSYになる。
+func FromFreeBusy(result *gcal.FreeBusyResult) ([]FreeBusy, error) {
+	ids := result.CalendarIds()
+	components := make([]FreeBusy, 0, len(ids))
+	for _, id := range ids {
+		fb := FreeBusy{UID: id, Organizer: id, Start: result.TimeMin, End: result.TimeMax}
+		for _, p := range result.Busy[id] {
+			start, err := time.Parse(time.RFC3339, p.Start)
+			if err != nil {
+				return nil, err
+			}
+			end, err := time.Parse(time.RFC3339, p.End)
+			if err != nil {
+				return nil, err
+			}
+			fb.Busy = append(fb.Busy, Period{Interval: schedule.Interval{Start: start, End: end}, Type: FBTypeBusy})
+		}
+		components = append(components, fb)
+	}
+	return components, nil
+}
+
+// FromEvents 予定からカレンダーごとのVFREEBUSYを作成する。
+// 仮の予定はBUSY-TENTATIVEとし、同じ時間に確定の予定もあればBUSYを優先する。
+func FromEvents(calendarIds []string, events []*schedule.Event, start, end time.Time) []FreeBusy {
+	busy := make(map[string]schedule.Intervals)
+	tentative := make(map[string]schedule.Intervals)
+	window := schedule.Interval{Start: start, End: end}
+	for _, e := range events {
+		i := schedule.Interval{Start: e.StartDateTime, End: e.EndDateTime}
+		if e.IsTentative {
+			tentative[e.CalendarId] = append(tentative[e.CalendarId], i)
+		} else {
+			busy[e.CalendarId] = append(busy[e.CalendarId], i)
+		}
+	}
+
+	components := make([]FreeBusy, 0, len(calendarIds))
+	for _, id := range calendarIds {
+		fb := FreeBusy{UID: id, Organizer: id, Start: start, End: end}
+		confirmed := schedule.Intersect(busy[id], schedule.Intervals{window})
+		for _, i := range confirmed {
+			fb.Busy = append(fb.Busy, Period{Interval: i, Type: FBTypeBusy})
+		}
+		onlyTentative := schedule.Subtract(schedule.Intersect(tentative[id], schedule.Intervals{window}), confirmed)
+		for _, i := range onlyTentative {
+			fb.Busy = append(fb.Busy, Period{Interval: i, Type: FBTypeBusyTentative})
+		}
+		sortPeriods(fb.Busy)
+		components = append(components, fb)
+	}
+	return components
+}
+
+// WithAggregate カレンダーごとのVFREEBUSYの先頭に、集約したVFREEBUSYを加える。
+func WithAggregate(components []FreeBusy, start, end time.Time) []FreeBusy {
+	return append([]FreeBusy{Aggregate(AggregatedUID, components, start, end)}, components...)
+}
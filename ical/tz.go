@@ -0,0 +1,300 @@
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	formatLocal = "20060102T150405"
+	formatDate  = "20060102"
+)
+
+// zone 壁時計の時刻（年月日時分秒）を絶対時刻に変換する。
+//
+// IANAのタイムゾーン名であればtime.Locationを使い、
+// そうでなければVTIMEZONEの定義（STANDARD / DAYLIGHT）から時差を求める。
+type zone interface {
+	at(wall time.Time) time.Time
+}
+
+// locationZone time.Locationを使うzone
+type locationZone struct {
+	loc *time.Location
+}
+
+func (z locationZone) at(wall time.Time) time.Time {
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, z.loc)
+}
+
+// vtimezone VTIMEZONEの定義から時差を求めるzone
+type vtimezone struct {
+	id          string
+	observances []observance
+}
+
+// observance STANDARD / DAYLIGHT の1つ
+// onsetは切り替わる壁時計の時刻（切り替え前の時差）で、rruleがあれば毎年繰り返す。
+type observance struct {
+	name       string
+	offsetFrom int // 秒
+	offsetTo   int // 秒
+	onset      time.Time
+	rule       *rrule
+	rdates     []time.Time
+}
+
+// parseVTimezone VTIMEZONEコンポーネントを読み込む。
+func parseVTimezone(c *Component) (*vtimezone, error) {
+	z := &vtimezone{id: c.Value("TZID")}
+	if z.id == "" {
+		return nil, fmt.Errorf("VTIMEZONE without TZID")
+	}
+	for _, child := range c.Components {
+		if child.Name != "STANDARD" && child.Name != "DAYLIGHT" {
+			continue
+		}
+		o := observance{name: child.Value("TZNAME")}
+		var err error
+		if o.offsetFrom, err = parseUTCOffset(child.Value("TZOFFSETFROM")); err != nil {
+			return nil, fmt.Errorf("%s: %w", z.id, err)
+		}
+		if o.offsetTo, err = parseUTCOffset(child.Value("TZOFFSETTO")); err != nil {
+			return nil, fmt.Errorf("%s: %w", z.id, err)
+		}
+		if o.onset, err = time.Parse(formatLocal, child.Value("DTSTART")); err != nil {
+			return nil, fmt.Errorf("%s: %w", z.id, err)
+		}
+		if rule := child.Value("RRULE"); rule != "" {
+			if o.rule, err = parseRRule(rule); err != nil {
+				return nil, fmt.Errorf("%s: %w", z.id, err)
+			}
+		}
+		for _, p := range child.All("RDATE") {
+			for _, v := range strings.Split(p.Value, ",") {
+				t, err := time.Parse(formatLocal, v)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", z.id, err)
+				}
+				o.rdates = append(o.rdates, t)
+			}
+		}
+		z.observances = append(z.observances, o)
+	}
+	if len(z.observances) == 0 {
+		return nil, fmt.Errorf("%s: VTIMEZONE without STANDARD or DAYLIGHT", z.id)
+	}
+	return z, nil
+}
+
+func (z *vtimezone) at(wall time.Time) time.Time {
+	wall = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, time.UTC)
+
+	// wall以前で最後に切り替わったobservanceの時差を使う。
+	// どれもwallより後であれば、最も早いobservanceの切り替え前の時差を使う。
+	var current *observance
+	var currentOnset time.Time
+	for n := range z.observances {
+		o := &z.observances[n]
+		onset, ok := o.lastOnset(wall)
+		if ok && (current == nil || onset.After(currentOnset)) {
+			current, currentOnset = o, onset
+		}
+	}
+	offset, name := 0, z.id
+	if current != nil {
+		offset, name = current.offsetTo, current.name
+	} else {
+		earliest := z.observances[0]
+		for _, o := range z.observances[1:] {
+			if o.onset.Before(earliest.onset) {
+				earliest = o
+			}
+		}
+		offset = earliest.offsetFrom
+	}
+	if name == "" {
+		name = z.id
+	}
+	return wall.Add(-time.Duration(offset) * time.Second).In(time.FixedZone(name, offset))
+}
+
+// lastOnset wall以前で最後に切り替わった壁時計の時刻を返す。
+func (o *observance) lastOnset(wall time.Time) (time.Time, bool) {
+	var last time.Time
+	found := false
+	if !o.onset.After(wall) {
+		last, found = o.onset, true
+	}
+	if o.rule != nil {
+		// UNTILはUTCで指定されるため、切り替え前の時差で絶対時刻に換算して比較する。
+		toAbs := func(t time.Time) time.Time { return t.Add(-time.Duration(o.offsetFrom) * time.Second) }
+		o.rule.each(o.onset, toAbs, func(t time.Time) bool {
+			if t.After(wall) {
+				return false
+			}
+			last, found = t, true
+			return true
+		})
+	}
+	for _, t := range o.rdates {
+		if !t.After(wall) && (!found || t.After(last)) {
+			last, found = t, true
+		}
+	}
+	return last, found
+}
+
+// parseUTCOffset ex: +0900, -0430, +053730
+func parseUTCOffset(s string) (int, error) {
+	if len(s) != 5 && len(s) != 7 || (s[0] != '+' && s[0] != '-') {
+		return 0, fmt.Errorf("invalid utc offset: %q", s)
+	}
+	hour, err1 := strconv.Atoi(s[1:3])
+	minute, err2 := strconv.Atoi(s[3:5])
+	second := 0
+	var err3 error
+	if len(s) == 7 {
+		second, err3 = strconv.Atoi(s[5:7])
+	}
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, fmt.Errorf("invalid utc offset: %q", s)
+	}
+	offset := hour*3600 + minute*60 + second
+	if s[0] == '-' {
+		offset = -offset
+	}
+	return offset, nil
+}
+
+// zones VCALENDAR内のVTIMEZONEを読み込む。
+// TZIDがIANAのタイムゾーン名であればtime.Locationを優先する。
+func zones(cal *Component) (map[string]zone, error) {
+	result := make(map[string]zone)
+	for _, c := range cal.Children("VTIMEZONE") {
+		z, err := parseVTimezone(c)
+		if err != nil {
+			return nil, err
+		}
+		if loc, err := time.LoadLocation(z.id); err == nil {
+			result[z.id] = locationZone{loc: loc}
+			continue
+		}
+		result[z.id] = z
+	}
+	return result, nil
+}
+
+// dateTime 日時の値（壁時計の時刻とzone）
+type dateTime struct {
+	wall   time.Time // time.UTCに置いた壁時計の時刻
+	zone   zone
+	isDate bool // VALUE=DATE（終日）
+}
+
+func (d dateTime) abs() time.Time {
+	return d.zone.at(d.wall)
+}
+
+// parseDateTime DTSTART / DTEND / EXDATE などの値を読み込む。
+//
+// ex:
+// DTSTART;VALUE=DATE:20220418                -> 終日（locの0時）
+// DTSTART:20220418T010000Z                   -> UTC
+// DTSTART;TZID=Asia/Tokyo:20220418T100000    -> TZIDのタイムゾーン
+// DTSTART:20220418T100000                    -> 浮動時刻（locの時刻）
+func parseDateTime(value string, params map[string]string, zs map[string]zone, loc *time.Location) (dateTime, error) {
+	if params["VALUE"] == "DATE" || len(value) == len(formatDate) {
+		wall, err := time.Parse(formatDate, value)
+		return dateTime{wall: wall, zone: locationZone{loc: loc}, isDate: true}, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		wall, err := time.Parse(formatLocal, strings.TrimSuffix(value, "Z"))
+		return dateTime{wall: wall, zone: locationZone{loc: time.UTC}}, err
+	}
+	wall, err := time.Parse(formatLocal, value)
+	if err != nil {
+		return dateTime{}, err
+	}
+	tzid := strings.Trim(params["TZID"], "/")
+	if tzid == "" {
+		return dateTime{wall: wall, zone: locationZone{loc: loc}}, nil
+	}
+	if z, ok := zs[tzid]; ok {
+		return dateTime{wall: wall, zone: z}, nil
+	}
+	tzLoc, err := time.LoadLocation(tzid)
+	if err != nil {
+		return dateTime{}, fmt.Errorf("unknown TZID %q", tzid)
+	}
+	zs[tzid] = locationZone{loc: tzLoc}
+	return dateTime{wall: wall, zone: zs[tzid]}, nil
+}
+
+// parseDateTimes EXDATE / RDATE のようにカンマ区切りで複数の値を持つプロパティを読み込む。
+func parseDateTimes(props []*Property, zs map[string]zone, loc *time.Location) ([]dateTime, error) {
+	result := make([]dateTime, 0)
+	for _, p := range props {
+		for _, v := range strings.Split(p.Value, ",") {
+			d, err := parseDateTime(v, p.Params, zs, loc)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", p.Name, err)
+			}
+			result = append(result, d)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].wall.Before(result[j].wall) })
+	return result, nil
+}
+
+// parseDuration ex: PT1H30M, P1D, P2W, -PT15M
+func parseDuration(s string) (days int, d time.Duration, err error) {
+	orig := s
+	sign := 1
+	if strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	}
+	s = strings.TrimPrefix(s, "+")
+	if !strings.HasPrefix(s, "P") {
+		return 0, 0, fmt.Errorf("invalid duration: %q", orig)
+	}
+	s = s[1:]
+	inTime := false
+	num := ""
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			num += string(r)
+			continue
+		case r == 'T':
+			inTime = true
+			continue
+		}
+		n, convErr := strconv.Atoi(num)
+		if convErr != nil {
+			return 0, 0, fmt.Errorf("invalid duration: %q", orig)
+		}
+		num = ""
+		switch {
+		case r == 'W' && !inTime:
+			days += 7 * n
+		case r == 'D' && !inTime:
+			days += n
+		case r == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case r == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		case r == 'S' && inTime:
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, 0, fmt.Errorf("invalid duration: %q", orig)
+		}
+	}
+	if num != "" {
+		return 0, 0, fmt.Errorf("invalid duration: %q", orig)
+	}
+	return sign * days, time.Duration(sign) * d, nil
+}
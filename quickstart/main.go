@@ -2,13 +2,15 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"google-calendar-sample/auth"
+	"google-calendar-sample/gcal"
+	"google-calendar-sample/report"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -19,66 +21,65 @@ import (
 func getClient(config *oauth2.Config) *http.Client {
 	// The file token.json stores the user's access and refresh tokens, and is
 	// created automatically when the authorization flow completes for the first
-	// time.
-	tokFile := "token.json"
-	tok, err := tokenFromFile(tokFile)
+	// time. It is encrypted with AES-GCM when CALENDAR_TOKEN_KEY or
+	// CALENDAR_TOKEN_KEYFILE is set.
+	cipher, err := auth.CipherFromEnv()
 	if err != nil {
+		log.Fatalf("Unable to load token encryption key: %v", err)
+	}
+	store := &auth.FileStore{Path: "token.json", Cipher: cipher}
+	tok, err := store.Load("")
+	if err != nil {
+		if err != auth.ErrTokenNotFound {
+			log.Printf("Discarding unreadable token: %v", err)
+		}
 		tok = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
+		fmt.Printf("Saving credential file to: %s\n", store.Path)
+		if err := store.Save("", tok); err != nil {
+			log.Fatalf("Unable to cache oauth token: %v", err)
+		}
+	}
+	// Ask only for the missing scopes when the saved token was granted fewer
+	// scopes than config requests.
+	ctx := context.Background()
+	tok, upgraded, err := auth.EnsureScopes(ctx, tok, auth.NewLoopbackFlow(config))
+	if err != nil {
+		log.Fatalf("Unable to upgrade token scopes: %v", err)
+	}
+	if upgraded {
+		if err := store.Save("", tok); err != nil {
+			log.Fatalf("Unable to cache oauth token: %v", err)
+		}
 	}
-	return config.Client(context.Background(), tok)
+	// Refreshed tokens are written back to the store automatically.
+	src := auth.PersistingTokenSource(config.TokenSource(ctx, tok), store, "", tok)
+	return oauth2.NewClient(ctx, src)
 }
 
 // Request a token from the web, then returns the retrieved token.
+// The browser redirects back to a local loopback listener, so the
+// authorization code is exchanged without copying it by hand.
 func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code: %v", err)
-	}
-
-	tok, err := config.Exchange(context.TODO(), authCode)
+	tok, err := auth.NewLoopbackFlow(config).Token(context.Background())
 	if err != nil {
 		log.Fatalf("Unable to retrieve token from web: %v", err)
 	}
 	return tok
 }
 
-// Retrieves a token from a local file.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
-}
-
-// Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
-	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
-}
-
 func main() {
+	clientSecret := flag.String("client-secret", "", "path to the OAuth client secret file")
+	flag.Parse()
+
 	ctx := context.Background()
-	b, err := ioutil.ReadFile("credentials/client_secret.json")
+	b, err := auth.ResolveClientSecret(*clientSecret)
 	if err != nil {
 		log.Fatalf("Unable to read client secret file: %v", err)
 	}
 
-	// If modifying these scopes, delete your previously saved token.json.
-	config, err := google.ConfigFromJSON(b, calendar.CalendarReadonlyScope)
+	// Request only the scopes needed for the calls below. If these change, the
+	// saved token.json is upgraded through incremental consent in getClient.
+	config, err := google.ConfigFromJSON(b, auth.ScopesFor(auth.OpCalendarListReadonly, auth.OpFreeBusy)...)
 	if err != nil {
 		log.Fatalf("Unable to parse client secret file to config: %v", err)
 	}
@@ -89,35 +90,35 @@ func main() {
 		log.Fatalf("Unable to retrieve Calendar client: %v", err)
 	}
 
-	calendarList, err := srv.CalendarList.List().Do()
+	calendars, err := gcal.ListCalendars(ctx, srv, gcal.ListOptions{})
 	if err != nil {
 		log.Fatalf("calendar list request failed: %v", err)
 	}
 
-	freeBusyRequestItem := make([]*calendar.FreeBusyRequestItem, 0, len(calendarList.Items))
-	for _, item := range calendarList.Items {
-		freeBusyRequestItem = append(freeBusyRequestItem, &calendar.FreeBusyRequestItem{
-			Id:              item.Id,
-			ForceSendFields: nil,
-			NullFields:      nil,
-		})
+	calendarIds := make([]string, 0, len(calendars))
+	for _, item := range calendars {
+		calendarIds = append(calendarIds, item.Id)
 	}
 
-	query := calendar.FreeBusyRequest{
-		CalendarExpansionMax: 1,
-		GroupExpansionMax:    1,
-		Items:                freeBusyRequestItem,
-		TimeMax:              time.Now().Add(time.Hour * 24 * 14).Format(time.RFC3339),
-		TimeMin:              time.Now().Format(time.RFC3339),
-		TimeZone:             "",
-		ForceSendFields:      nil,
-		NullFields:           nil,
-	}
-	resp, err := srv.Freebusy.Query(&query).Do()
+	freeBusyClient := gcal.NewFreeBusyClient(srv, "")
+	resp, err := freeBusyClient.Query(ctx, calendarIds, time.Now(), time.Now().Add(time.Hour*24*14))
 	if err != nil {
 		log.Fatalf("free busy request failed: %v", err)
 	}
-	fmt.Printf("%v\n", resp)
+	for _, e := range resp.Errors {
+		log.Printf("free busy error: %v", e)
+	}
+	for group, members := range resp.Groups {
+		log.Printf("group %s expanded to %v", group, members)
+	}
+	r, err := report.FromFreeBusy(resp, time.Local)
+	if err != nil {
+		log.Fatalf("Unable to build free busy report: %v", err)
+	}
+	r.Color = report.IsTerminal(os.Stdout)
+	if err := r.Write(os.Stdout); err != nil {
+		log.Fatalf("Unable to write free busy report: %v", err)
+	}
 
 	// t := time.Now().Format(time.RFC3339)
 	// events, err := srv.Events.List("primary").ShowDeleted(false).
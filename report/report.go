@@ -0,0 +1,253 @@
+// Package report 空き状況を人が読める形式（テキスト・タイムライン）で出力する。
+package report
+
+import (
+	"fmt"
+	"google-calendar-sample/gcal"
+	"google-calendar-sample/schedule"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultStartHour = 8
+	DefaultEndHour   = 20
+	maxLabelWidth    = 32
+)
+
+// ANSIカラー（TTYのみ）
+const (
+	colorReset = "\x1b[0m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorBold  = "\x1b[1m"
+)
+
+// Report カレンダーごとの予定ありの期間をまとめたもの
+type Report struct {
+	CalendarIds []string
+	Busy        map[string]schedule.Intervals
+	From        time.Time // 期間の開始（この日から）
+	To          time.Time // 期間の終了（この日まで）
+	Location    *time.Location
+	StartHour   int // タイムラインに表示する時間帯
+	EndHour     int
+	Color       bool // ANSIカラーで出力する
+}
+
+// FromFreeBusy FreeBusyの結果からReportを作成する。
+func FromFreeBusy(result *gcal.FreeBusyResult, loc *time.Location) (*Report, error) {
+	r := &Report{
+		CalendarIds: result.CalendarIds(),
+		Busy:        make(map[string]schedule.Intervals),
+		From:        result.TimeMin,
+		To:          result.TimeMax,
+		Location:    loc,
+		StartHour:   DefaultStartHour,
+		EndHour:     DefaultEndHour,
+	}
+	for _, id := range r.CalendarIds {
+		for _, p := range result.Busy[id] {
+			start, err := time.Parse(time.RFC3339, p.Start)
+			if err != nil {
+				return nil, err
+			}
+			end, err := time.Parse(time.RFC3339, p.End)
+			if err != nil {
+				return nil, err
+			}
+			r.Busy[id] = append(r.Busy[id], schedule.Interval{Start: start, End: end})
+		}
+	}
+	return r, nil
+}
+
+// IsTerminal fがTTYで、NO_COLORが設定されていないか（カラー出力するか）を判定する。
+// see: https://no-color.org/
+func IsTerminal(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Write 日ごとに、カレンダーごとの予定ありの時間帯と、全員分のタイムラインを出力する。
+//
+// ex:
+//
+//	== 2022/04/18 (Mon) ==
+//	calendar-a@example.com
+//	  10:00 - 11:30
+//	calendar-b@example.com
+//	  (free)
+//	                         08  09  10  11  12 ...
+//	calendar-a@example.com   ........######......
+//	calendar-b@example.com   ....................
+//	free                     oooooooooooooooooooo
+//
+// タイムラインは2文字が1つの時間枠（30分）で、# 予定あり / . 空き。
+// free 行は誰か1人でも空いていれば o、全員予定ありなら x。
+func (r *Report) Write(w io.Writer) error {
+	loc := r.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	for day := startOfDay(r.From.In(loc)); day.Before(r.To.In(loc)); day = day.AddDate(0, 0, 1) {
+		if err := r.writeDay(w, day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Report) writeDay(w io.Writer, day time.Time) error {
+	next := day.AddDate(0, 0, 1)
+	dayInterval := schedule.Intervals{{Start: day, End: next}}
+
+	fmt.Fprintf(w, "%s== %s (%s) ==%s\n", r.paint(colorBold), day.Format(schedule.FormatDate), day.Weekday().String()[:3], r.paint(colorReset))
+	for _, id := range r.CalendarIds {
+		fmt.Fprintln(w, id)
+		busy := schedule.Intersect(r.Busy[id], dayInterval)
+		if len(busy) == 0 {
+			fmt.Fprintf(w, "  %s(free)%s\n", r.paint(colorGreen), r.paint(colorReset))
+			continue
+		}
+		for _, b := range busy {
+			fmt.Fprintf(w, "  %s%s%s\n", r.paint(colorRed), formatRange(b, day), r.paint(colorReset))
+		}
+	}
+	fmt.Fprintln(w)
+	r.writeTimeline(w, day)
+	fmt.Fprintln(w)
+	return nil
+}
+
+// writeTimeline 時間枠ごとのタイムラインを出力する。
+func (r *Report) writeTimeline(w io.Writer, day time.Time) {
+	slotsPerHour := 60 / schedule.TimeFrameMinutes
+	first, last := uint(r.StartHour*slotsPerHour), uint(r.EndHour*slotsPerHour)
+
+	width := len("free")
+	for _, id := range r.CalendarIds {
+		if l := displayWidth(label(id)); l > width {
+			width = l
+		}
+	}
+
+	var header strings.Builder
+	for h := r.StartHour; h < r.EndHour; h++ {
+		header.WriteString(fmt.Sprintf("%-*s", slotsPerHour*2, fmt.Sprintf("%02d", h)))
+	}
+	fmt.Fprintf(w, "%s  %s\n", padRight("", width), strings.TrimRight(header.String(), " "))
+
+	// 論理積で集約し、誰か1人でも空いている枠を求める。
+	allBusy := schedule.FullDayBits
+	for _, id := range r.CalendarIds {
+		bits := r.Busy[id].Bits(day)
+		allBusy &= bits
+		var row strings.Builder
+		for i := first; i < last; i++ {
+			if bits&(1<<i) != 0 {
+				row.WriteString(r.paint(colorRed) + "##" + r.paint(colorReset))
+			} else {
+				row.WriteString("..")
+			}
+		}
+		fmt.Fprintf(w, "%s  %s\n", padRight(label(id), width), row.String())
+	}
+
+	var free strings.Builder
+	for i := first; i < last; i++ {
+		if allBusy&(1<<i) != 0 {
+			free.WriteString(r.paint(colorRed) + "xx" + r.paint(colorReset))
+		} else {
+			free.WriteString(r.paint(colorGreen) + "oo" + r.paint(colorReset))
+		}
+	}
+	fmt.Fprintf(w, "%s  %s\n", padRight("free", width), free.String())
+}
+
+func (r *Report) paint(code string) string {
+	if !r.Color {
+		return ""
+	}
+	return code
+}
+
+// formatRange 日をまたぐ予定は 24:00 まで / 00:00 からとして表示する。
+func formatRange(i schedule.Interval, day time.Time) string {
+	loc := day.Location()
+	start, end := i.Start.In(loc), i.End.In(loc)
+	endText := end.Format("15:04")
+	if !end.Before(day.AddDate(0, 0, 1)) {
+		endText = "24:00"
+	}
+	return fmt.Sprintf("%s - %s", start.Format("15:04"), endText)
+}
+
+// label 表示幅がmaxLabelWidthを超えるカレンダーIDは末尾を省略する。
+// マルチバイト文字の途中で切らないよう、rune単位で表示幅を数える。
+func label(id string) string {
+	if displayWidth(id) <= maxLabelWidth {
+		return id
+	}
+	var b strings.Builder
+	width := 0
+	for _, c := range id {
+		w := runeWidth(c)
+		if width+w > maxLabelWidth-len("...") {
+			break
+		}
+		b.WriteRune(c)
+		width += w
+	}
+	return b.String() + "..."
+}
+
+// padRight 表示幅がwidthになるよう末尾を空白で埋める。
+// fmtの %-*s はrune数で数えるため、全角文字を含むと列がずれる。
+func padRight(s string, width int) string {
+	if n := width - displayWidth(s); n > 0 {
+		return s + strings.Repeat(" ", n)
+	}
+	return s
+}
+
+// displayWidth 端末での表示幅（全角文字は2）
+func displayWidth(s string) int {
+	width := 0
+	for _, c := range s {
+		width += runeWidth(c)
+	}
+	return width
+}
+
+// runeWidth 東アジアの全角文字（CJK・ハングル・全角記号）を2、それ以外を1とする。
+func runeWidth(c rune) int {
+	switch {
+	case c >= 0x1100 && c <= 0x115F, // ハングル字母
+		c >= 0x2E80 && c <= 0x303E, // CJK部首・記号
+		c >= 0x3041 && c <= 0x33FF, // ひらがな・カタカナ・CJK互換
+		c >= 0x3400 && c <= 0x4DBF, // CJK統合漢字拡張A
+		c >= 0x4E00 && c <= 0x9FFF, // CJK統合漢字
+		c >= 0xA000 && c <= 0xA4CF, // イ文字
+		c >= 0xAC00 && c <= 0xD7A3, // ハングル音節
+		c >= 0xF900 && c <= 0xFAFF, // CJK互換漢字
+		c >= 0xFE30 && c <= 0xFE4F, // CJK互換形
+		c >= 0xFF00 && c <= 0xFF60, // 全角英数・記号
+		c >= 0xFFE0 && c <= 0xFFE6,
+		c >= 0x20000 && c <= 0x3FFFD:
+		return 2
+	}
+	return 1
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,105 @@
+package report
+
+import (
+	"bytes"
+	"flag"
+	"google-calendar-sample/schedule"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// go test ./report -run TestWrite -update でgoldenファイルを作り直す。
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+var tokyo = time.FixedZone("JST", 9*60*60)
+
+// at 2022/04/dd hh:mm (JST)
+func at(dd, hh, mm int) time.Time {
+	return time.Date(2022, 4, dd, hh, mm, 0, 0, tokyo)
+}
+
+func TestLabel(t *testing.T) {
+	tests := []struct {
+		name      string
+		id        string
+		want      string
+		wantWidth int
+	}{
+		{name: "short", id: "a@example.com", want: "a@example.com", wantWidth: 13},
+		{name: "just fits", id: strings.Repeat("a", maxLabelWidth), want: strings.Repeat("a", maxLabelWidth), wantWidth: maxLabelWidth},
+		{name: "long ascii", id: strings.Repeat("a", 40), want: strings.Repeat("a", 29) + "...", wantWidth: maxLabelWidth},
+		// 2byte文字の途中で切らない。
+		{name: "accented", id: strings.Repeat("é", 40), want: strings.Repeat("é", 29) + "...", wantWidth: maxLabelWidth},
+		// 全角文字は幅2で数える。
+		{name: "wide", id: "会議室" + strings.Repeat("あ", 20), want: "会議室" + strings.Repeat("あ", 11) + "...", wantWidth: 31},
+		{name: "wide fits", id: strings.Repeat("あ", 16), want: strings.Repeat("あ", 16), wantWidth: maxLabelWidth},
+		// 全角文字が省略記号の手前の境界をまたぐ場合はその文字ごと省略する。
+		{name: "wide across the boundary", id: "a" + strings.Repeat("あ", 20), want: "a" + strings.Repeat("あ", 14) + "...", wantWidth: 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := label(tt.id)
+			if got != tt.want {
+				t.Errorf("label() = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("label() = %q is not valid UTF-8", got)
+			}
+			if w := displayWidth(got); w != tt.wantWidth || w > maxLabelWidth {
+				t.Errorf("displayWidth(label()) = %d, want %d", w, tt.wantWidth)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name  string
+		color bool
+	}{
+		{name: "plain", color: false},
+		{name: "color", color: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Report{
+				CalendarIds: []string{"a@example.com", "会議室-大@resource.example.com", "very-long-calendar-name@group.calendar.google.com"},
+				Busy: map[string]schedule.Intervals{
+					"a@example.com": {{Start: at(18, 9, 0), End: at(18, 10, 30)}},
+					// 日をまたぐ予定
+					"会議室-大@resource.example.com":                        {{Start: at(18, 8, 0), End: at(18, 9, 15)}, {Start: at(18, 23, 0), End: at(19, 9, 0)}},
+					"very-long-calendar-name@group.calendar.google.com": {{Start: at(18, 8, 0), End: at(18, 10, 0)}},
+				},
+				From:      at(18, 0, 0),
+				To:        at(19, 12, 0),
+				Location:  tokyo,
+				StartHour: 8,
+				EndHour:   11,
+				Color:     tt.color,
+			}
+			var buf bytes.Buffer
+			if err := r.Write(&buf); err != nil {
+				t.Fatal(err)
+			}
+			got := buf.Bytes()
+
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Write() differs from %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Package schedule 予定を時間枠ごとのbitに換算し、空き時間を計算する。
+//
+// Events.Listから変換したEventも、FreeBusyのTimePeriodも
+// 同じ「予定ありの期間 [start, end)」としてCalendarBitsに集約するため、
+// 同じ予定からは取得方法によらず同じbitsになる。
+package schedule
+
+import (
+	"google.golang.org/api/calendar/v3"
+	"sort"
+	"time"
+)
+
+const (
+	FormatDate       = "2006/01/02"
+	TimeFrameMinutes = 30                         // 1個の時間枠
+	SlotsPerDay      = 24 * 60 / TimeFrameMinutes // 1日の時間枠の数
+	FullDayBits      = uint64(1)<<SlotsPerDay - 1 // 1日分の時間枠をすべて1（予定あり）にしたbits
+)
+
+// CalendarBits 日付ごとに予定の状況を管理する
+//
+// ex:
+//
+//	CalendarBits: {
+//		"2022/04/16" : {
+//			"example@gmail.com" : 000000000000000000001111110001100001000110000000
+//			"example2@gmail.com": 000000000000000000001111110001100001000110000000
+//		},
+//	}
+//
+// 右から1bit目が00:00~00:30で、1が予定あり、0が空き。
+type CalendarBits map[string]map[string]uint64
+
+// NewCalendarBits 空のCalendarBitsを作成する。
+func NewCalendarBits() CalendarBits {
+	return make(CalendarBits)
+}
+
+// RangeBits 0時からの経過分 startMinutes〜endMinutes（endは含まない）に重なる時間枠を1にしたbitsを返す。
+//
+// ex: 30分枠の場合
+// 08:00~09:00 -> 右から17bit目と18bit目が1
+// 08:30~09:30 -> 右から18bit目と19bit目が1
+// 08:15~08:45 -> 08:00~08:30, 08:30~09:00の枠に重なるので右から17bit目と18bit目が1
+// ループせずにmaskの差で求める。 (1<<end - 1) &^ (1<<start - 1)
+func RangeBits(startMinutes, endMinutes int) uint64 {
+	if startMinutes < 0 {
+		startMinutes = 0
+	}
+	if endMinutes > 24*60 {
+		endMinutes = 24 * 60
+	}
+	startSlot := uint(startMinutes / TimeFrameMinutes)
+	// 終了は枠の途中でもその枠を予定ありにするため切り上げる。
+	endSlot := uint((endMinutes + TimeFrameMinutes - 1) / TimeFrameMinutes)
+	if endSlot <= startSlot {
+		return 0
+	}
+	return (uint64(1)<<endSlot - 1) &^ (uint64(1)<<startSlot - 1)
+}
+
+// Set 日付・カレンダーのbitsに論理和でbitsを追加する。
+func (c CalendarBits) Set(date, calendarId string, bits uint64) {
+	if _, ok := c[date]; !ok {
+		c[date] = make(map[string]uint64)
+	}
+	// 1日に複数の予定がある場合は論理和で集約する。
+	// event_1: 0000000011
+	// event_2: 0000110000
+	//        → 0000110011
+	c[date][calendarId] |= bits
+}
+
+// AddBusy 予定ありの期間 [start, end) をlocの日付ごとに分割してbitsに追加する。
+//
+// ex: 2022/04/18 22:00 ~ 2022/04/20 02:00 の予定
+// -> 2022/04/18 22:00~24:00, 2022/04/19 00:00~24:00, 2022/04/20 00:00~02:00 に分割
+func (c CalendarBits) AddBusy(calendarId string, start, end time.Time, loc *time.Location) {
+	splitDays(start, end, loc, func(day int64, startMinutes, endMinutes int) {
+		c.Set(civilDate(day).Format(FormatDate), calendarId, RangeBits(startMinutes, endMinutes))
+	})
+}
+
+// AddEvent Eventの期間をbitsに追加する。
+func (c CalendarBits) AddEvent(event *Event, loc *time.Location) {
+	c.AddBusy(event.CalendarId, event.StartDateTime, event.EndDateTime, loc)
+}
+
+// AddTimePeriod FreeBusyのTimePeriodをbitsに追加する。
+func (c CalendarBits) AddTimePeriod(calendarId string, period *calendar.TimePeriod, loc *time.Location) error {
+	start, err := time.Parse(time.RFC3339, period.Start)
+	if err != nil {
+		return err
+	}
+	end, err := time.Parse(time.RFC3339, period.End)
+	if err != nil {
+		return err
+	}
+	c.AddBusy(calendarId, start, end, loc)
+	return nil
+}
+
+// FillEmpty from〜toの日付で、予定が1つもないカレンダーにすべて0（空き）を入れる。
+//
+// 予定からbitsを作るだけでは予定がない日のキーができないため、
+// カレンダーID（ユーザー）ごとに予定がない日も空いていることにする。
+// ex: 2022/04/19の予定がまったくないとき
+// {"2022/04/18": {"hoge@example.com": 0000000000001110...}, "2022/04/20": {...}}
+// -> {"2022/04/18": {...}, "2022/04/19": {"hoge@example.com": 0000000000000000...}, "2022/04/20": {...}}
+func (c CalendarBits) FillEmpty(calendarIds []string, from, to time.Time, loc *time.Location) {
+	for day := startOfDay(from.In(loc)); !day.After(to.In(loc)); day = day.AddDate(0, 0, 1) {
+		for _, id := range calendarIds {
+			c.Set(day.Format(FormatDate), id, 0)
+		}
+	}
+}
+
+// Dates 日付を昇順で返す。
+// FormatDate(2006/01/02)はゼロ埋めのため文字列の昇順 = 日付の昇順
+func (c CalendarBits) Dates() []string {
+	dates := make([]string, 0, len(c))
+	for date := range c {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	return dates
+}
+
+// DateBits 日付:bits に集約する。
+// 誰か1人でも空きがあれば予定が空いている仕様のため、カレンダーごとのbitsを論理積で集約する。
+//
+// b_a  : 0011100
+// b_b  : 0110110
+// -------------
+// aki  : 0010100  (0 空き / 1 空いてない)
+func (c CalendarBits) DateBits() map[string]uint64 {
+	dateBits := make(map[string]uint64, len(c))
+	for date, v := range c {
+		// keyがない場合に論理積で集約するとすべて0になるため、最初はすべて1から始める。
+		bits := FullDayBits
+		for _, b := range v {
+			bits &= b
+		}
+		dateBits[date] = bits
+	}
+	return dateBits
+}
+
+// splitDays 期間 [start, end) をlocの日付ごとに分割し、日付（civilDay）と0時からの経過分 startMinutes〜endMinutes でfnを呼ぶ。
+// CalendarBits・DenseBitsのAddBusyで共通の分割処理。
+//
+// 日ごとのtime.Timeを作らず、開始日・終了日の時刻（分）だけで範囲を求める。
+// 途中の日は0〜24:00、0時ちょうどに終わる予定は前日の24:00までとする。
+// 終了の秒以下は切り上げる。
+func splitDays(start, end time.Time, loc *time.Location, fn func(day int64, startMinutes, endMinutes int)) {
+	if !start.Before(end) {
+		return
+	}
+	start, end = start.In(loc), end.In(loc)
+	first, last := civilDay(start), civilDay(end)
+
+	startMinutes := start.Hour()*60 + start.Minute()
+	endMinutes := end.Hour()*60 + end.Minute()
+	if end.Second() > 0 || end.Nanosecond() > 0 {
+		endMinutes++
+	}
+	if endMinutes == 0 {
+		last--
+		endMinutes = 24 * 60
+	}
+
+	for day := first; day <= last; day++ {
+		s, e := 0, 24*60
+		if day == first {
+			s = startMinutes
+		}
+		if day == last {
+			e = endMinutes
+		}
+		if s < e {
+			fn(day, s, e)
+		}
+	}
+}
+
+// civilDay 年月日を1970/01/01からの日数に変換する。
+func civilDay(t time.Time) int64 {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400
+}
+
+// civilDate civilDayの日数を年月日（UTCの0時）に戻す。
+func civilDate(day int64) time.Time {
+	return time.Unix(day*86400, 0).UTC()
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// minuteOfDay dayの0時からの経過分を返す。
+// 翌日0時ちょうどは24:00として扱い、isEndの場合は秒以下を切り上げる。
+func minuteOfDay(t, day time.Time, isEnd bool) int {
+	if !t.Before(day.AddDate(0, 0, 1)) {
+		return 24 * 60
+	}
+	minutes := t.Hour()*60 + t.Minute()
+	if isEnd && (t.Second() > 0 || t.Nanosecond() > 0) {
+		minutes++
+	}
+	return minutes
+}
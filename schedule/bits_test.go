@@ -0,0 +1,153 @@
+package schedule
+
+import (
+	"google.golang.org/api/calendar/v3"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestAddEventMatchesAddTimePeriod 同じ予定をEvents.List（AddEvent）とFreeBusy（AddTimePeriod）のどちらで
+// 取得しても同じbitsになることを確認する。FreeBusyのTimePeriodはUTCで返るためUTCの文字列にする。
+func TestAddEventMatchesAddTimePeriod(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	type period struct{ start, end time.Time }
+	tests := []struct {
+		name     string
+		loc      *time.Location
+		periods  []period
+		from, to time.Time // FillEmptyの範囲（ゼロ値の場合は呼ばない）
+		want     CalendarBits
+	}{
+		{
+			name:    "within a day",
+			loc:     tokyo,
+			periods: []period{{time.Date(2022, 4, 18, 8, 0, 0, 0, tokyo), time.Date(2022, 4, 18, 9, 15, 0, 0, tokyo)}},
+			want:    CalendarBits{"2022/04/18": {"a": RangeBits(8*60, 9*60+30)}},
+		},
+		{
+			name: "multiple periods on one day",
+			loc:  tokyo,
+			periods: []period{
+				{time.Date(2022, 4, 18, 8, 0, 0, 0, tokyo), time.Date(2022, 4, 18, 9, 0, 0, 0, tokyo)},
+				{time.Date(2022, 4, 18, 13, 0, 0, 0, tokyo), time.Date(2022, 4, 18, 14, 30, 0, 0, tokyo)},
+				{time.Date(2022, 4, 18, 8, 30, 0, 0, tokyo), time.Date(2022, 4, 18, 10, 0, 0, 0, tokyo)},
+			},
+			want: CalendarBits{"2022/04/18": {"a": RangeBits(8*60, 10*60) | RangeBits(13*60, 14*60+30)}},
+		},
+		{
+			name:    "across midnight",
+			loc:     tokyo,
+			periods: []period{{time.Date(2022, 4, 18, 22, 0, 0, 0, tokyo), time.Date(2022, 4, 20, 2, 0, 0, 0, tokyo)}},
+			want: CalendarBits{
+				"2022/04/18": {"a": RangeBits(22*60, 24*60)},
+				"2022/04/19": {"a": FullDayBits},
+				"2022/04/20": {"a": RangeBits(0, 2*60)},
+			},
+		},
+		{
+			name:    "ends at midnight",
+			loc:     tokyo,
+			periods: []period{{time.Date(2022, 4, 18, 23, 0, 0, 0, tokyo), time.Date(2022, 4, 19, 0, 0, 0, 0, tokyo)}},
+			want:    CalendarBits{"2022/04/18": {"a": RangeBits(23*60, 24*60)}},
+		},
+		{
+			// 2022/03/13 02:00(EST) → 03:00(EDT) で1日が23時間になる
+			name:    "DST start",
+			loc:     newYork,
+			periods: []period{{time.Date(2022, 3, 13, 1, 0, 0, 0, newYork), time.Date(2022, 3, 13, 4, 0, 0, 0, newYork)}},
+			want:    CalendarBits{"2022/03/13": {"a": RangeBits(1*60, 4*60)}},
+		},
+		{
+			// 2022/11/06 02:00(EDT) → 01:00(EST) で1日が25時間になる
+			name:    "DST end",
+			loc:     newYork,
+			periods: []period{{time.Date(2022, 11, 5, 22, 0, 0, 0, newYork), time.Date(2022, 11, 7, 0, 0, 0, 0, newYork)}},
+			want: CalendarBits{
+				"2022/11/05": {"a": RangeBits(22*60, 24*60)},
+				"2022/11/06": {"a": FullDayBits},
+			},
+		},
+		{
+			name:    "FillEmpty",
+			loc:     tokyo,
+			periods: []period{{time.Date(2022, 4, 19, 10, 0, 0, 0, tokyo), time.Date(2022, 4, 19, 11, 0, 0, 0, tokyo)}},
+			from:    time.Date(2022, 4, 18, 0, 0, 0, 0, tokyo),
+			to:      time.Date(2022, 4, 20, 0, 0, 0, 0, tokyo),
+			want: CalendarBits{
+				"2022/04/18": {"a": 0, "b": 0},
+				"2022/04/19": {"a": RangeBits(10*60, 11*60), "b": 0},
+				"2022/04/20": {"a": 0, "b": 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fromEvents := NewCalendarBits()
+			fromPeriods := NewCalendarBits()
+			if !tt.from.IsZero() {
+				fromEvents.FillEmpty([]string{"a", "b"}, tt.from, tt.to, tt.loc)
+				fromPeriods.FillEmpty([]string{"a", "b"}, tt.from, tt.to, tt.loc)
+			}
+			for _, p := range tt.periods {
+				fromEvents.AddEvent(&Event{CalendarId: "a", StartDateTime: p.start, EndDateTime: p.end}, tt.loc)
+				period := &calendar.TimePeriod{
+					Start: p.start.UTC().Format(time.RFC3339),
+					End:   p.end.UTC().Format(time.RFC3339),
+				}
+				if err := fromPeriods.AddTimePeriod("a", period, tt.loc); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(fromEvents, fromPeriods) {
+				t.Errorf("AddEvent %v != AddTimePeriod %v", fromEvents, fromPeriods)
+			}
+			if !reflect.DeepEqual(fromEvents, tt.want) {
+				t.Errorf("got %v, want %v", fromEvents, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddTimePeriodInvalid(t *testing.T) {
+	c := NewCalendarBits()
+	for _, p := range []*calendar.TimePeriod{
+		{Start: "2022-04-18", End: "2022-04-18T10:00:00Z"},
+		{Start: "2022-04-18T09:00:00Z", End: "invalid"},
+	} {
+		if err := c.AddTimePeriod("a", p, time.UTC); err == nil {
+			t.Errorf("AddTimePeriod(%v) returned no error", p)
+		}
+	}
+	if len(c) != 0 {
+		t.Errorf("bits added on error: %v", c)
+	}
+}
+
+func TestRangeBits(t *testing.T) {
+	tests := []struct {
+		start, end int
+		want       uint64
+	}{
+		{8 * 60, 9 * 60, 0b11 << 16},
+		{8*60 + 30, 9*60 + 30, 0b11 << 17},
+		{8*60 + 15, 8*60 + 45, 0b11 << 16},
+		{0, 24 * 60, FullDayBits},
+		{-30, 25 * 60, FullDayBits},
+		{9 * 60, 9 * 60, 0},
+	}
+	for _, tt := range tests {
+		if got := RangeBits(tt.start, tt.end); got != tt.want {
+			t.Errorf("RangeBits(%d, %d) = %048b, want %048b", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
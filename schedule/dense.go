@@ -0,0 +1,117 @@
+package schedule
+
+import (
+	"time"
+)
+
+// DenseBits カレンダー × 日付のbitsを1つのスライスにまとめて持つ。
+//
+// CalendarBits（map[日付]map[カレンダーID]uint64）は日付・カレンダーごとにmapの確保とハッシュ計算が走るため、
+// 数百カレンダー × 60日のような規模では、カレンダー・日付をindexにした連続領域の方が速い。
+//
+// words の並び（カレンダーごとに日付が連続する）
+// [cal0/day0, cal0/day1, ..., cal0/dayN, cal1/day0, ...]
+type DenseBits struct {
+	CalendarIds []string
+	From        time.Time // 0日目（locの0時）
+	Days        int
+
+	index   map[string]int
+	fromDay int64 // Fromの1970/01/01からの日数
+	words   []uint64
+}
+
+// NewDenseBits calendarIds × from〜days日分の領域を確保する。すべて0（空き）で初期化される。
+func NewDenseBits(calendarIds []string, from time.Time, days int, loc *time.Location) *DenseBits {
+	index := make(map[string]int, len(calendarIds))
+	for i, id := range calendarIds {
+		index[id] = i
+	}
+	return &DenseBits{
+		CalendarIds: calendarIds,
+		From:        startOfDay(from.In(loc)),
+		Days:        days,
+		index:       index,
+		fromDay:     civilDay(from.In(loc)),
+		words:       make([]uint64, len(calendarIds)*days),
+	}
+}
+
+// Row カレンダーの日付ごとのbits（words の部分スライス）を返す。存在しないカレンダーはnil。
+func (d *DenseBits) Row(calendarId string) []uint64 {
+	c, ok := d.index[calendarId]
+	if !ok {
+		return nil
+	}
+	return d.words[c*d.Days : (c+1)*d.Days]
+}
+
+// DayIndex tの日付が何日目かを返す。
+// 夏時間で1日が24時間でない場合もずれないよう、年月日のみで差を取る。
+func (d *DenseBits) DayIndex(t time.Time) int {
+	return int(civilDay(t.In(d.From.Location())) - d.fromDay)
+}
+
+// AddBusy 予定ありの期間 [start, end) を日付ごとに分割し、maskの論理和で追加する。
+// 範囲外の日付・存在しないカレンダーは無視する。
+// 日付の分割はCalendarBits.AddBusyと共通（splitDays）のため、同じ期間からは同じbitsになる。
+func (d *DenseBits) AddBusy(calendarId string, start, end time.Time) {
+	row := d.Row(calendarId)
+	if row == nil {
+		return
+	}
+	splitDays(start, end, d.From.Location(), func(day int64, startMinutes, endMinutes int) {
+		i := int(day - d.fromDay)
+		if i < 0 || i >= d.Days {
+			return
+		}
+		if startMinutes == 0 && endMinutes == 24*60 {
+			row[i] = FullDayBits
+			return
+		}
+		row[i] |= RangeBits(startMinutes, endMinutes)
+	})
+}
+
+// AddEvent Eventの期間を追加する。
+func (d *DenseBits) AddEvent(event *Event) {
+	d.AddBusy(event.CalendarId, event.StartDateTime, event.EndDateTime)
+}
+
+// SetDay カレンダーのi日目のbitsを置き換える。
+func (d *DenseBits) SetDay(calendarId string, i int, bits uint64) {
+	if row := d.Row(calendarId); row != nil && i >= 0 && i < d.Days {
+		row[i] = bits
+	}
+}
+
+// DateBits 日付ごとに全カレンダーのbitsを論理積で集約する（誰か1人でも空いていれば0）。
+// CalendarBits.DateBitsと同じ結果を日付のindex順で返す。
+func (d *DenseBits) DateBits() []uint64 {
+	result := make([]uint64, d.Days)
+	for i := range result {
+		result[i] = FullDayBits
+	}
+	// カレンダーごとの行を順に読むことで、wordsを先頭から連続してアクセスする。
+	for c := range d.CalendarIds {
+		row := d.words[c*d.Days : (c+1)*d.Days]
+		for i, bits := range row {
+			result[i] &= bits
+		}
+	}
+	return result
+}
+
+// CalendarBits 既存の出力処理（FreeTimeSchedules）用にCalendarBitsに変換する。
+func (d *DenseBits) CalendarBits() CalendarBits {
+	c := make(CalendarBits, d.Days)
+	for i := 0; i < d.Days; i++ {
+		date := d.From.AddDate(0, 0, i).Format(FormatDate)
+		day := make(map[string]uint64, len(d.CalendarIds))
+		for n, id := range d.CalendarIds {
+			day[id] = d.words[n*d.Days+i]
+		}
+		c[date] = day
+	}
+	return c
+}
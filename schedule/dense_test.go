@@ -0,0 +1,154 @@
+package schedule
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// randomBusy 固定のseedでfrom〜days日分の予定ありの期間を作る。
+// 日をまたぐ予定・0時ちょうどに終わる予定・秒を含む予定・範囲外にはみ出す予定を含む。
+func randomBusy(r *rand.Rand, calendarIds []string, from time.Time, days, perCalendar int) []*Event {
+	events := make([]*Event, 0, len(calendarIds)*perCalendar)
+	for _, id := range calendarIds {
+		for i := 0; i < perCalendar; i++ {
+			// 範囲の前後1日にもはみ出すようにする。
+			start := from.AddDate(0, 0, r.Intn(days+2)-1).Add(time.Duration(r.Intn(24*60)) * time.Minute)
+			var end time.Time
+			switch r.Intn(4) {
+			case 0:
+				// 翌日以降の0時ちょうどに終わる
+				end = startOfDay(start).AddDate(0, 0, 1+r.Intn(2))
+			case 1:
+				// 数日にまたがる
+				end = start.Add(time.Duration(24*60+r.Intn(3*24*60)) * time.Minute)
+			case 2:
+				// 秒を含む
+				end = start.Add(time.Duration(r.Intn(4*60*60)+1) * time.Second)
+			default:
+				end = start.Add(time.Duration(15*(1+r.Intn(16))) * time.Minute)
+			}
+			events = append(events, &Event{CalendarId: id, StartDateTime: start, EndDateTime: end})
+		}
+	}
+	return events
+}
+
+func calendarIdsN(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("user%04d@example.com", i)
+	}
+	return ids
+}
+
+// TestDenseBitsMatchesCalendarBits 同じ予定からDenseBitsとCalendarBitsが同じbitsになることを確認する。
+func TestDenseBitsMatchesCalendarBits(t *testing.T) {
+	for _, name := range []string{"Asia/Tokyo", "America/New_York", "Europe/London"} {
+		t.Run(name, func(t *testing.T) {
+			loc, err := time.LoadLocation(name)
+			if err != nil {
+				t.Skip(err)
+			}
+			// 夏時間の切り替え（3月・10〜11月）を含む期間
+			from := time.Date(2022, 3, 1, 0, 0, 0, 0, loc)
+			const days = 250
+			ids := calendarIdsN(20)
+			events := randomBusy(rand.New(rand.NewSource(1)), ids, from, days, 200)
+
+			dense := NewDenseBits(ids, from, days, loc)
+			calendarBits := NewCalendarBits()
+			calendarBits.FillEmpty(ids, from, from.AddDate(0, 0, days-1), loc)
+			for _, e := range events {
+				dense.AddEvent(e)
+				calendarBits.AddEvent(e, loc)
+			}
+
+			got := dense.CalendarBits()
+			for i := 0; i < days; i++ {
+				date := from.AddDate(0, 0, i).Format(FormatDate)
+				for _, id := range ids {
+					if g, w := got[date][id], calendarBits[date][id]; g != w {
+						t.Errorf("%s %s: dense %048b, calendarBits %048b", date, id, g, w)
+					}
+				}
+			}
+
+			dateBits := calendarBits.DateBits()
+			for i, bits := range dense.DateBits() {
+				date := from.AddDate(0, 0, i).Format(FormatDate)
+				if bits != dateBits[date] {
+					t.Errorf("DateBits %s: dense %048b, calendarBits %048b", date, bits, dateBits[date])
+				}
+			}
+		})
+	}
+}
+
+func TestDenseBitsAddBusy(t *testing.T) {
+	loc := time.UTC
+	from := time.Date(2022, 4, 18, 0, 0, 0, 0, loc)
+	at := func(day, hour, minute int) time.Time { return time.Date(2022, 4, day, hour, minute, 0, 0, loc) }
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       []uint64
+	}{
+		{"within a day", at(18, 8, 0), at(18, 9, 0), []uint64{RangeBits(8*60, 9*60), 0, 0}},
+		{"across midnight", at(18, 22, 0), at(20, 2, 0), []uint64{RangeBits(22*60, 24*60), FullDayBits, RangeBits(0, 2*60)}},
+		{"ends at midnight", at(18, 23, 0), at(19, 0, 0), []uint64{RangeBits(23*60, 24*60), 0, 0}},
+		{"before range", at(16, 10, 0), at(18, 1, 0), []uint64{RangeBits(0, 60), 0, 0}},
+		{"after range", at(20, 23, 30), at(22, 0, 0), []uint64{0, 0, RangeBits(23*60+30, 24*60)}},
+		{"empty", at(18, 8, 0), at(18, 8, 0), []uint64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDenseBits([]string{"a"}, from, 3, loc)
+			d.AddBusy("a", tt.start, tt.end)
+			d.AddBusy("unknown", tt.start, tt.end)
+			for i, w := range tt.want {
+				if g := d.Row("a")[i]; g != w {
+					t.Errorf("day %d: got %048b, want %048b", i, g, w)
+				}
+			}
+		})
+	}
+}
+
+const benchmarkCalendars = 1000
+
+func benchmarkEvents(b *testing.B) ([]string, time.Time, []*Event, *time.Location) {
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		b.Skip(err)
+	}
+	from := time.Date(2022, 4, 1, 0, 0, 0, 0, loc)
+	ids := calendarIdsN(benchmarkCalendars)
+	return ids, from, randomBusy(rand.New(rand.NewSource(1)), ids, from, 60, 20), loc
+}
+
+func BenchmarkDenseBits_AddBusy(b *testing.B) {
+	ids, from, events, loc := benchmarkEvents(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		d := NewDenseBits(ids, from, 60, loc)
+		for _, e := range events {
+			d.AddBusy(e.CalendarId, e.StartDateTime, e.EndDateTime)
+		}
+	}
+}
+
+func BenchmarkCalendarBits_AddBusy(b *testing.B) {
+	_, _, events, loc := benchmarkEvents(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		c := NewCalendarBits()
+		for _, e := range events {
+			c.AddBusy(e.CalendarId, e.StartDateTime, e.EndDateTime, loc)
+		}
+	}
+}
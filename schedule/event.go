@@ -0,0 +1,66 @@
+package schedule
+
+import (
+	"google.golang.org/api/calendar/v3"
+	"time"
+)
+
+// BookingPropertyKey 予約経由で登録した予定に付与するextendedProperties.privateのキー
+// createevents で登録する予定には "booking": "true" が入る。
+const BookingPropertyKey = "booking"
+
+// Event calendarEventsからアプリ用に変換したもの
+// 期間は [StartDateTime, EndDateTime) で、終了時刻は含まない。
+type Event struct {
+	Id            string // 予定のID（繰り返しの予定は回ごとに異なる）
+	CalendarId    string
+	CalendarName  string
+	Title         string
+	IsAllDay      bool
+	IsBooking     bool // 予約経由で登録された予定か
+	IsTentative   bool // 仮の予定（status: tentative）か
+	StartDateTime time.Time
+	EndDateTime   time.Time
+}
+
+// NewEvent calendar.EventからEventに変換する。
+// 終日イベントはlocの開始日の0時〜終了日の0時とする。
+func NewEvent(id, name, title string, item *calendar.Event, loc *time.Location) (*Event, error) {
+	var isAllDay bool
+	sTime, eTime, err := timeParseRangeRFC3339(item.Start.DateTime, item.End.DateTime)
+	if err != nil {
+		// all-day（終日）イベントであればevent.Start.Dateに値が入る
+		// End.Dateは終了日の翌日（含まない）が入る。 ex: 4/18のみの終日イベント -> Start.Date: 2022-04-18, End.Date: 2022-04-19
+		// see: https://pkg.go.dev/google.golang.org/api/calendar/v3#EventDateTime
+		sTime, err = time.ParseInLocation("2006-01-02", item.Start.Date, loc)
+		if err != nil {
+			return nil, err
+		}
+		eTime, err = time.ParseInLocation("2006-01-02", item.End.Date, loc)
+		if err != nil {
+			return nil, err
+		}
+		isAllDay = true
+	}
+	return &Event{Id: item.Id, CalendarId: id, CalendarName: name, Title: title, IsAllDay: isAllDay, IsBooking: IsBookingItem(item), IsTentative: item.Status == "tentative", StartDateTime: sTime, EndDateTime: eTime}, nil
+}
+
+// IsBookingItem 予約経由で登録された予定かを判定する。
+func IsBookingItem(item *calendar.Event) bool {
+	if item.ExtendedProperties == nil {
+		return false
+	}
+	return item.ExtendedProperties.Private[BookingPropertyKey] == "true"
+}
+
+func timeParseRangeRFC3339(s, e string) (start, end time.Time, err error) {
+	start, err = time.Parse(time.RFC3339, s)
+	if err != nil {
+		return start, end, err
+	}
+	end, err = time.Parse(time.RFC3339, e)
+	if err != nil {
+		return start, end, err
+	}
+	return start, end, err
+}
@@ -0,0 +1,206 @@
+package schedule
+
+import (
+	"sort"
+	"time"
+)
+
+// Interval 期間 [Start, End)（Endは含まない）
+//
+// bitsは時間枠（30分）単位でしか表せないため、分単位の空き時間はIntervalsで計算する。
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Duration 期間の長さ
+func (i Interval) Duration() time.Duration {
+	return i.End.Sub(i.Start)
+}
+
+// IsEmpty 長さが0以下の期間か
+func (i Interval) IsEmpty() bool {
+	return !i.Start.Before(i.End)
+}
+
+// Intervals 期間の集合
+// Mergeした後は開始時刻順に並び、重なり・隣接がない状態になる。
+// 以下の集合演算はいずれもMerge済みの結果を返す。
+type Intervals []Interval
+
+// Merge 開始時刻順に並べ、重なっている・隣接している期間を1つにまとめる。
+//
+// ex: [10:00-12:00] [11:00-13:00] [13:00-14:00] [15:00-16:00]
+// -> [10:00-14:00] [15:00-16:00]
+func (iv Intervals) Merge() Intervals {
+	sorted := make(Intervals, 0, len(iv))
+	for _, i := range iv {
+		if !i.IsEmpty() {
+			sorted = append(sorted, i)
+		}
+	}
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Start.Before(sorted[b].Start) })
+
+	merged := make(Intervals, 0, len(sorted))
+	for _, i := range sorted {
+		last := len(merged) - 1
+		if last >= 0 && !i.Start.After(merged[last].End) {
+			if i.End.After(merged[last].End) {
+				merged[last].End = i.End
+			}
+			continue
+		}
+		merged = append(merged, i)
+	}
+	return merged
+}
+
+// Union 和集合
+func Union(a, b Intervals) Intervals {
+	all := make(Intervals, 0, len(a)+len(b))
+	all = append(all, a...)
+	all = append(all, b...)
+	return all.Merge()
+}
+
+// Intersect 積集合
+//
+// ex:
+// a: [10:00-12:00] [13:00-15:00]
+// b: [11:00-14:00]
+// -> [11:00-12:00] [13:00-14:00]
+func Intersect(a, b Intervals) Intervals {
+	a, b = a.Merge(), b.Merge()
+	result := make(Intervals, 0)
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		start := laterOf(a[i].Start, b[j].Start)
+		end := earlierOf(a[i].End, b[j].End)
+		if start.Before(end) {
+			result = append(result, Interval{Start: start, End: end})
+		}
+		// 先に終わる方を進める。
+		if a[i].End.Before(b[j].End) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// Subtract 差集合 a - b
+//
+// ex:
+// a: [10:00-15:00]
+// b: [11:00-12:00] [13:30-14:00]
+// -> [10:00-11:00] [12:00-13:30] [14:00-15:00]
+func Subtract(a, b Intervals) Intervals {
+	a, b = a.Merge(), b.Merge()
+	result := make(Intervals, 0, len(a))
+	j := 0
+	for _, i := range a {
+		start := i.Start
+		// aの開始より前に終わるbは以降も関係ないので読み飛ばす。
+		for j < len(b) && !b[j].End.After(start) {
+			j++
+		}
+		for k := j; k < len(b) && b[k].Start.Before(i.End); k++ {
+			if b[k].Start.After(start) {
+				result = append(result, Interval{Start: start, End: b[k].Start})
+			}
+			start = laterOf(start, b[k].End)
+		}
+		if start.Before(i.End) {
+			result = append(result, Interval{Start: start, End: i.End})
+		}
+	}
+	return result
+}
+
+// Complement window内で期間に含まれない部分（予定ありの期間であれば空き時間）を返す。
+func (iv Intervals) Complement(window Interval) Intervals {
+	return Subtract(Intervals{window}, iv)
+}
+
+// AtLeast d以上の長さがある期間のみ返す。
+func (iv Intervals) AtLeast(d time.Duration) Intervals {
+	result := make(Intervals, 0, len(iv))
+	for _, i := range iv.Merge() {
+		if i.Duration() >= d {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// Bits day（locの0時）の時間枠のうち、期間に重なる枠を1にしたbitsを返す。
+// CalendarBits.AddBusyと同じく、枠の一部でも重なれば予定ありとする。
+func (iv Intervals) Bits(day time.Time) uint64 {
+	day = startOfDay(day)
+	next := day.AddDate(0, 0, 1)
+	var bits uint64
+	for _, i := range iv {
+		s, e := laterOf(i.Start.In(day.Location()), day), earlierOf(i.End.In(day.Location()), next)
+		if !s.Before(e) {
+			continue
+		}
+		bits |= RangeBits(minuteOfDay(s, day, false), minuteOfDay(e, day, true))
+	}
+	return bits
+}
+
+// IntervalsFromBits day（locの0時）のbitsの1が連続する部分を期間に変換する。
+//
+// ex: 30分枠で 右から17〜19bit目が1 -> [08:00-09:30]
+func IntervalsFromBits(day time.Time, bits uint64) Intervals {
+	day = startOfDay(day)
+	result := make(Intervals, 0)
+	for slot := 0; slot < SlotsPerDay; {
+		if bits&(1<<uint(slot)) == 0 {
+			slot++
+			continue
+		}
+		start := slot
+		for slot < SlotsPerDay && bits&(1<<uint(slot)) != 0 {
+			slot++
+		}
+		// bitsは壁時計の時刻で枠を決めているため、夏時間の切り替え日も経過時間ではなく時刻で戻す。
+		result = append(result, Interval{
+			Start: wallClock(day, start*TimeFrameMinutes),
+			End:   wallClock(day, slot*TimeFrameMinutes),
+		})
+	}
+	return result
+}
+
+// FreeWindows window内で、誰か1人でも空いている期間を分単位で返す。
+// busyはカレンダーごとの予定ありの期間。busyが空の場合はwindow全体を返す。
+func FreeWindows(busy []Intervals, window Interval) Intervals {
+	if len(busy) == 0 {
+		return Intervals{window}.Merge()
+	}
+	free := make(Intervals, 0)
+	for _, b := range busy {
+		free = Union(free, b.Complement(window))
+	}
+	return free
+}
+
+// wallClock dayの0時からminutes分後の時刻（経過時間ではなく壁時計の時刻）
+func wallClock(day time.Time, minutes int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), 0, minutes, 0, 0, day.Location())
+}
+
+func laterOf(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func earlierOf(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
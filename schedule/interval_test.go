@@ -0,0 +1,292 @@
+package schedule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var tokyo = time.FixedZone("JST", 9*60*60)
+
+// at 2022/04/18 h:m (JST)。24時以降は翌日になる。
+func at(h, m int) time.Time {
+	return time.Date(2022, 4, 18, h, m, 0, 0, tokyo)
+}
+
+// span [sh:sm, eh:em)
+func span(sh, sm, eh, em int) Interval {
+	return Interval{Start: at(sh, sm), End: at(eh, em)}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Intervals
+		want Intervals
+	}{
+		{name: "nil", in: nil, want: Intervals{}},
+		{name: "empty intervals are dropped", in: Intervals{span(10, 0, 10, 0), span(11, 0, 10, 0)}, want: Intervals{}},
+		{name: "adjacent", in: Intervals{span(10, 0, 11, 0), span(11, 0, 12, 0)}, want: Intervals{span(10, 0, 12, 0)}},
+		{name: "overlapping", in: Intervals{span(10, 0, 12, 0), span(11, 0, 13, 0)}, want: Intervals{span(10, 0, 13, 0)}},
+		{name: "contained", in: Intervals{span(10, 0, 14, 0), span(11, 0, 12, 0)}, want: Intervals{span(10, 0, 14, 0)}},
+		{name: "unsorted", in: Intervals{span(15, 0, 16, 0), span(10, 0, 11, 0)}, want: Intervals{span(10, 0, 11, 0), span(15, 0, 16, 0)}},
+		{name: "across midnight", in: Intervals{span(22, 0, 25, 0), span(24, 30, 26, 0)}, want: Intervals{span(22, 0, 26, 0)}},
+		{
+			name: "example",
+			in:   Intervals{span(10, 0, 12, 0), span(11, 0, 13, 0), span(13, 0, 14, 0), span(15, 0, 16, 0)},
+			want: Intervals{span(10, 0, 14, 0), span(15, 0, 16, 0)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.in.Merge(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Merge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSetOperations Union / Intersect / Subtractを同じ入力で確認する。
+func TestSetOperations(t *testing.T) {
+	tests := []struct {
+		name          string
+		a, b          Intervals
+		union         Intervals
+		intersect     Intervals
+		subtract      Intervals // a - b
+		subtractOther Intervals // b - a
+	}{
+		{
+			name:          "both empty",
+			union:         Intervals{},
+			intersect:     Intervals{},
+			subtract:      Intervals{},
+			subtractOther: Intervals{},
+		},
+		{
+			name:          "one empty",
+			a:             Intervals{span(10, 0, 11, 0)},
+			union:         Intervals{span(10, 0, 11, 0)},
+			intersect:     Intervals{},
+			subtract:      Intervals{span(10, 0, 11, 0)},
+			subtractOther: Intervals{},
+		},
+		{
+			name:          "adjacent",
+			a:             Intervals{span(10, 0, 11, 0)},
+			b:             Intervals{span(11, 0, 12, 0)},
+			union:         Intervals{span(10, 0, 12, 0)},
+			intersect:     Intervals{},
+			subtract:      Intervals{span(10, 0, 11, 0)},
+			subtractOther: Intervals{span(11, 0, 12, 0)},
+		},
+		{
+			name:          "overlapping",
+			a:             Intervals{span(10, 0, 12, 0), span(13, 0, 15, 0)},
+			b:             Intervals{span(11, 0, 14, 0)},
+			union:         Intervals{span(10, 0, 15, 0)},
+			intersect:     Intervals{span(11, 0, 12, 0), span(13, 0, 14, 0)},
+			subtract:      Intervals{span(10, 0, 11, 0), span(14, 0, 15, 0)},
+			subtractOther: Intervals{span(12, 0, 13, 0)},
+		},
+		{
+			name:          "contained",
+			a:             Intervals{span(10, 0, 15, 0)},
+			b:             Intervals{span(11, 0, 12, 0), span(13, 30, 14, 0)},
+			union:         Intervals{span(10, 0, 15, 0)},
+			intersect:     Intervals{span(11, 0, 12, 0), span(13, 30, 14, 0)},
+			subtract:      Intervals{span(10, 0, 11, 0), span(12, 0, 13, 30), span(14, 0, 15, 0)},
+			subtractOther: Intervals{},
+		},
+		{
+			name:          "unmerged input",
+			a:             Intervals{span(11, 0, 12, 0), span(10, 0, 11, 30)},
+			b:             Intervals{span(10, 30, 10, 45), span(10, 40, 11, 0)},
+			union:         Intervals{span(10, 0, 12, 0)},
+			intersect:     Intervals{span(10, 30, 11, 0)},
+			subtract:      Intervals{span(10, 0, 10, 30), span(11, 0, 12, 0)},
+			subtractOther: Intervals{},
+		},
+		{
+			name:          "across midnight",
+			a:             Intervals{span(22, 0, 26, 0)},
+			b:             Intervals{span(23, 0, 24, 30)},
+			union:         Intervals{span(22, 0, 26, 0)},
+			intersect:     Intervals{span(23, 0, 24, 30)},
+			subtract:      Intervals{span(22, 0, 23, 0), span(24, 30, 26, 0)},
+			subtractOther: Intervals{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Union(tt.a, tt.b); !reflect.DeepEqual(got, tt.union) {
+				t.Errorf("Union() = %v, want %v", got, tt.union)
+			}
+			if got := Intersect(tt.a, tt.b); !reflect.DeepEqual(got, tt.intersect) {
+				t.Errorf("Intersect() = %v, want %v", got, tt.intersect)
+			}
+			if got := Intersect(tt.b, tt.a); !reflect.DeepEqual(got, tt.intersect) {
+				t.Errorf("Intersect(b, a) = %v, want %v", got, tt.intersect)
+			}
+			if got := Subtract(tt.a, tt.b); !reflect.DeepEqual(got, tt.subtract) {
+				t.Errorf("Subtract() = %v, want %v", got, tt.subtract)
+			}
+			if got := Subtract(tt.b, tt.a); !reflect.DeepEqual(got, tt.subtractOther) {
+				t.Errorf("Subtract(b, a) = %v, want %v", got, tt.subtractOther)
+			}
+		})
+	}
+}
+
+func TestComplement(t *testing.T) {
+	window := span(9, 0, 18, 0)
+	tests := []struct {
+		name string
+		busy Intervals
+		want Intervals
+	}{
+		{name: "no busy", busy: nil, want: Intervals{window}},
+		{name: "busy all day", busy: Intervals{span(0, 0, 24, 0)}, want: Intervals{}},
+		{name: "busy at the edges", busy: Intervals{span(8, 0, 9, 30), span(17, 45, 19, 0)}, want: Intervals{span(9, 30, 17, 45)}},
+		{name: "busy outside the window", busy: Intervals{span(7, 0, 9, 0), span(18, 0, 20, 0)}, want: Intervals{window}},
+		{name: "adjacent busy", busy: Intervals{span(10, 0, 11, 0), span(11, 0, 12, 0)}, want: Intervals{span(9, 0, 10, 0), span(12, 0, 18, 0)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.busy.Complement(window); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Complement() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFreeWindows(t *testing.T) {
+	window := span(9, 0, 18, 0)
+	tests := []struct {
+		name string
+		busy []Intervals
+		want Intervals
+	}{
+		{name: "no calendars", busy: nil, want: Intervals{window}},
+		{name: "calendar without busy", busy: []Intervals{nil}, want: Intervals{window}},
+		{name: "one calendar", busy: []Intervals{{span(10, 0, 12, 0)}}, want: Intervals{span(9, 0, 10, 0), span(12, 0, 18, 0)}},
+		{
+			// 誰か1人でも空いていればよい。
+			name: "any calendar is free",
+			busy: []Intervals{{span(9, 0, 12, 0)}, {span(12, 0, 18, 0)}},
+			want: Intervals{window},
+		},
+		{name: "everyone busy", busy: []Intervals{{span(9, 0, 18, 0)}, {span(8, 0, 19, 0)}}, want: Intervals{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FreeWindows(tt.busy, window); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FreeWindows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBitsAndIntervalsFromBits(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 2022/03/13 は夏時間の開始日（2:00 → 3:00）、2022/11/06 は終了日（2:00 → 1:00）
+	springForward := time.Date(2022, 3, 13, 0, 0, 0, 0, newYork)
+	fallBack := time.Date(2022, 11, 6, 0, 0, 0, 0, newYork)
+	ny := func(day time.Time, h, m int) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, newYork)
+	}
+	tests := []struct {
+		name      string
+		day       time.Time
+		intervals Intervals
+		bits      uint64
+		// IntervalsFromBits(day, bits)の結果（枠に丸めるため元の期間とは異なることがある）
+		fromBits Intervals
+	}{
+		{name: "empty", day: at(0, 0), bits: 0, fromBits: Intervals{}},
+		{
+			name:      "aligned",
+			day:       at(0, 0),
+			intervals: Intervals{span(8, 0, 9, 30)},
+			bits:      RangeBits(8*60, 9*60+30),
+			fromBits:  Intervals{span(8, 0, 9, 30)},
+		},
+		{
+			name:      "partial slots are busy",
+			day:       at(0, 0),
+			intervals: Intervals{span(10, 15, 10, 45)},
+			bits:      RangeBits(10*60, 11*60),
+			fromBits:  Intervals{span(10, 0, 11, 0)},
+		},
+		{
+			name:      "adjacent intervals",
+			day:       at(0, 0),
+			intervals: Intervals{span(10, 0, 11, 0), span(11, 0, 11, 30)},
+			bits:      RangeBits(10*60, 11*60+30),
+			fromBits:  Intervals{span(10, 0, 11, 30)},
+		},
+		{
+			name:      "separate intervals",
+			day:       at(0, 0),
+			intervals: Intervals{span(9, 0, 10, 0), span(13, 0, 14, 0)},
+			bits:      RangeBits(9*60, 10*60) | RangeBits(13*60, 14*60),
+			fromBits:  Intervals{span(9, 0, 10, 0), span(13, 0, 14, 0)},
+		},
+		{
+			name:      "across midnight is clipped to the day",
+			day:       at(0, 0),
+			intervals: Intervals{span(-2, 0, 1, 0), span(23, 0, 26, 0)},
+			bits:      RangeBits(0, 60) | RangeBits(23*60, 24*60),
+			fromBits:  Intervals{span(0, 0, 1, 0), span(23, 0, 24, 0)},
+		},
+		{
+			name:      "whole day",
+			day:       at(0, 0),
+			intervals: Intervals{span(0, 0, 24, 0)},
+			bits:      FullDayBits,
+			fromBits:  Intervals{span(0, 0, 24, 0)},
+		},
+		{
+			name:      "other location",
+			day:       at(0, 0),
+			intervals: Intervals{{Start: time.Date(2022, 4, 18, 0, 0, 0, 0, time.UTC), End: time.Date(2022, 4, 18, 1, 0, 0, 0, time.UTC)}},
+			bits:      RangeBits(9*60, 10*60),
+			fromBits:  Intervals{span(9, 0, 10, 0)},
+		},
+		{
+			// bitsは壁時計の時刻で枠を決める。
+			name:      "spring forward",
+			day:       springForward,
+			intervals: Intervals{{Start: ny(springForward, 9, 0), End: ny(springForward, 10, 0)}},
+			bits:      RangeBits(9*60, 10*60),
+			fromBits:  Intervals{{Start: ny(springForward, 9, 0), End: ny(springForward, 10, 0)}},
+		},
+		{
+			name:      "fall back",
+			day:       fallBack,
+			intervals: Intervals{{Start: ny(fallBack, 9, 0), End: ny(fallBack, 10, 0)}},
+			bits:      RangeBits(9*60, 10*60),
+			fromBits:  Intervals{{Start: ny(fallBack, 9, 0), End: ny(fallBack, 10, 0)}},
+		},
+		{
+			name:      "whole day on spring forward",
+			day:       springForward,
+			intervals: Intervals{{Start: springForward, End: springForward.AddDate(0, 0, 1)}},
+			bits:      FullDayBits,
+			fromBits:  Intervals{{Start: springForward, End: springForward.AddDate(0, 0, 1)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.intervals.Bits(tt.day); got != tt.bits {
+				t.Errorf("Bits() = %048b, want %048b", got, tt.bits)
+			}
+			if got := IntervalsFromBits(tt.day, tt.bits); !reflect.DeepEqual(got, tt.fromBits) {
+				t.Errorf("IntervalsFromBits() = %v, want %v", got, tt.fromBits)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"google-calendar-sample/auth"
+	"google-calendar-sample/gcal"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+	"log"
+	"net/http"
+	"time"
+)
+
+// sample calendar ids
+var calendarIds = []string{
+	"kg090637fo0f1lg5s3ham2bhk8@group.calendar.google.com",
+	"0lqtb45e5rpi3jmvjs4kcrrh94@group.calendar.google.com",
+	"7j4hmerqr14ptp98p6b5p3io2k@group.calendar.google.com",
+}
+
+// カレンダーの予定をローカルのミラー（-dir）に同期する。
+// 2回目以降はsyncTokenで前回からの変更（削除を含む）のみを取得する。
+//
+// -listen を指定した場合は、プッシュ通知（Events.Watch）を受けて変更があったカレンダーのみ同期する。
+// 通知チャンネルは期限の前に作り直す。
+//
+// go run ./syncevents [-dir mirror] [-interval 5m] [calendarId ...]
+// go run ./syncevents -listen :8080 -webhook-url https://example.com/notifications [calendarId ...]
+func main() {
+	dir := flag.String("dir", "mirror", "directory of the local event mirror")
+	interval := flag.Duration("interval", 0, "sync repeatedly at this interval (0 syncs once)")
+	listen := flag.String("listen", "", "receive push notifications at /notifications on this address (ex: :8080)")
+	webhookURL := flag.String("webhook-url", "", "public HTTPS URL that Google sends push notifications to")
+	resolver := auth.NewResolver()
+	resolver.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	ids := calendarIds
+	if flag.NArg() > 0 {
+		ids = flag.Args()
+	}
+
+	ctx := context.Background()
+	c, err := resolver.Credentials(ctx, auth.ScopesFor(auth.OpEventsReadonly)...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	calendarService, err := calendar.NewService(ctx, option.WithCredentials(c))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	syncer := gcal.NewSyncer(calendarService, &gcal.FileEventStore{Dir: *dir})
+	syncAndLog := func(ctx context.Context, id string) {
+		result, err := syncer.Sync(ctx, id)
+		if err != nil {
+			log.Printf("sync %s: %v", id, err)
+			return
+		}
+		log.Printf("sync %s: full=%v updated=%d deleted=%d", id, result.Full, result.Updated, result.Deleted)
+	}
+
+	if *listen != "" {
+		if *webhookURL == "" {
+			log.Fatal("-webhook-url is required with -listen")
+		}
+		manager := gcal.NewWatchManager(&gcal.ServiceChannelAPI{Service: calendarService}, *webhookURL)
+		http.Handle("/notifications", &gcal.WebhookHandler{Manager: manager, OnChange: syncAndLog})
+		go func() {
+			log.Printf("listening on %s", *listen)
+			log.Fatal(http.ListenAndServe(*listen, nil))
+		}()
+		// 通知を受ける前にミラーとsyncTokenを作っておく。
+		for _, id := range ids {
+			syncAndLog(ctx, id)
+			if _, err := manager.Watch(ctx, id); err != nil {
+				log.Printf("%v", err)
+			}
+		}
+		manager.Run(ctx, 0)
+		return
+	}
+
+	for {
+		for _, id := range ids {
+			syncAndLog(ctx, id)
+		}
+		if *interval <= 0 {
+			return
+		}
+		time.Sleep(*interval)
+	}
+}